@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+)
+
+func runConfig(path string, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: digger config check")
+	}
+
+	path, err := config.Locate(path)
+	if err != nil {
+		return err
+	}
+
+	n, err := checkConfig(os.Stdout, path)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%s has %d problems", path, n)
+	}
+	return nil
+}
+
+// checkConfig prints every problem in the config file with its YAML path
+// and returns how many there were. When the settings are valid the
+// notification backends are built too, which loads their templates and
+// certificates.
+func checkConfig(out io.Writer, path string) (int, error) {
+	cfg, problems, err := config.Check(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if len(problems) == 0 {
+		_, err = newDispatcher(cfg, nil)
+		if err != nil {
+			problems = append(problems, config.Problem{Path: "notifiers", Message: err.Error()})
+		}
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(out, "%s: %s\n", path, p)
+	}
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "%s: ok\n", path)
+	}
+	return len(problems), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/report"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"go.etcd.io/bbolt"
+)
+
+// decisions prints what the notification policy decided for each event and
+// why.
+func decisions(db *bbolt.DB, args []string) error {
+	fs := flag.NewFlagSet("decisions", flag.ExitOnError)
+	since := fs.String("since", "168h", "Only show decisions at or after this time (RFC3339, YYYY-MM-DD or a duration such as 24h)")
+	site := fs.String("site", "", "Only show decisions for this hostname")
+	fs.Parse(args)
+
+	t, err := report.ParseTime(*since, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid -since value: %w", err)
+	}
+
+	return db.View(func(tx *bbolt.Tx) error {
+		list, err := store.ListDecisions(tx, t)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tHOSTNAME\tKIND\tACTION\tEVENT\tREASON")
+		for _, d := range list {
+			if *site != "" && d.Hostname != *site {
+				continue
+			}
+
+			action := d.Action
+			if d.Released {
+				action += " (released)"
+			}
+			id := d.EventID
+			if id == "" {
+				id = "-"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				d.Time.Format(time.RFC3339), d.Hostname, d.Kind, action, id, d.Reason)
+		}
+
+		return w.Flush()
+	})
+}
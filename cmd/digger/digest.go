@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/digest"
+	"github.com/bytetwiddler/digger/pkg/site"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// runDigest implements the digest subcommand. The inventory is read from
+// sitesPath.
+func runDigest(cfg *config.Config, db *bbolt.DB, sitesPath string, args []string) error {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	force := fs.Bool("force", false, "Send the digest even if the period has not ended")
+	fs.Parse(args)
+
+	return sendDigest(cfg, db, sitesPath, *force)
+}
+
+func sendDigest(cfg *config.Config, db *bbolt.DB, sitesPath string, force bool) error {
+	period := cfg.Digest.Period
+	now := time.Now()
+	from, due, err := digest.Period(db, period, now)
+	if err != nil {
+		return err
+	}
+	if !due && !force {
+		logrus.Debugf("Digest not due until %s", from.Add(period).Format(time.RFC3339))
+		return nil
+	}
+
+	var current site.Sites
+	err = current.ReadFromDB(db)
+	if err != nil {
+		return fmt.Errorf("failed to read from database: %w", err)
+	}
+
+	var inventory site.Sites
+	err = inventory.ReadFromCSV(sitesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read from csv: %w", err)
+	}
+
+	data, err := digest.Build(db, reportSites(current), reportSites(inventory), from, now)
+	if err != nil {
+		return err
+	}
+
+	htmlPath := cfg.Digest.TemplatePath
+	if htmlPath == "" {
+		htmlPath = filepath.Join("templates", "digest.html")
+	}
+	textPath := cfg.Digest.TextTemplatePath
+	if textPath == "" {
+		textPath = filepath.Join("templates", "digest.txt")
+	}
+
+	logrus.Infof("Sending digest for %s to %s to %s", from.Format(time.RFC3339), now.Format(time.RFC3339), strings.Join(cfg.SMTP.To, ", "))
+	return digest.Send(cfg, db, htmlPath, textPath, data)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/site"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/sirupsen/logrus"
+)
+
+// dryRun resolves every site and detects changes like a normal run, but
+// against a scratch copy of the database. Notifications are rendered to
+// stdout, or to files in dir, instead of being sent, and the inventory at
+// sitesPath is left alone.
+func dryRun(cfg *config.Config, sitesPath string, update bool, dir string) error {
+	var sites site.Sites
+	err := sites.ReadFromCSV(sitesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read from csv: %w", err)
+	}
+
+	db, cleanup, err := store.OpenCopy(cfg.DB.Path)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	previews, err := notification.NewPreviewWriter(os.Stdout, dir)
+	if err != nil {
+		return err
+	}
+
+	dispatcher, err := newDispatcher(cfg, db)
+	if err != nil {
+		return fmt.Errorf("failed to set up notifiers: %w", err)
+	}
+	dispatcher.Notifiers = notification.DryRun(dispatcher.Notifiers, previews)
+
+	err = sites.UpdateIPs(cfg, db, dispatcher, update)
+	if err != nil {
+		return fmt.Errorf("failed to update IPs: %w", err)
+	}
+
+	// Queued notifications from earlier runs would go out too
+	err = deliverOutbox(cfg, db, dispatcher)
+	if err != nil {
+		logrus.Errorf("failed to deliver queued notifications: %v", err)
+	}
+
+	written := ""
+	if update {
+		written = sitesPath
+	}
+	return dryRunSummary(os.Stdout, sites, previews.Count(), written)
+}
+
+// dryRunSummary lists the changes a real run would have made, and that
+// -update would write them to sitesPath when it is set.
+func dryRunSummary(out io.Writer, sites site.Sites, notifications int, sitesPath string) error {
+	var changed []site.Site
+	for _, s := range sites {
+		if s.Changed {
+			changed = append(changed, s)
+		}
+	}
+
+	fmt.Fprintf(out, "Dry run: %d of %d sites changed, %d notifications rendered, nothing was sent or saved\n",
+		len(changed), len(sites), notifications)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOSTNAME\tPORT\tENTITY\tOLD IP\tNEW IP")
+	for _, s := range changed {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", s.Hostname, s.Port, s.EntityName, s.OldIP, s.NewIP)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if sitesPath != "" {
+		fmt.Fprintf(out, "With -update these addresses would be written to %s\n", sitesPath)
+	}
+	return nil
+}
@@ -2,24 +2,39 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
+	"os"
+	"time"
 
 	"github.com/bytetwiddler/digger/pkg/config"
 	"github.com/bytetwiddler/digger/pkg/logging"
 	"github.com/bytetwiddler/digger/pkg/site"
+	"github.com/bytetwiddler/digger/pkg/store"
 	"github.com/sirupsen/logrus"
-	"go.etcd.io/bbolt"
 )
 
 func main() {
 	// Define the report and update flags
+	configPath := flag.String("config", "", "Config file to use instead of config.yaml in the working directory or next to the executable")
 	report := flag.Bool("report", false, "Report changes from the database")
 	update := flag.Bool("update", false, "Update IP addresses in the CSV file")
+	sitesPath := flag.String("sites", "sites.csv", "Inventory CSV file to read, and to write with -update")
+	dryRunFlag := flag.Bool("dry-run", false, "Detect changes and render notifications without sending them or saving anything")
+	dryRunDir := flag.String("dry-run-dir", "", "With -dry-run, write each rendered notification to a file in this directory instead of stdout")
+	reportOpts := addReportFlags(flag.CommandLine)
 	flag.Parse()
 
+	// Checking the config has to work when it would not load
+	if flag.Arg(0) == "config" {
+		err := runConfig(*configPath, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Load configuration
-	cfg, err := config.LoadConfig("config.yaml")
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		log.Fatalf("failed to load config: %v", err)
 	}
@@ -31,36 +46,85 @@ func main() {
 	}
 	defer file.Close()
 
-	// Open the bbolt database
-	db, err := bbolt.Open(cfg.DB.Path, 0o600, nil)
+	logrus.Infof("digger operation started with config %s", cfg.Path)
+
+	// Compaction needs the database to itself, so run it before opening
+	if flag.Arg(0) == "compact" {
+		err = compactDB(cfg)
+		if err != nil {
+			logrus.Fatalf("failed to compact database: %v", err)
+		}
+		return
+	}
+
+	// A dry run works on a copy of the database and never writes sites.csv
+	if *dryRunFlag {
+		err = dryRun(cfg, *sitesPath, *update, *dryRunDir)
+		if err != nil {
+			logrus.Fatalf("failed dry run: %v", err)
+		}
+		return
+	}
+
+	// Open the bbolt database, migrating it to the current schema
+	db, err := store.Open(cfg.DB.Path)
 	if err != nil {
 		logrus.Fatal(err)
 	}
 	defer db.Close()
 
-	logrus.Info("digger operation started")
+	if flag.Arg(0) == "report" {
+		err = runReport(cfg, db, flag.Args()[1:])
+		if err != nil {
+			logrus.Fatalf("failed to report changes: %v", err)
+		}
+		return
+	}
 
-	// Ensure the buckets are created before reading from them
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("sites"))
+	if flag.Arg(0) == "stats" {
+		err = runStats(db, flag.Args()[1:])
 		if err != nil {
-			return fmt.Errorf("failed creating db sites: %w", err)
+			logrus.Fatalf("failed to compute statistics: %v", err)
 		}
+		return
+	}
 
-		_, err = tx.CreateBucketIfNotExists([]byte("changes"))
+	if flag.Arg(0) == "digest" {
+		err = runDigest(cfg, db, *sitesPath, flag.Args()[1:])
 		if err != nil {
-			return fmt.Errorf("failed creating db changes: %w", err)
+			logrus.Fatalf("failed to send digest: %v", err)
 		}
-		return nil
-	})
-	if err != nil {
-		logrus.Fatalf("failed to create buckets: %v", err)
+		return
+	}
+
+	if flag.Arg(0) == "outbox" {
+		err = runOutbox(cfg, db, flag.Args()[1:])
+		if err != nil {
+			logrus.Fatalf("failed to manage outbox: %v", err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "decisions" {
+		err = decisions(db, flag.Args()[1:])
+		if err != nil {
+			logrus.Fatalf("failed to list notification decisions: %v", err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "history" {
+		err = history(db, flag.Args()[1:])
+		if err != nil {
+			logrus.Fatalf("failed to read lookup history: %v", err)
+		}
+		return
 	}
 
 	var sites site.Sites
 
 	// Read sites from CSV
-	err = sites.ReadFromCSV("sites.csv")
+	err = sites.ReadFromCSV(*sitesPath)
 	if err != nil {
 		logrus.Fatalf("failed to read from csv: %v", err)
 	}
@@ -79,7 +143,12 @@ func main() {
 		}
 		logrus.Infof("Total number of records: %d", count)
 
-		err = sites.ReportChanges(db)
+		filter, err := reportOpts.filter(time.Now())
+		if err != nil {
+			logrus.Fatalf("invalid report filter: %v", err)
+		}
+
+		err = sites.ReportChanges(db, os.Stdout, filter, reportOpts.format)
 		if err != nil {
 			logrus.Fatalf("failed to report changes: %v", err)
 		}
@@ -88,21 +157,41 @@ func main() {
 		return
 	}
 
+	// Build the configured notification backends
+	dispatcher, err := newDispatcher(cfg, db)
+	if err != nil {
+		logrus.Fatalf("failed to set up notifiers: %v", err)
+	}
+
 	// Update IPs and log changes
-	err = sites.UpdateIPs(cfg, db, *update)
+	err = sites.UpdateIPs(cfg, db, dispatcher, *update)
 	if err != nil {
 		logrus.Fatalf("failed to update IPs: %v", err)
 	}
 
+	// Retry notifications that failed on earlier runs
+	err = deliverOutbox(cfg, db, dispatcher)
+	if err != nil {
+		logrus.Errorf("failed to deliver queued notifications: %v", err)
+	}
+
 	// Write sites to the database
 	err = sites.WriteToDB(db)
 	if err != nil {
 		logrus.Fatalf("failed to write to db: %v", err)
 	}
 
+	// Thin out old lookup observations
+	removed, err := store.ApplyRetention(db, retentionPolicy(cfg), time.Now())
+	if err != nil {
+		logrus.Errorf("failed to apply observation retention: %v", err)
+	} else if removed > 0 {
+		logrus.Infof("Removed %d observations past retention", removed)
+	}
+
 	// If the update flag is set, update the CSV file with the new IPs
 	if *update {
-		err = sites.WriteToCSV("sites.csv")
+		err = sites.WriteToCSV(*sitesPath)
 		if err != nil {
 			logrus.Fatalf("failed to write to csv: %v", err)
 		}
@@ -110,5 +199,13 @@ func main() {
 		logrus.Info("CSV file updated successfully")
 	}
 
+	// Send the periodic digest if one is due
+	if cfg.Digest.Enabled {
+		err = sendDigest(cfg, db, *sitesPath, false)
+		if err != nil {
+			logrus.Errorf("failed to send digest: %v", err)
+		}
+	}
+
 	logrus.Info("digger operation completed successfully")
 }
@@ -2,9 +2,13 @@
 package main
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/bytetwiddler/digger/pkg/event"
 	"github.com/bytetwiddler/digger/pkg/site"
 	"github.com/stretchr/testify/assert"
 )
@@ -53,3 +57,42 @@ example.com,80,example_entity,1.2.3.4
 	assert.Equal(t, "example.com", sites[0].Hostname)
 	assert.NotEqual(t, "1.2.3.5", sites[0].IP)
 }
+
+func TestCheckConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+log:
+  level: verbose
+smtp:
+  host: localhost
+  form: digger@example.com
+`), 0644)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	n, err := checkConfig(&out, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Contains(t, out.String(), path+": smtp.form: unknown setting\n")
+	assert.Contains(t, out.String(), path+": log.level: unknown level \"verbose\"")
+	assert.Contains(t, out.String(), path+": smtp.to: at least one recipient is required\n")
+
+	err = os.WriteFile(path, []byte("smtp:\n  host: localhost\n  from: digger@example.com\n  to: noc@example.com\n"), 0644)
+	assert.NoError(t, err)
+	out.Reset()
+	n, err = checkConfig(&out, path)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, path+": ok\n", out.String())
+}
+
+func TestReportKindFilter(t *testing.T) {
+	opts := &reportOptions{kind: "unreachable"}
+	filter, err := opts.filter(time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, event.KindUnreachable, filter.Kind)
+
+	opts.kind = "ip_change"
+	_, err = opts.filter(time.Now())
+	assert.EqualError(t, err, `unknown kind "ip_change", expected one of ip_changed, lookup_failed, unreachable`)
+}
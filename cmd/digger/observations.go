@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"go.etcd.io/bbolt"
+)
+
+// history prints what a site resolved to at a point in time.
+func history(db *bbolt.DB, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	site := fs.String("site", "", "Hostname to look up")
+	at := fs.String("at", "", "Point in time (RFC3339), defaults to now")
+	fs.Parse(args)
+
+	if *site == "" {
+		return fmt.Errorf("-site is required")
+	}
+
+	t := time.Now()
+	if *at != "" {
+		var err error
+		t, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("invalid -at value: %w", err)
+		}
+	}
+
+	return db.View(func(tx *bbolt.Tx) error {
+		o, err := store.ObservationAt(tx, *site, t)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			fmt.Printf("Site: %s, no observation at or before %s\n", *site, t.Format(time.RFC3339))
+			return nil
+		}
+
+		answer := strings.Join(o.Answers, ";")
+		if o.Error != "" {
+			answer = "error: " + o.Error
+		}
+
+		fmt.Printf("Site: %s, Observed: %s, Answer: %s, Resolver: %s, Latency: %s\n",
+			o.Hostname, o.Time.Format(time.RFC3339), answer, o.Resolver, o.Latency)
+		return nil
+	})
+}
+
+func compactDB(cfg *config.Config) error {
+	before, after, err := store.Compact(cfg.DB.Path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Compacted %s from %d to %d bytes\n", cfg.DB.Path, before, after)
+	return nil
+}
+
+func retentionPolicy(cfg *config.Config) store.RetentionPolicy {
+	r := cfg.DB.Retention
+	if r.Raw == 0 && r.Hourly == 0 && r.Daily == 0 {
+		return store.DefaultRetention
+	}
+
+	return store.RetentionPolicy{
+		Raw:    r.Raw,
+		Hourly: r.Hourly,
+		Daily:  r.Daily,
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/outbox"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// runOutbox implements the outbox list, deliver, retry and purge
+// subcommands.
+func runOutbox(cfg *config.Config, db *bbolt.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: digger outbox list|deliver|retry|purge")
+	}
+
+	fs := flag.NewFlagSet("outbox "+args[0], flag.ExitOnError)
+	id := fs.String("id", "", "Only act on this event ID or entry key")
+	all := fs.Bool("all", false, "Purge entries that are still being retried, not just dead ones")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "list":
+		return listOutbox(db)
+
+	case "deliver":
+		return deliverOutbox(cfg, db, nil)
+
+	case "retry":
+		n, err := outbox.Retry(db, *id, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Scheduled %d outbox entries for retry\n", n)
+		return deliverOutbox(cfg, db, nil)
+
+	case "purge":
+		n, err := outbox.Purge(db, *id, *all)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d outbox entries\n", n)
+		return nil
+	}
+
+	return fmt.Errorf("unknown outbox command %q, expected list, deliver, retry or purge", args[0])
+}
+
+func listOutbox(db *bbolt.DB) error {
+	entries, err := store.ListOutbox(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "EVENT\tBACKEND\tHOSTNAME\tQUEUED\tATTEMPTS\tNEXT ATTEMPT\tSTATE\tLAST ERROR")
+	for _, e := range entries {
+		state, next := "pending", e.NextAttempt.Format(time.RFC3339)
+		if e.Dead {
+			state, next = "dead", "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.EventID, e.Backend, e.Hostname, e.Created.Format(time.RFC3339), e.Attempts, next, state, e.LastError)
+	}
+
+	return w.Flush()
+}
+
+// deliverOutbox retries due outbox entries and prints when the next one is
+// due. It builds the notifiers from cfg unless a dispatcher is passed in.
+func deliverOutbox(cfg *config.Config, db *bbolt.DB, d *notification.Dispatcher) error {
+	if d == nil {
+		var err error
+		d, err = newDispatcher(cfg, db)
+		if err != nil {
+			return fmt.Errorf("failed to set up notifiers: %w", err)
+		}
+	}
+
+	delivered, pending, err := outbox.Deliver(context.Background(), db, d, outbox.NewPolicy(cfg), time.Now())
+	if err != nil {
+		return err
+	}
+	if delivered > 0 || pending > 0 {
+		logrus.Infof("Outbox: delivered %d queued notifications, %d still pending", delivered, pending)
+	}
+
+	next, err := outbox.NextAttempt(db)
+	if err != nil {
+		return err
+	}
+	if !next.IsZero() {
+		fmt.Printf("Next outbox attempt at %s\n", next.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// newDispatcher builds the configured notifiers and routes.
+func newDispatcher(cfg *config.Config, db *bbolt.DB) (*notification.Dispatcher, error) {
+	notifiers, err := notification.Build(cfg, notificationEnv(db))
+	if err != nil {
+		return nil, err
+	}
+	routes, err := notification.NewRoutes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	d := notification.NewDispatcher(notifiers)
+	d.Routes = routes
+	return d, nil
+}
+
+func notificationEnv(db *bbolt.DB) notification.Env {
+	return notification.Env{
+		LastTicket: func(backend, siteID string) (string, error) {
+			return store.LastTicket(db, backend, siteID)
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/report"
+	"github.com/bytetwiddler/digger/pkg/site"
+	"github.com/bytetwiddler/digger/pkg/stats"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// runReport implements the report subcommand. Without -html it prints the
+// same report as the -report flag.
+func runReport(cfg *config.Config, db *bbolt.DB, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	opts := addReportFlags(fs)
+	htmlPath := fs.String("html", "", "Write a self-contained HTML report to this file")
+	fs.Parse(args)
+
+	now := time.Now()
+	filter, err := opts.filter(now)
+	if err != nil {
+		return fmt.Errorf("invalid report filter: %w", err)
+	}
+
+	var sites site.Sites
+	err = sites.ReadFromDB(db)
+	if err != nil {
+		return fmt.Errorf("failed to read from database: %w", err)
+	}
+
+	if *htmlPath == "" {
+		return sites.ReportChanges(db, os.Stdout, filter, opts.format)
+	}
+
+	events, err := store.ListEvents(db)
+	if err != nil {
+		return err
+	}
+
+	data := report.BuildHTMLData(reportSites(sites), report.Select(events, filter), filter, now)
+
+	file, err := os.Create(*htmlPath)
+	if err != nil {
+		return fmt.Errorf("failed to create html report: %w", err)
+	}
+	defer file.Close()
+
+	err = report.WriteHTML(file, reportTemplatePath(cfg), data)
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("HTML report written to %s", *htmlPath)
+	return nil
+}
+
+// runStats implements the stats subcommand. It takes the same filters as the
+// report, with -format table or json.
+func runStats(db *bbolt.DB, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	opts := addReportFlags(fs)
+	fs.Parse(args)
+
+	now := time.Now()
+	filter, err := opts.filter(now)
+	if err != nil {
+		return fmt.Errorf("invalid stats filter: %w", err)
+	}
+
+	events, err := store.ListEvents(db)
+	if err != nil {
+		return err
+	}
+
+	return stats.Write(os.Stdout, stats.Compute(report.Select(events, filter), now), opts.format)
+}
+
+func reportSites(sites site.Sites) []report.Site {
+	out := make([]report.Site, 0, len(sites))
+	for _, s := range sites {
+		out = append(out, report.Site{
+			Hostname:   s.Hostname,
+			Port:       s.Port,
+			EntityName: s.EntityName,
+			IPs:        s.CurrentIPs(),
+		})
+	}
+	return out
+}
+
+func reportTemplatePath(cfg *config.Config) string {
+	if cfg.Report.TemplatePath != "" {
+		return cfg.Report.TemplatePath
+	}
+	return filepath.Join("templates", "report.html")
+}
+
+type reportOptions struct {
+	since  string
+	until  string
+	site   string
+	entity string
+	kind   string
+	format string
+}
+
+func addReportFlags(fs *flag.FlagSet) *reportOptions {
+	o := &reportOptions{}
+	fs.StringVar(&o.since, "since", "", "Only report changes at or after this time (RFC3339, YYYY-MM-DD or a duration such as 168h)")
+	fs.StringVar(&o.until, "until", "", "Only report changes before this time (RFC3339, YYYY-MM-DD or a duration)")
+	fs.StringVar(&o.site, "site", "", "Only report changes for this hostname")
+	fs.StringVar(&o.entity, "entity", "", "Only report changes for this entity name")
+	fs.StringVar(&o.kind, "kind", "", "Only report changes of this kind: "+strings.Join(kindNames(), ", "))
+	fs.StringVar(&o.format, "format", report.FormatTable, "Report format: "+strings.Join(report.Formats, ", "))
+	return o
+}
+
+func (o *reportOptions) filter(now time.Time) (report.Filter, error) {
+	since, err := report.ParseTime(o.since, now)
+	if err != nil {
+		return report.Filter{}, err
+	}
+
+	until, err := report.ParseTime(o.until, now)
+	if err != nil {
+		return report.Filter{}, err
+	}
+
+	if o.kind != "" && !slices.Contains(kindNames(), o.kind) {
+		return report.Filter{}, fmt.Errorf("unknown kind %q, expected one of %s", o.kind, strings.Join(kindNames(), ", "))
+	}
+
+	return report.Filter{
+		Since:  since,
+		Until:  until,
+		Site:   o.site,
+		Entity: o.entity,
+		Kind:   event.Kind(o.kind),
+	}, nil
+}
+
+func kindNames() []string {
+	names := make([]string, len(event.Kinds))
+	for i, k := range event.Kinds {
+		names[i] = string(k)
+	}
+	return names
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/site"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// reloader holds the config the service runs with. Edits to the config
+// file only take effect once they validate: the file is then copied to
+// the snapshot that digger is run with, so a bad edit leaves both the
+// service and digger on the previous settings. The inventory is handled
+// the same way, service.sites_path becoming its .applied copy.
+type reloader struct {
+	source        string
+	snapshot      string
+	sites         string
+	sitesSnapshot string
+	current       atomic.Pointer[config.Config]
+}
+
+// newReloader loads the same config file digger would use, and fails
+// unless service.digger_path names a file. A service starts in the system
+// directory, so the working directory moves to the config file's
+// directory, where relative paths in it are resolved. The inventory is
+// taken from service.sites_path.
+func newReloader(path string) (*reloader, error) {
+	file, err := config.Locate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(file)
+	err = os.Chdir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change to config directory: %w", err)
+	}
+
+	r := &reloader{
+		source:   file,
+		snapshot: snapshotPath(file),
+	}
+	cfg, err := r.reload()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	r.sites = cfg.Service.SitesPath
+	if !filepath.IsAbs(r.sites) {
+		r.sites = filepath.Join(dir, r.sites)
+	}
+	r.sitesSnapshot = snapshotPath(r.sites)
+	_, err = site.Snapshot(r.sites, r.sitesSnapshot)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.sites, err)
+	}
+	return r, nil
+}
+
+// snapshotPath names the validated copy of a file, config.yaml becoming
+// config.applied.yaml.
+func snapshotPath(file string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + ".applied" + ext
+}
+
+func (r *reloader) config() *config.Config {
+	return r.current.Load()
+}
+
+// reload validates the config file, including the settings only the
+// service uses, and switches to it. On error the current config stays in
+// use.
+func (r *reloader) reload() (*config.Config, error) {
+	cfg, err := config.Snapshot(r.source, r.snapshot, (*config.Config).ValidateService)
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(cfg)
+	return cfg, nil
+}
+
+// watch reports edits to the config file and the inventory.
+func (r *reloader) watch() (*config.Watcher, error) {
+	return config.Watch(time.Second, r.source, r.sites)
+}
+
+// apply handles an edit to one watched file, moving the schedule to a new
+// interval when it changed.
+func (r *reloader) apply(elog *eventlog.Log, ticker *time.Ticker, file string) {
+	if file == r.sites {
+		sites, err := site.Snapshot(r.sites, r.sitesSnapshot)
+		if err != nil {
+			elog.Error(1, fmt.Sprintf("Rejected change to %s, keeping the previous sites: %v", file, err))
+			return
+		}
+		elog.Info(1, fmt.Sprintf("Applied change to %s, the next run checks %d sites", file, len(sites)))
+		return
+	}
+
+	old := r.config()
+	cfg, err := r.reload()
+	if err != nil {
+		elog.Error(1, fmt.Sprintf("Rejected change to %s, keeping the previous config: %v", file, err))
+		return
+	}
+
+	if cfg.Service.Schedule != old.Service.Schedule {
+		ticker.Reset(cfg.Service.Schedule)
+	}
+	if cfg.Service.SitesPath != old.Service.SitesPath {
+		elog.Warning(1, fmt.Sprintf("service.sites_path changed to %s, the service keeps using %s until it is restarted", cfg.Service.SitesPath, r.sites))
+	}
+	elog.Info(1, fmt.Sprintf("Applied change to %s, running every %s", file, cfg.Service.Schedule))
+}
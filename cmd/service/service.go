@@ -2,18 +2,23 @@ package service
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"sync"
 	"time"
 
-	"github.com/spf13/viper"
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/outbox"
+	"go.etcd.io/bbolt"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/eventlog"
 )
 
+// DiggerService runs digger on the schedule in the config file. ConfigPath
+// picks the file, otherwise config.yaml is looked for as digger does.
 type DiggerService struct {
+	ConfigPath string
+
 	stopOnce sync.Once
 	stopChan chan struct{}
 }
@@ -40,15 +45,50 @@ func (m *DiggerService) Execute(args []string, r <-chan svc.ChangeRequest, chang
 	elog.Info(1, "Digger service starting")
 
 	// Initialize configuration
-	if err := initializeConfig(); err != nil {
+	configs, err := newReloader(m.ConfigPath)
+	if err != nil {
 		elog.Error(1, fmt.Sprintf("Failed to initialize config: %v", err))
 		return true, 1
 	}
 
+	cfg := configs.config()
+	elog.Info(1, fmt.Sprintf("Digger service using config %s, running every %s", configs.source, cfg.Service.Schedule))
+
 	// Create ticker for periodic execution
-	ticker := time.NewTicker(4 * time.Hour)
+	ticker := time.NewTicker(cfg.Service.Schedule)
 	defer ticker.Stop()
 
+	// Apply edits to the config file and inventory without a restart
+	var edits <-chan []string
+	var watchErrs <-chan error
+	watcher, err := configs.watch()
+	if err != nil {
+		elog.Warning(1, fmt.Sprintf("Config changes need a restart, failed to watch them: %v", err))
+	} else {
+		defer watcher.Close()
+		edits = watcher.Changes
+		watchErrs = watcher.Errors
+	}
+
+	// Queued notifications are delivered when they are due, between runs
+	retry := time.NewTimer(0)
+	retry.Stop()
+	defer retry.Stop()
+	nextAttempts := make(chan time.Time, 1)
+	task := func(name string, args ...string) {
+		// Runs read the last validated copy of the inventory
+		args = append([]string{"-sites", configs.sitesSnapshot}, args...)
+		next, err := runDiggerTask(elog, configs.config(), args...)
+		if err != nil {
+			elog.Error(1, fmt.Sprintf("%s failed: %v", name, err))
+			return
+		}
+		select {
+		case nextAttempts <- next:
+		case <-m.stopChan:
+		}
+	}
+
 	// Report running status
 	changes <- svc.Status{
 		State:   svc.Running,
@@ -58,11 +98,7 @@ func (m *DiggerService) Execute(args []string, r <-chan svc.ChangeRequest, chang
 	elog.Info(1, "Digger service started")
 
 	// Run first task immediately
-	go func() {
-		if err := runDiggerTask(elog); err != nil {
-			elog.Error(1, fmt.Sprintf("Initial digger task failed: %v", err))
-		}
-	}()
+	go task("Initial digger task")
 
 	// Main service loop
 	for {
@@ -74,11 +110,23 @@ func (m *DiggerService) Execute(args []string, r <-chan svc.ChangeRequest, chang
 			}
 			return false, 0
 		case <-ticker.C:
-			go func() {
-				if err := runDiggerTask(elog); err != nil {
-					elog.Error(1, fmt.Sprintf("Scheduled digger task failed: %v", err))
-				}
-			}()
+			go task("Scheduled digger task")
+		case <-retry.C:
+			go task("Outbox delivery", "outbox", "deliver")
+		case next := <-nextAttempts:
+			if next.IsZero() {
+				retry.Stop()
+				continue
+			}
+			// Entries for a backend that is no longer configured stay due,
+			// so wait at least the initial backoff between deliveries
+			retry.Reset(max(time.Until(next), configs.config().Outbox.InitialBackoff))
+		case files := <-edits:
+			for _, file := range files {
+				configs.apply(elog, ticker, file)
+			}
+		case err := <-watchErrs:
+			elog.Warning(1, fmt.Sprintf("Config watcher error: %v", err))
 		case c := <-r:
 			switch c.Cmd {
 			case svc.Interrogate:
@@ -105,50 +153,54 @@ func (m *DiggerService) Execute(args []string, r <-chan svc.ChangeRequest, chang
 	}
 }
 
-func initializeConfig() error {
-	// Get the executable's directory
-	exe, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
-	}
-	exeDir := filepath.Dir(exe)
+// runMu makes digger runs take turns, as each one holds sites.db open.
+var runMu sync.Mutex
 
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(exeDir)
-	viper.AddConfigPath(".")
-
-	if err := viper.ReadInConfig(); err != nil {
-		return fmt.Errorf("failed to read config: %w", err)
+// runDiggerTask runs digger with args and returns when queued
+// notifications are next due, or the zero time when none are waiting.
+func runDiggerTask(elog *eventlog.Log, cfg *config.Config, args ...string) (time.Time, error) {
+	cmdPath := cfg.Service.DiggerPath
+	if cmdPath == "" {
+		return time.Time{}, fmt.Errorf("service.digger_path not configured")
 	}
 
-	return nil
-}
+	runMu.Lock()
+	defer runMu.Unlock()
 
-func runDiggerTask(elog *eventlog.Log) error {
-	cmdPath := viper.GetString("digger_path")
-	if cmdPath == "" {
-		return fmt.Errorf("digger_path not configured")
-	}
+	// digger reads the last validated copy of the config file, from its
+	// directory
+	cmd := exec.Command(cmdPath, append([]string{"-config", cfg.Path}, args...)...)
+	cmd.Dir = filepath.Dir(cfg.Path)
 
-	// Get the executable's directory
-	exe, err := os.Executable()
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
+		msg := fmt.Sprintf("digger execution failed: %v, output: %s", err, string(output))
+		elog.Error(1, msg)
+		return time.Time{}, fmt.Errorf(msg)
 	}
-	exeDir := filepath.Dir(exe)
 
-	cmd := exec.Command(cmdPath)
-	// Set the working directory to the executable's directory
-	cmd.Dir = exeDir
+	elog.Info(1, "Digger task completed successfully")
 
-	if output, err := cmd.CombinedOutput(); err != nil {
+	next, err := nextAttempt(cfg)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the outbox: %w", err)
+	}
+	return next, nil
+}
 
-		msg := fmt.Sprintf("digger execution failed: %v, output: %s", err, string(output))
-		elog.Error(1, msg)
-		return fmt.Errorf(msg)
+// nextAttempt reads when queued notifications are next due from the
+// database digger just ran against. It is opened read-only, between runs.
+func nextAttempt(cfg *config.Config) (time.Time, error) {
+	path := cfg.DB.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(cfg.Path), path)
 	}
 
-	elog.Info(1, "Digger task completed successfully")
-	return nil
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{ReadOnly: true, Timeout: 10 * time.Second})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	return outbox.NextAttempt(db)
 }
@@ -6,12 +6,18 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/site"
+	"github.com/bytetwiddler/digger/pkg/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/eventlog"
 )
 
+const testSites = "Hostname,Port,EntityName,IP\nexample.com,22,Example,192.0.2.1\n"
+
 func TestDiggerService_Execute(t *testing.T) {
 	isService, err := svc.IsWindowsService()
 	if err != nil || !isService {
@@ -25,13 +31,18 @@ func TestDiggerService_Execute(t *testing.T) {
 
 	// Create a test config file
 	configContent := []byte(`
-digger_path: "test-digger.exe"
+service:
+  digger_path: "test-digger.exe"
 `)
 	err = os.WriteFile(filepath.Join(tmpDir, "config.yaml"), configContent, 0644)
 	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tmpDir, "test-digger.exe"), nil, 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tmpDir, "sites.csv"), []byte(testSites), 0644)
+	require.NoError(t, err)
 
 	// Create test service
-	service := &DiggerService{}
+	service := &DiggerService{ConfigPath: filepath.Join(tmpDir, "config.yaml")}
 
 	// Create channels for testing
 	changes := make(chan svc.Status, 1)
@@ -67,17 +78,23 @@ func verifyServiceTransition(t *testing.T, changes chan svc.Status, expectedStat
 	}
 }
 
-func TestInitializeConfig(t *testing.T) {
+func TestReloader(t *testing.T) {
 	// Create a temporary directory for test config
 	tmpDir, err := os.MkdirTemp("", "digger-test")
 	require.NoError(t, err)
 	defer os.RemoveAll(tmpDir)
 
 	// Create a test config file
+	configFile := filepath.Join(tmpDir, "config.yaml")
 	configContent := []byte(`
-digger_path: "test-digger.exe"
+service:
+  digger_path: "test-digger.exe"
 `)
-	err = os.WriteFile(filepath.Join(tmpDir, "config.yaml"), configContent, 0644)
+	err = os.WriteFile(configFile, configContent, 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tmpDir, "test-digger.exe"), nil, 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tmpDir, "sites.csv"), []byte(testSites), 0644)
 	require.NoError(t, err)
 
 	// Set working directory to temp dir
@@ -89,8 +106,75 @@ digger_path: "test-digger.exe"
 	require.NoError(t, err)
 
 	// Test config initialization
-	err = initializeConfig()
-	assert.NoError(t, err)
+	configs, err := newReloader("")
+	require.NoError(t, err)
+	assert.Equal(t, configFile, configs.source)
+	cfg := configs.config()
+	assert.Equal(t, filepath.Join(tmpDir, "config.applied.yaml"), cfg.Path)
+	assert.Equal(t, "test-digger.exe", cfg.Service.DiggerPath)
+	assert.Equal(t, 4*time.Hour, cfg.Service.Schedule)
+
+	// An invalid edit keeps the previous config and snapshot
+	err = os.WriteFile(configFile, []byte("service:\n  schedule: often\n"), 0644)
+	require.NoError(t, err)
+	_, err = configs.reload()
+	assert.Error(t, err)
+	assert.Same(t, cfg, configs.config())
+	data, err := os.ReadFile(cfg.Path)
+	require.NoError(t, err)
+	assert.Equal(t, configContent, data)
+
+	// So does one naming a digger that is not there
+	err = os.WriteFile(configFile, []byte("service:\n  digger_path: missing.exe\n"), 0644)
+	require.NoError(t, err)
+	_, err = configs.reload()
+	assert.ErrorContains(t, err, "service.digger_path: file not found")
+	assert.Same(t, cfg, configs.config())
+
+	err = os.WriteFile(configFile, []byte("service:\n  digger_path: test-digger.exe\n  schedule: 1h\n"), 0644)
+	require.NoError(t, err)
+	_, err = configs.reload()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, configs.config().Service.Schedule)
+
+	// Runs read a validated copy of the inventory, which a bad edit keeps
+	assert.Equal(t, filepath.Join(tmpDir, "sites.applied.csv"), configs.sitesSnapshot)
+	data, err = os.ReadFile(configs.sitesSnapshot)
+	require.NoError(t, err)
+	assert.Equal(t, testSites, string(data))
+
+	err = os.WriteFile(configs.sites, []byte("Hostname,Port,EntityName,IP\nexample.com,ssh,Example,\n"), 0644)
+	require.NoError(t, err)
+	_, err = site.Snapshot(configs.sites, configs.sitesSnapshot)
+	assert.Error(t, err)
+	data, err = os.ReadFile(configs.sitesSnapshot)
+	require.NoError(t, err)
+	assert.Equal(t, testSites, string(data))
+}
+
+func TestReloaderSitesPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "config.yaml")
+	err := os.WriteFile(configFile, []byte("service:\n  digger_path: test-digger.exe\n  sites_path: inventory\\vendors.csv\n"), 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(tmpDir, "test-digger.exe"), nil, 0755)
+	require.NoError(t, err)
+	require.NoError(t, os.Mkdir(filepath.Join(tmpDir, "inventory"), 0755))
+	err = os.WriteFile(filepath.Join(tmpDir, "inventory", "vendors.csv"), []byte(testSites), 0644)
+	require.NoError(t, err)
+
+	oldWd, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(oldWd)
+
+	// The configured inventory is watched and snapshotted next to itself
+	configs, err := newReloader(configFile)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(tmpDir, "inventory", "vendors.csv"), configs.sites)
+	assert.Equal(t, filepath.Join(tmpDir, "inventory", "vendors.applied.csv"), configs.sitesSnapshot)
+	data, err := os.ReadFile(configs.sitesSnapshot)
+	require.NoError(t, err)
+	assert.Equal(t, testSites, string(data))
 }
 
 func TestRunDiggerTask(t *testing.T) {
@@ -125,7 +209,9 @@ func TestRunDiggerTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := runDiggerTask(elog)
+			cfg := &config.Config{Path: filepath.Join(t.TempDir(), "config.yaml")}
+			cfg.Service.DiggerPath = tt.diggerPath
+			_, err := runDiggerTask(elog, cfg)
 			if tt.wantErr {
 				assert.Error(t, err)
 			} else {
@@ -134,3 +220,22 @@ func TestRunDiggerTask(t *testing.T) {
 		})
 	}
 }
+
+func TestNextAttempt(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{Path: filepath.Join(dir, "config.applied.yaml")}
+	cfg.DB.Path = "sites.db"
+
+	db, err := store.Open(filepath.Join(dir, cfg.DB.Path))
+	require.NoError(t, err)
+	due := time.Date(2024, 3, 1, 10, 5, 0, 0, time.UTC)
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return store.PutOutbox(tx, store.OutboxEntry{EventID: "a", Backend: "email", NextAttempt: due})
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	next, err := nextAttempt(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, due, next.UTC())
+}
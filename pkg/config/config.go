@@ -2,48 +2,321 @@ package config
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Log struct {
-		Level string `yaml:"level"`
-		Gelf  struct {
-			Address string `yaml:"address"`
-		} `yaml:"gelf"`
-		File struct {
-			Filename   string `yaml:"filename"`
-			MaxSize    int    `yaml:"maxsize"`
-			MaxBackups int    `yaml:"maxbackups"`
-			MaxAge     int    `yaml:"maxage"`
-		} `yaml:"file"`
-	} `yaml:"log"`
-	DB struct {
-		Path string `yaml:"path"`
+	Log LogConfig `yaml:"log"`
+	DB  struct {
+		Path      string `yaml:"path"`
+		Retention struct {
+			Raw    time.Duration `yaml:"raw"`
+			Hourly time.Duration `yaml:"hourly"`
+			Daily  time.Duration `yaml:"daily"`
+		} `yaml:"retention"`
 	} `yaml:"db"`
-	SMTP struct {
-		Host         string `yaml:"host"`
-		Port         int    `yaml:"port"`
-		Username     string `yaml:"username"`
-		Password     string `yaml:"password"`
-		From         string `yaml:"from"`
-		To           string `yaml:"to"`
+	SMTP   SMTPConfig `yaml:"smtp"`
+	Digest struct {
+		Enabled          bool          `yaml:"enabled"`
+		Period           time.Duration `yaml:"period"`
+		TemplatePath     string        `yaml:"template_path"`
+		TextTemplatePath string        `yaml:"text_template_path"`
+	} `yaml:"digest"`
+	Report struct {
 		TemplatePath string `yaml:"template_path"`
-	} `yaml:"smtp"`
+	} `yaml:"report"`
+	Outbox struct {
+		MaxAge         time.Duration `yaml:"max_age"`
+		InitialBackoff time.Duration `yaml:"initial_backoff"`
+		MaxBackoff     time.Duration `yaml:"max_backoff"`
+	} `yaml:"outbox"`
+	Policy       PolicyConfig `yaml:"policy"`
+	Reachability struct {
+		Enabled bool          `yaml:"enabled"`
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"reachability"`
+	Resolver struct {
+		Servers StringList    `yaml:"servers"`
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"resolver"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+	Routes    []RouteConfig    `yaml:"routes"`
+	Service   ServiceConfig    `yaml:"service"`
+
+	// DiggerPath is the old place for service.digger_path.
 	DiggerPath string `yaml:"digger_path"`
+
+	// Path is the file the config was loaded from.
+	Path string `yaml:"-"`
+}
+
+// ServiceConfig is read by the Windows service, which runs the digger
+// executable at DiggerPath every Schedule against the inventory at
+// SitesPath.
+type ServiceConfig struct {
+	DiggerPath string        `yaml:"digger_path"`
+	Schedule   time.Duration `yaml:"schedule"`
+	SitesPath  string        `yaml:"sites_path"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+	Gelf  struct {
+		Address string `yaml:"address"`
+	} `yaml:"gelf"`
+	File struct {
+		Filename   string `yaml:"filename"`
+		MaxSize    int    `yaml:"maxsize"`
+		MaxBackups int    `yaml:"maxbackups"`
+		MaxAge     int    `yaml:"maxage"`
+	} `yaml:"file"`
+	Syslog SyslogConfig `yaml:"syslog"`
+}
+
+// SMTPConfig holds the mail server settings and the email templates.
+// Subjects are templates too. Templates picks other templates for changes
+// to particular entities or of a particular severity. The password can be
+// kept in PasswordFile instead.
+type SMTPConfig struct {
+	Host                  string                `yaml:"host"`
+	Port                  int                   `yaml:"port"`
+	Username              string                `yaml:"username"`
+	Password              string                `yaml:"password"`
+	PasswordFile          string                `yaml:"password_file"`
+	From                  string                `yaml:"from"`
+	To                    StringList            `yaml:"to"`
+	CC                    StringList            `yaml:"cc"`
+	BCC                   StringList            `yaml:"bcc"`
+	RecipientName         string                `yaml:"recipient_name"`
+	Subject               string                `yaml:"subject"`
+	TemplatePath          string                `yaml:"template_path"`
+	TextTemplatePath      string                `yaml:"text_template_path"`
+	BatchSubject          string                `yaml:"batch_subject"`
+	BatchTemplatePath     string                `yaml:"batch_template_path"`
+	BatchTextTemplatePath string                `yaml:"batch_text_template_path"`
+	Templates             []EmailTemplateConfig `yaml:"templates"`
+	Timeout               time.Duration         `yaml:"timeout"`
+	TLS                   SMTPTLSConfig         `yaml:"tls"`
+	SMIME                 SMIMEConfig           `yaml:"smime"`
 }
 
+// SMIMEConfig signs emails with S/MIME. CertFile holds the signing
+// certificate, optionally followed by its intermediates, and KeyFile its
+// private key, both PEM.
+type SMIMEConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// SMTPTLSConfig secures the connection to the mail server. Mode is none,
+// opportunistic (STARTTLS when offered), starttls (STARTTLS or fail) or
+// tls (implicit TLS, usually port 465). The server certificate is verified
+// unless InsecureSkipVerify is set.
+type SMTPTLSConfig struct {
+	Mode               string `yaml:"mode"`
+	CAFile             string `yaml:"ca_file"`
+	ServerName         string `yaml:"server_name"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// EmailTemplateConfig applies to changes whose entity and severity match.
+// An empty Entity or Severity matches anything, and unset templates fall
+// back to the defaults.
+type EmailTemplateConfig struct {
+	Entity           string `yaml:"entity"`
+	Severity         string `yaml:"severity"`
+	Subject          string `yaml:"subject"`
+	TemplatePath     string `yaml:"template_path"`
+	TextTemplatePath string `yaml:"text_template_path"`
+}
+
+// SyslogConfig sends events to a SIEM over syslog. Network is udp, tcp or
+// tls and Format is rfc5424, cef or leef.
+type SyslogConfig struct {
+	Address  string        `yaml:"address"`
+	Network  string        `yaml:"network"`
+	Format   string        `yaml:"format"`
+	Facility string        `yaml:"facility"`
+	AppName  string        `yaml:"app_name"`
+	Hostname string        `yaml:"hostname"`
+	CAFile   string        `yaml:"ca_file"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Retry    RetryConfig   `yaml:"retry"`
+}
+
+// PolicyConfig decides which events are notified. Zero values turn each
+// rule off. Quiet hours are local times such as "22:00" and "07:00".
+type PolicyConfig struct {
+	DedupWindow time.Duration `yaml:"dedup_window"`
+	RateLimit   struct {
+		Window  time.Duration `yaml:"window"`
+		PerSite int           `yaml:"per_site"`
+		Global  int           `yaml:"global"`
+	} `yaml:"rate_limit"`
+	QuietHours struct {
+		Start string `yaml:"start"`
+		End   string `yaml:"end"`
+	} `yaml:"quiet_hours"`
+}
+
+// NotifierConfig is one entry in the notifiers list. Type selects the
+// backend and Name tells several backends of the same type apart. Batch
+// delivers every change from a run as one notification.
+type NotifierConfig struct {
+	Type    string         `yaml:"type"`
+	Name    string         `yaml:"name"`
+	Batch   bool           `yaml:"batch"`
+	Webhook *WebhookConfig `yaml:"webhook"`
+	Chat    *ChatConfig    `yaml:"chat"`
+	Ticket  *TicketConfig  `yaml:"ticket"`
+	Exec    *ExecConfig    `yaml:"exec"`
+}
+
+// RouteConfig picks the recipients and backends for events that match it.
+// Every criterion that is set must match, and a list matches any of its
+// entries.
+type RouteConfig struct {
+	Match struct {
+		Entity   StringList `yaml:"entity"`
+		Tags     StringList `yaml:"tags"`
+		Severity StringList `yaml:"severity"`
+		Kind     StringList `yaml:"kind"`
+	} `yaml:"match"`
+	To        StringList `yaml:"to"`
+	CC        StringList `yaml:"cc"`
+	BCC       StringList `yaml:"bcc"`
+	Notifiers StringList `yaml:"notifiers"`
+}
+
+// StringList is a YAML list that may also be written as a single value.
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if unmarshal(&list) == nil {
+		*l = list
+		return nil
+	}
+
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	*l = nil
+	if s != "" {
+		*l = StringList{s}
+	}
+	return nil
+}
+
+// RetryConfig sets how often a backend retries a failed delivery.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+type WebhookConfig struct {
+	URL             string            `yaml:"url"`
+	Headers         map[string]string `yaml:"headers"`
+	Secret          string            `yaml:"secret"`
+	SecretFile      string            `yaml:"secret_file"`
+	SignatureHeader string            `yaml:"signature_header"`
+	Timeout         time.Duration     `yaml:"timeout"`
+	Retry           RetryConfig       `yaml:"retry"`
+}
+
+// ChatConfig configures a Slack, Teams or Mattermost incoming webhook. The
+// URL is a credential, so it can be kept in URLFile instead.
+type ChatConfig struct {
+	URL          string        `yaml:"url"`
+	URLFile      string        `yaml:"url_file"`
+	TemplatePath string        `yaml:"template_path"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Retry        RetryConfig   `yaml:"retry"`
+}
+
+// TicketConfig configures a Jira or ServiceNow ticketing backend. Summary,
+// Description, Comment and string values in Fields are templates rendered
+// with the change event.
+type TicketConfig struct {
+	URL         string                 `yaml:"url"`
+	Username    string                 `yaml:"username"`
+	Token       string                 `yaml:"token"`
+	TokenFile   string                 `yaml:"token_file"`
+	Project     string                 `yaml:"project"`
+	IssueType   string                 `yaml:"issue_type"`
+	Table       string                 `yaml:"table"`
+	Summary     string                 `yaml:"summary"`
+	Description string                 `yaml:"description"`
+	Comment     string                 `yaml:"comment"`
+	Fields      map[string]interface{} `yaml:"fields"`
+	Timeout     time.Duration          `yaml:"timeout"`
+	Retry       RetryConfig            `yaml:"retry"`
+}
+
+// ExecConfig runs a local command for each event.
+type ExecConfig struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Dir     string            `yaml:"dir"`
+	Env     map[string]string `yaml:"env"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Retry   RetryConfig       `yaml:"retry"`
+}
+
+// LoadConfig reads the config file, applies DIGGER_ environment variables
+// and secret files, fills in defaults and validates it. Unknown keys and
+// invalid settings are returned together as Problems.
 func LoadConfig(filePath string) (*Config, error) {
-	data, err := ioutil.ReadFile(filePath)
+	cfg, problems, err := Check(filePath)
 	if err != nil {
 		return nil, err
 	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	return cfg, nil
+}
+
+// Check reads the config file like LoadConfig but returns the problems
+// found separately, so they can all be reported. The error is only set
+// when the file cannot be read or is not valid YAML.
+func Check(filePath string) (*Config, Problems, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
 	var cfg Config
 	err = yaml.Unmarshal(data, &cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &cfg, nil
+	cfg.Path = filePath
+	if abs, err := filepath.Abs(filePath); err == nil {
+		cfg.Path = abs
+	}
+
+	problems, err := unknownKeys(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	problems = append(problems, cfg.applyEnv(os.Environ())...)
+	problems = append(problems, cfg.loadSecrets()...)
+
+	cfg.SetDefaults()
+	problems = append(problems, cfg.Validate()...)
+
+	return &cfg, problems, nil
 }
@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+
+	"gopkg.in/yaml.v2"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -28,18 +30,7 @@ log:
     maxage: 20
 `,
 			expectedConfig: &Config{
-				Log: struct {
-					Level string `yaml:"level"`
-					Gelf  struct {
-						Address string `yaml:"address"`
-					} `yaml:"gelf"`
-					File struct {
-						Filename   string `yaml:"filename"`
-						MaxSize    int    `yaml:"maxsize"`
-						MaxBackups int    `yaml:"maxbackups"`
-						MaxAge     int    `yaml:"maxage"`
-					} `yaml:"file"`
-				}{
+				Log: LogConfig{
 					Level: "debug",
 					Gelf: struct {
 						Address string `yaml:"address"`
@@ -104,18 +95,7 @@ log:
 				"LOG_MAXAGE":     "20",
 			},
 			expectedConfig: &Config{
-				Log: struct {
-					Level string `yaml:"level"`
-					Gelf  struct {
-						Address string `yaml:"address"`
-					} `yaml:"gelf"`
-					File struct {
-						Filename   string `yaml:"filename"`
-						MaxSize    int    `yaml:"maxsize"`
-						MaxBackups int    `yaml:"maxbackups"`
-						MaxAge     int    `yaml:"maxage"`
-					} `yaml:"file"`
-				}{
+				Log: LogConfig{
 					Level: "info",
 					Gelf: struct {
 						Address string `yaml:"address"`
@@ -185,3 +165,29 @@ log:
 		})
 	}
 }
+
+func TestStringList(t *testing.T) {
+	var cfg struct {
+		One  StringList `yaml:"one"`
+		Many StringList `yaml:"many"`
+		None StringList `yaml:"none"`
+	}
+	err := yaml.Unmarshal([]byte(`
+one: noc@example.com
+many: [noc@example.com, ops@example.com]
+none: ""
+`), &cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(cfg.One) != 1 || cfg.One[0] != "noc@example.com" {
+		t.Errorf("expected a single entry, got %v", cfg.One)
+	}
+	if len(cfg.Many) != 2 || cfg.Many[1] != "ops@example.com" {
+		t.Errorf("expected two entries, got %v", cfg.Many)
+	}
+	if cfg.None != nil {
+		t.Errorf("expected no entries, got %v", cfg.None)
+	}
+}
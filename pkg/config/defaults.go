@@ -0,0 +1,81 @@
+package config
+
+import "time"
+
+// SetDefaults fills in every setting left out of the config file.
+func (c *Config) SetDefaults() {
+	if c.Log.Level == "" {
+		c.Log.Level = "info"
+	}
+	if c.Log.File.Filename == "" {
+		c.Log.File.Filename = "digger.log"
+	}
+
+	sc := &c.Log.Syslog
+	if sc.Network == "" {
+		sc.Network = "udp"
+	}
+	if sc.Format == "" {
+		sc.Format = "rfc5424"
+	}
+	if sc.Facility == "" {
+		sc.Facility = "local0"
+	}
+	if sc.AppName == "" {
+		sc.AppName = "digger"
+	}
+	if sc.Timeout == 0 {
+		sc.Timeout = 10 * time.Second
+	}
+
+	if c.DB.Path == "" {
+		c.DB.Path = "sites.db"
+	}
+	r := &c.DB.Retention
+	if r.Raw == 0 && r.Hourly == 0 && r.Daily == 0 {
+		r.Raw = 7 * 24 * time.Hour
+		r.Hourly = 30 * 24 * time.Hour
+		r.Daily = 365 * 24 * time.Hour
+	}
+
+	if c.SMTP.Port == 0 {
+		c.SMTP.Port = 25
+		if c.SMTP.TLS.Mode == "tls" {
+			c.SMTP.Port = 465
+		}
+	}
+	if c.SMTP.Timeout == 0 {
+		c.SMTP.Timeout = 30 * time.Second
+	}
+
+	if c.Digest.Period == 0 {
+		c.Digest.Period = 24 * time.Hour
+	}
+
+	if c.Outbox.MaxAge == 0 {
+		c.Outbox.MaxAge = 72 * time.Hour
+	}
+	if c.Outbox.InitialBackoff == 0 {
+		c.Outbox.InitialBackoff = 5 * time.Minute
+	}
+	if c.Outbox.MaxBackoff == 0 {
+		c.Outbox.MaxBackoff = 6 * time.Hour
+	}
+
+	if c.Reachability.Timeout == 0 {
+		c.Reachability.Timeout = 5 * time.Second
+	}
+	if c.Resolver.Timeout == 0 {
+		c.Resolver.Timeout = 5 * time.Second
+	}
+
+	if c.Service.DiggerPath == "" {
+		c.Service.DiggerPath = c.DiggerPath
+	}
+	if c.Service.Schedule == 0 {
+		c.Service.Schedule = 4 * time.Hour
+	}
+	if c.Service.SitesPath == "" {
+		c.Service.SitesPath = "sites.csv"
+	}
+}
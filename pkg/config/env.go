@@ -0,0 +1,199 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EnvPrefix starts every environment variable that overrides a setting.
+// The rest of the name is the YAML path in upper case with underscores,
+// such as DIGGER_SMTP_PASSWORD or DIGGER_NOTIFIERS_0_WEBHOOK_URL.
+const EnvPrefix = "DIGGER_"
+
+type envOverrides struct {
+	vars     map[string]string
+	used     map[string]bool
+	problems Problems
+}
+
+// applyEnv overrides settings with DIGGER_ environment variables. Lists
+// take comma separated values. List entries must already exist in the
+// config file, and maps such as webhook headers cannot be overridden.
+// Variables that match no setting are logged and ignored, as other tools,
+// and digger's own exec backend, set DIGGER_ variables too.
+func (c *Config) applyEnv(environ []string) Problems {
+	e := &envOverrides{vars: make(map[string]string), used: make(map[string]bool)}
+	for _, kv := range environ {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(k, EnvPrefix) {
+			e.vars[k] = v
+		}
+	}
+	if len(e.vars) == 0 {
+		return nil
+	}
+
+	e.set(reflect.ValueOf(c).Elem(), "", strings.TrimSuffix(EnvPrefix, "_"))
+
+	var unused []string
+	for k := range e.vars {
+		if !e.used[k] {
+			unused = append(unused, k)
+		}
+	}
+	sort.Strings(unused)
+	for _, k := range unused {
+		logrus.Warnf("Ignoring environment variable %s, it does not match a setting", k)
+	}
+
+	return e.problems
+}
+
+func (e *envOverrides) set(v reflect.Value, path, name string) {
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		if s, ok := e.lookup(name); ok {
+			v.Set(reflect.ValueOf(splitList(s)).Convert(v.Type()))
+		}
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			e.set(v.Field(i), join(path, key), name+"_"+strings.ToUpper(key))
+		}
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			if v.Type().Elem().Kind() != reflect.Struct || !e.hasPrefix(name+"_") {
+				return
+			}
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		e.set(v.Elem(), path, name)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			if s, ok := e.lookup(name); ok {
+				v.Set(reflect.ValueOf(splitList(s)))
+			}
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			e.set(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("%s_%d", name, i))
+		}
+
+	case reflect.String:
+		if s, ok := e.lookup(name); ok {
+			v.SetString(s)
+		}
+
+	case reflect.Bool:
+		if s, ok := e.lookup(name); ok {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				e.problems.add(path, "invalid value %q from %s, expected true or false", s, name)
+				return
+			}
+			v.SetBool(b)
+		}
+
+	case reflect.Int, reflect.Int64:
+		s, ok := e.lookup(name)
+		if !ok {
+			return
+		}
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				e.problems.add(path, "invalid duration %q from %s", s, name)
+				return
+			}
+			v.SetInt(int64(d))
+			return
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			e.problems.add(path, "invalid number %q from %s", s, name)
+			return
+		}
+		v.SetInt(int64(n))
+	}
+}
+
+func (e *envOverrides) lookup(name string) (string, bool) {
+	s, ok := e.vars[name]
+	if ok {
+		e.used[name] = true
+	}
+	return s, ok
+}
+
+func (e *envOverrides) hasPrefix(prefix string) bool {
+	for k := range e.vars {
+		if strings.HasPrefix(k, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loadSecrets reads the secrets kept in files, such as smtp.password_file,
+// into the settings they stand for. Trailing newlines are dropped.
+func (c *Config) loadSecrets() Problems {
+	var p Problems
+	p.secret("smtp.password", &c.SMTP.Password, c.SMTP.PasswordFile)
+	for i, nc := range c.Notifiers {
+		path := fmt.Sprintf("notifiers[%d]", i)
+		if nc.Webhook != nil {
+			p.secret(path+".webhook.secret", &nc.Webhook.Secret, nc.Webhook.SecretFile)
+		}
+		if nc.Chat != nil {
+			p.secret(path+".chat.url", &nc.Chat.URL, nc.Chat.URLFile)
+		}
+		if nc.Ticket != nil {
+			p.secret(path+".ticket.token", &nc.Ticket.Token, nc.Ticket.TokenFile)
+		}
+	}
+	return p
+}
+
+func (p *Problems) secret(path string, value *string, file string) {
+	if file == "" {
+		return
+	}
+	if *value != "" {
+		p.add(path+"_file", "set either %s or %s_file, not both", path, path)
+		return
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		p.add(path+"_file", "failed to read secret: %v", err)
+		return
+	}
+	*value = strings.TrimRight(string(data), "\r\n")
+}
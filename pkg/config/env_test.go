@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	t.Setenv("DIGGER_SMTP_PASSWORD", "from-env")
+	t.Setenv("DIGGER_SMTP_PORT", "587")
+	t.Setenv("DIGGER_SMTP_TO", "noc@example.com, ops@example.com")
+	t.Setenv("DIGGER_SMTP_TLS_MODE", "starttls")
+	t.Setenv("DIGGER_DIGEST_ENABLED", "true")
+	t.Setenv("DIGGER_OUTBOX_MAX_AGE", "24h")
+	t.Setenv("DIGGER_NOTIFIERS_1_WEBHOOK_SECRET", "hook-secret")
+
+	cfg, problems := checkYAML(t, `
+smtp:
+  host: smtp.example.com
+  port: 25
+  from: digger@example.com
+  to: someone@example.com
+notifiers:
+  - type: email
+  - type: webhook
+    webhook:
+      url: https://hooks.example.com/digger
+`)
+	if len(problems) > 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	if cfg.SMTP.Password != "from-env" || cfg.SMTP.Port != 587 || cfg.SMTP.TLS.Mode != "starttls" {
+		t.Errorf("expected smtp overrides, got %+v", cfg.SMTP)
+	}
+	if strings.Join(cfg.SMTP.To, " ") != "noc@example.com ops@example.com" {
+		t.Errorf("expected the to list to be replaced, got %v", cfg.SMTP.To)
+	}
+	if !cfg.Digest.Enabled || cfg.Outbox.MaxAge != 24*time.Hour {
+		t.Errorf("expected digest and outbox overrides, got %v %s", cfg.Digest.Enabled, cfg.Outbox.MaxAge)
+	}
+	if cfg.Notifiers[1].Webhook.Secret != "hook-secret" || cfg.Notifiers[0].Webhook != nil {
+		t.Errorf("expected a secret on the webhook notifier only, got %+v", cfg.Notifiers)
+	}
+}
+
+func TestEnvOverrideErrors(t *testing.T) {
+	t.Setenv("DIGGER_SMTP_PORT", "smtp")
+	t.Setenv("DIGGER_REACHABILITY_TIMEOUT", "5")
+	t.Setenv("DIGGER_SMTP_PASWORD", "typo")
+	t.Setenv("DIGGER_NOTIFIERS_3_TYPE", "exec")
+
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	defer logrus.SetOutput(os.Stderr)
+
+	_, problems := checkYAML(t, "{}\n")
+	expected := []Problem{
+		{"smtp.port", `invalid number "smtp" from DIGGER_SMTP_PORT`},
+		{"reachability.timeout", "invalid duration"},
+	}
+	for _, e := range expected {
+		if !hasProblem(problems, e.Path, e.Message) {
+			t.Errorf("expected %s, got %v", e, problems)
+		}
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("expected %d problems, got %v", len(expected), problems)
+	}
+
+	// Variables that match no setting are only warned about
+	for _, name := range []string{"DIGGER_SMTP_PASWORD", "DIGGER_NOTIFIERS_3_TYPE"} {
+		if !strings.Contains(logs.String(), "Ignoring environment variable "+name) {
+			t.Errorf("expected a warning for %s, got %q", name, logs.String())
+		}
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatalf("failed to write secret: %v", err)
+		}
+		return filepath.ToSlash(path)
+	}
+	password := write("smtp_password", "s3cret\n")
+	token := write("jira_token", "tok3n")
+	hook := write("slack_url", "https://hooks.slack.com/services/T000/B000/XXXX\r\n")
+
+	cfg, problems := checkYAML(t, `
+smtp:
+  host: smtp.example.com
+  from: digger@example.com
+  to: noc@example.com
+  password_file: `+password+`
+notifiers:
+  - type: email
+  - type: jira
+    ticket:
+      url: https://example.atlassian.net
+      project: OPS
+      token_file: `+token+`
+  - type: slack
+    chat:
+      url_file: `+hook+`
+`)
+	if len(problems) > 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if cfg.SMTP.Password != "s3cret" || cfg.Notifiers[1].Ticket.Token != "tok3n" {
+		t.Errorf("expected secrets from files, got %q and %q", cfg.SMTP.Password, cfg.Notifiers[1].Ticket.Token)
+	}
+	if cfg.Notifiers[2].Chat.URL != "https://hooks.slack.com/services/T000/B000/XXXX" {
+		t.Errorf("expected the chat url from its file, got %q", cfg.Notifiers[2].Chat.URL)
+	}
+
+	// The file can be named in the environment too
+	t.Setenv("DIGGER_SMTP_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	_, problems = checkYAML(t, "smtp:\n  password: plain\n")
+	if !hasProblem(problems, "smtp.password_file", "set either smtp.password or smtp.password_file") {
+		t.Errorf("expected a conflict, got %v", problems)
+	}
+	_, problems = checkYAML(t, "{}\n")
+	if !hasProblem(problems, "smtp.password_file", "failed to read secret") {
+		t.Errorf("expected a read error, got %v", problems)
+	}
+}
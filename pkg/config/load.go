@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the config file looked for when no path is given.
+const FileName = "config.yaml"
+
+// SearchPaths lists where Locate looks for the config file, in order: the
+// working directory, then the directory of the running executable.
+func SearchPaths() []string {
+	paths := []string{FileName}
+	exe, err := os.Executable()
+	if err == nil {
+		paths = append(paths, filepath.Join(filepath.Dir(exe), FileName))
+	}
+	return paths
+}
+
+// Locate returns the absolute path of the config file to use: path itself
+// when it is set, otherwise the first of SearchPaths that exists.
+func Locate(path string) (string, error) {
+	if path != "" {
+		_, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to find config file: %w", err)
+		}
+		return filepath.Abs(path)
+	}
+
+	candidates := SearchPaths()
+	for _, candidate := range candidates {
+		_, err := os.Stat(candidate)
+		if err == nil {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", fmt.Errorf("no config file found, looked for %s", strings.Join(candidates, " and "))
+}
+
+// Load locates the config file and loads it with LoadConfig. The file used
+// is recorded in the Path of the result.
+func Load(path string) (*Config, error) {
+	file, err := Locate(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Snapshot loads the config file at path and, when it is valid, saves a
+// copy at snapshot, replacing the old copy in one step. The copy is taken
+// before loading, so a write that lands in between cannot slip through
+// unvalidated. validate, when set, checks settings beyond Validate. The
+// Path of the result is the snapshot.
+func Snapshot(path, snapshot string, validate func(*Config) Problems) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(snapshot), filepath.Base(snapshot)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create config snapshot: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+
+	cfg, err := LoadConfig(tmp.Name())
+	if err == nil && validate != nil {
+		if problems := validate(cfg); len(problems) > 0 {
+			err = problems
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	err = os.Rename(tmp.Name(), snapshot)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to save config snapshot: %w", err)
+	}
+
+	cfg.Path, err = filepath.Abs(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	explicit := filepath.Join(dir, "digger.yaml")
+	err := os.WriteFile(explicit, []byte("service:\n  schedule: 1h\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := Load(explicit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Path != explicit || cfg.Service.Schedule != time.Hour {
+		t.Errorf("expected %s with a 1h schedule, got %s and %s", explicit, cfg.Path, cfg.Service.Schedule)
+	}
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "failed to find config file") {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+
+	// Without a path, config.yaml in the working directory is used
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("failed to change directory: %v", err)
+	}
+
+	_, err = Locate("")
+	if err == nil || !strings.Contains(err.Error(), "no config file found, looked for config.yaml and ") {
+		t.Errorf("expected a search error, got %v", err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, FileName), []byte("digger_path: digger.yaml\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err = Load("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Path != filepath.Join(dir, FileName) {
+		t.Errorf("expected the config in the working directory, got %s", cfg.Path)
+	}
+	// The old top level digger_path still works
+	if cfg.Service.DiggerPath != "digger.yaml" || cfg.Service.Schedule != 4*time.Hour {
+		t.Errorf("unexpected service settings: %+v", cfg.Service)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, FileName), []byte("service:\n  schedule: -1h\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	_, err = Load("")
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join(dir, FileName)+": 1 problems in config: service.schedule") {
+		t.Errorf("expected the file name in the error, got %v", err)
+	}
+}
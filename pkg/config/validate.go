@@ -0,0 +1,475 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// Problem is something wrong with one setting, named by its YAML path such
+// as smtp.port or notifiers[1].webhook.url.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// Problems is every problem found in a config file.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	msgs := make([]string, len(p))
+	for i, pr := range p {
+		msgs[i] = pr.String()
+	}
+	return fmt.Sprintf("%d problems in config: %s", len(p), strings.Join(msgs, "; "))
+}
+
+func (p *Problems) add(path, format string, args ...interface{}) {
+	*p = append(*p, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// unknownKeys reports keys in data that do not match a field of Config, so
+// misspelt settings are not silently ignored.
+func unknownKeys(data []byte) (Problems, error) {
+	var raw interface{}
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems Problems
+	walkKeys(reflect.TypeOf(Config{}), raw, "", &problems)
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems, nil
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+func walkKeys(t reflect.Type, v interface{}, path string, problems *Problems) {
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		walkKeys(t.Elem(), v, path, problems)
+
+	case reflect.Struct:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			fields[name] = f.Type
+		}
+
+		for k, child := range m {
+			key := fmt.Sprint(k)
+			ft, ok := fields[key]
+			if !ok {
+				problems.add(join(path, key), "unknown setting")
+				continue
+			}
+			walkKeys(ft, child, join(path, key), problems)
+		}
+
+	case reflect.Slice:
+		list, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for i, child := range list {
+			walkKeys(t.Elem(), child, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+
+	case reflect.Map:
+		m, ok := v.(map[interface{}]interface{})
+		if !ok || t.Elem().Kind() == reflect.Interface {
+			return
+		}
+		for k, child := range m {
+			walkKeys(t.Elem(), child, join(path, fmt.Sprint(k)), problems)
+		}
+	}
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var (
+	tlsModes         = []string{"", "none", "opportunistic", "starttls", "tls"}
+	syslogNetworks   = []string{"udp", "tcp", "tls"}
+	syslogFormats    = []string{"rfc5424", "cef", "leef"}
+	syslogFacilities = []string{
+		"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news", "uucp", "cron", "authpriv", "ftp",
+		"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+	}
+	severities = []string{"info", "warning", "critical"}
+	kinds      = []string{"ip_changed", "lookup_failed", "unreachable"}
+)
+
+// Validate checks the settings make sense together: values are in range,
+// addresses and URLs parse and the files named exist. Call SetDefaults
+// first.
+func (c *Config) Validate() Problems {
+	var p Problems
+
+	// Logging
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		p.add("log.level", "unknown level %q, expected trace, debug, info, warn, error, fatal or panic", c.Log.Level)
+	}
+	if c.Log.Gelf.Address != "" {
+		p.hostPort("log.gelf.address", c.Log.Gelf.Address)
+	}
+	p.parentDir("log.file.filename", c.Log.File.Filename)
+	p.notNegative("log.file.maxsize", c.Log.File.MaxSize)
+	p.notNegative("log.file.maxbackups", c.Log.File.MaxBackups)
+	p.notNegative("log.file.maxage", c.Log.File.MaxAge)
+
+	if sc := c.Log.Syslog; sc.Address != "" {
+		p.hostPort("log.syslog.address", sc.Address)
+		p.oneOf("log.syslog.network", sc.Network, syslogNetworks)
+		p.oneOf("log.syslog.format", sc.Format, syslogFormats)
+		p.oneOf("log.syslog.facility", sc.Facility, syslogFacilities)
+		p.file("log.syslog.ca_file", sc.CAFile)
+		p.duration("log.syslog.timeout", sc.Timeout)
+		p.retry("log.syslog.retry", sc.Retry)
+	}
+
+	// Database
+	p.parentDir("db.path", c.DB.Path)
+	p.duration("db.retention.raw", c.DB.Retention.Raw)
+	p.duration("db.retention.hourly", c.DB.Retention.Hourly)
+	p.duration("db.retention.daily", c.DB.Retention.Daily)
+
+	// Email
+	if c.usesEmail() {
+		c.validateSMTP(&p)
+	}
+
+	if c.Digest.Enabled {
+		p.positive("digest.period", c.Digest.Period)
+		p.file("digest.template_path", c.Digest.TemplatePath)
+		p.file("digest.text_template_path", c.Digest.TextTemplatePath)
+	}
+	p.file("report.template_path", c.Report.TemplatePath)
+
+	p.duration("outbox.max_age", c.Outbox.MaxAge)
+	p.duration("outbox.initial_backoff", c.Outbox.InitialBackoff)
+	p.duration("outbox.max_backoff", c.Outbox.MaxBackoff)
+	if c.Outbox.MaxBackoff > 0 && c.Outbox.InitialBackoff > c.Outbox.MaxBackoff {
+		p.add("outbox.initial_backoff", "longer than outbox.max_backoff")
+	}
+
+	// Policy
+	p.duration("policy.dedup_window", c.Policy.DedupWindow)
+	p.duration("policy.rate_limit.window", c.Policy.RateLimit.Window)
+	p.notNegative("policy.rate_limit.per_site", c.Policy.RateLimit.PerSite)
+	p.notNegative("policy.rate_limit.global", c.Policy.RateLimit.Global)
+	if (c.Policy.RateLimit.PerSite > 0 || c.Policy.RateLimit.Global > 0) && c.Policy.RateLimit.Window <= 0 {
+		p.add("policy.rate_limit.window", "required with a rate limit")
+	}
+	if qh := c.Policy.QuietHours; qh.Start != "" || qh.End != "" {
+		p.clock("policy.quiet_hours.start", qh.Start)
+		p.clock("policy.quiet_hours.end", qh.End)
+	}
+
+	p.duration("reachability.timeout", c.Reachability.Timeout)
+	for _, server := range c.Resolver.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			if net.ParseIP(server) == nil {
+				p.add("resolver.servers", "expected an IP address or host:port, got %q", server)
+			}
+			continue
+		}
+		p.hostPort("resolver.servers", server)
+	}
+	p.duration("resolver.timeout", c.Resolver.Timeout)
+
+	// Notification backends and routes
+	names := make(map[string]bool)
+	for i, nc := range c.Notifiers {
+		path := fmt.Sprintf("notifiers[%d]", i)
+		if nc.Type == "" {
+			p.add(path+".type", "required")
+		}
+		name := nc.Name
+		if name == "" {
+			name = nc.Type
+		}
+		if names[name] {
+			p.add(path+".name", "duplicate name %q", name)
+		}
+		names[name] = true
+
+		c.validateNotifier(&p, path, nc)
+	}
+	if c.Log.Syslog.Address != "" {
+		names["syslog"] = true
+	}
+	if len(c.Notifiers) == 0 {
+		names["email"] = true
+	}
+
+	for i, rc := range c.Routes {
+		path := fmt.Sprintf("routes[%d]", i)
+		for _, s := range rc.Match.Severity {
+			p.oneOf(path+".match.severity", strings.ToLower(s), severities)
+		}
+		for _, k := range rc.Match.Kind {
+			p.oneOf(path+".match.kind", strings.ToLower(k), kinds)
+		}
+		p.addresses(path+".to", rc.To)
+		p.addresses(path+".cc", rc.CC)
+		p.addresses(path+".bcc", rc.BCC)
+		for _, n := range rc.Notifiers {
+			if !names[n] {
+				p.add(path+".notifiers", "no notifier named %q", n)
+			}
+		}
+	}
+
+	p.positive("service.schedule", c.Service.Schedule)
+
+	return p
+}
+
+// ValidateService checks the settings that only the Windows service needs.
+// digger itself runs without them, so Validate leaves them out.
+func (c *Config) ValidateService() Problems {
+	var p Problems
+	if c.Service.DiggerPath == "" {
+		p.add("service.digger_path", "required")
+	}
+	p.file("service.digger_path", c.Service.DiggerPath)
+	return p
+}
+
+// usesEmail reports whether the smtp settings are in use: an email notifier
+// is listed, or none are and the smtp section is filled in.
+func (c *Config) usesEmail() bool {
+	for _, nc := range c.Notifiers {
+		if nc.Type == "email" {
+			return true
+		}
+	}
+	return c.Digest.Enabled || (len(c.Notifiers) == 0 && c.SMTP.Host != "")
+}
+
+func (c *Config) validateSMTP(p *Problems) {
+	sc := c.SMTP
+	if sc.Host == "" {
+		p.add("smtp.host", "required for email")
+	}
+	if sc.Port < 1 || sc.Port > 65535 {
+		p.add("smtp.port", "must be between 1 and 65535, got %d", sc.Port)
+	}
+	if _, err := mail.ParseAddress(sc.From); err != nil {
+		p.add("smtp.from", "invalid address %q", sc.From)
+	}
+	if len(sc.To) == 0 {
+		p.add("smtp.to", "at least one recipient is required")
+	}
+	p.addresses("smtp.to", sc.To)
+	p.addresses("smtp.cc", sc.CC)
+	p.addresses("smtp.bcc", sc.BCC)
+	p.duration("smtp.timeout", sc.Timeout)
+
+	p.file("smtp.template_path", sc.TemplatePath)
+	p.file("smtp.text_template_path", sc.TextTemplatePath)
+	p.file("smtp.batch_template_path", sc.BatchTemplatePath)
+	p.file("smtp.batch_text_template_path", sc.BatchTextTemplatePath)
+	for i, tc := range sc.Templates {
+		path := fmt.Sprintf("smtp.templates[%d]", i)
+		if tc.Severity != "" {
+			p.oneOf(path+".severity", strings.ToLower(tc.Severity), severities)
+		}
+		p.file(path+".template_path", tc.TemplatePath)
+		p.file(path+".text_template_path", tc.TextTemplatePath)
+	}
+
+	p.oneOf("smtp.tls.mode", sc.TLS.Mode, tlsModes)
+	p.file("smtp.tls.ca_file", sc.TLS.CAFile)
+	p.pair("smtp.tls", sc.TLS.CertFile, sc.TLS.KeyFile)
+	p.pair("smtp.smime", sc.SMIME.CertFile, sc.SMIME.KeyFile)
+}
+
+func (c *Config) validateNotifier(p *Problems, path string, nc NotifierConfig) {
+	switch nc.Type {
+	case "webhook":
+		if nc.Webhook == nil {
+			p.add(path+".webhook", "required for a webhook notifier")
+			return
+		}
+		p.url(path+".webhook.url", nc.Webhook.URL)
+		p.duration(path+".webhook.timeout", nc.Webhook.Timeout)
+		p.retry(path+".webhook.retry", nc.Webhook.Retry)
+	case "slack", "teams", "mattermost":
+		if nc.Chat == nil {
+			p.add(path+".chat", "required for a %s notifier", nc.Type)
+			return
+		}
+		p.url(path+".chat.url", nc.Chat.URL)
+		p.file(path+".chat.template_path", nc.Chat.TemplatePath)
+		p.duration(path+".chat.timeout", nc.Chat.Timeout)
+		p.retry(path+".chat.retry", nc.Chat.Retry)
+	case "jira", "servicenow":
+		if nc.Ticket == nil {
+			p.add(path+".ticket", "required for a %s notifier", nc.Type)
+			return
+		}
+		p.url(path+".ticket.url", nc.Ticket.URL)
+		if nc.Type == "jira" && nc.Ticket.Project == "" {
+			p.add(path+".ticket.project", "required for jira")
+		}
+		p.duration(path+".ticket.timeout", nc.Ticket.Timeout)
+		p.retry(path+".ticket.retry", nc.Ticket.Retry)
+	case "exec":
+		if nc.Exec == nil || nc.Exec.Command == "" {
+			p.add(path+".exec.command", "required for an exec notifier")
+			return
+		}
+		if nc.Exec.Dir != "" {
+			if fi, err := os.Stat(nc.Exec.Dir); err != nil || !fi.IsDir() {
+				p.add(path+".exec.dir", "directory not found: %s", nc.Exec.Dir)
+			}
+		}
+		p.duration(path+".exec.timeout", nc.Exec.Timeout)
+		p.retry(path+".exec.retry", nc.Exec.Retry)
+	}
+}
+
+func (p *Problems) hostPort(path, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		p.add(path, "expected host:port, got %q", addr)
+		return
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		p.add(path, "invalid port %q", port)
+	}
+}
+
+func (p *Problems) url(path, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		// Chat webhook URLs are credentials, so the value is not shown
+		p.add(path, "expected an http or https URL")
+	}
+}
+
+func (p *Problems) addresses(path string, list []string) {
+	for _, entry := range list {
+		if _, err := mail.ParseAddressList(entry); err != nil {
+			p.add(path, "invalid address %q", entry)
+		}
+	}
+}
+
+// file checks a configured file exists. Unset paths are fine.
+func (p *Problems) file(path, name string) {
+	if name == "" {
+		return
+	}
+	if _, err := os.Stat(name); err != nil {
+		p.add(path, "file not found: %s", name)
+	}
+}
+
+// pair checks a certificate and key are set together and exist.
+func (p *Problems) pair(path, cert, key string) {
+	if (cert == "") != (key == "") {
+		p.add(path, "cert_file and key_file must be set together")
+	}
+	p.file(path+".cert_file", cert)
+	p.file(path+".key_file", key)
+}
+
+// parentDir checks the directory a file will be created in exists.
+func (p *Problems) parentDir(path, name string) {
+	if name == "" {
+		p.add(path, "required")
+		return
+	}
+	dir := filepath.Dir(name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		p.add(path, "directory not found: %s", dir)
+	}
+}
+
+func (p *Problems) oneOf(path, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	var shown []string
+	for _, a := range allowed {
+		if a != "" {
+			shown = append(shown, a)
+		}
+	}
+	p.add(path, "invalid value %q, expected one of %s", value, strings.Join(shown, ", "))
+}
+
+func (p *Problems) notNegative(path string, n int) {
+	if n < 0 {
+		p.add(path, "must not be negative")
+	}
+}
+
+func (p *Problems) duration(path string, d time.Duration) {
+	if d < 0 {
+		p.add(path, "must not be negative")
+	}
+}
+
+func (p *Problems) positive(path string, d time.Duration) {
+	if d <= 0 {
+		p.add(path, "must be longer than zero")
+	}
+}
+
+func (p *Problems) retry(path string, rc RetryConfig) {
+	if rc.MaxAttempts < 0 {
+		p.add(path+".max_attempts", "must not be negative")
+	}
+	p.duration(path+".initial_backoff", rc.InitialBackoff)
+	p.duration(path+".max_backoff", rc.MaxBackoff)
+}
+
+func (p *Problems) clock(path, s string) {
+	if _, err := time.Parse("15:04", s); err != nil {
+		p.add(path, "expected HH:MM, got %q", s)
+	}
+}
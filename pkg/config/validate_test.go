@@ -0,0 +1,220 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkYAML(t *testing.T, content string) (*Config, Problems) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, problems, err := Check(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cfg, problems
+}
+
+func hasProblem(problems Problems, path, message string) bool {
+	for _, p := range problems {
+		if p.Path == path && strings.Contains(p.Message, message) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDefaults(t *testing.T) {
+	cfg, problems := checkYAML(t, "{}\n")
+	if len(problems) > 0 {
+		t.Fatalf("expected an empty config to be valid, got %v", problems)
+	}
+
+	if cfg.Log.Level != "info" || cfg.Log.File.Filename != "digger.log" || cfg.DB.Path != "sites.db" {
+		t.Errorf("unexpected defaults: %+v %+v", cfg.Log, cfg.DB)
+	}
+	if cfg.SMTP.Port != 25 || cfg.Digest.Period != 24*time.Hour || cfg.Outbox.MaxBackoff != 6*time.Hour {
+		t.Errorf("unexpected defaults: port %d, digest %s, backoff %s", cfg.SMTP.Port, cfg.Digest.Period, cfg.Outbox.MaxBackoff)
+	}
+	if cfg.Service.SitesPath != "sites.csv" {
+		t.Errorf("unexpected default service.sites_path %q", cfg.Service.SitesPath)
+	}
+	if cfg.Log.Syslog.Format != "rfc5424" || cfg.Reachability.Timeout != 5*time.Second {
+		t.Errorf("unexpected defaults: %+v", cfg.Log.Syslog)
+	}
+
+	// Settings that are present are kept
+	cfg, _ = checkYAML(t, "smtp:\n  tls:\n    mode: tls\ndb:\n  retention:\n    raw: 1h\n")
+	if cfg.SMTP.Port != 465 {
+		t.Errorf("expected the implicit TLS port, got %d", cfg.SMTP.Port)
+	}
+	if cfg.DB.Retention.Raw != time.Hour || cfg.DB.Retention.Daily != 0 {
+		t.Errorf("expected retention to be left alone, got %+v", cfg.DB.Retention)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	_, problems := checkYAML(t, `
+log:
+  levle: debug
+smtp:
+  to: [noc@example.com]
+  tls:
+    mdoe: tls
+notifiers:
+  - type: webhook
+    webhook:
+      url: https://hooks.example.com/digger
+      headers:
+        X-Anything: allowed
+      retry:
+        attempts: 3
+  - type: jira
+    ticket:
+      url: https://example.atlassian.net
+      project: OPS
+      fields:
+        anything: allowed
+extra: true
+`)
+
+	var paths []string
+	for _, p := range problems {
+		if p.Message == "unknown setting" {
+			paths = append(paths, p.Path)
+		}
+	}
+	expected := []string{"extra", "log.levle", "notifiers[0].webhook.retry.attempts", "smtp.tls.mdoe"}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected unknown keys %v, got %v", expected, paths)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, problems := checkYAML(t, `
+log:
+  level: loud
+  gelf:
+    address: graylog
+  file:
+    filename: missing/digger.log
+  syslog:
+    address: siem.example.com:514
+    facility: local9
+smtp:
+  host: smtp.example.com
+  port: 70000
+  from: not an address
+  cc: [noc@example.com, "bad@"]
+  template_path: missing.html
+  smime:
+    cert_file: smime.pem
+policy:
+  rate_limit:
+    per_site: 3
+  quiet_hours:
+    start: "22:00"
+    end: 7am
+outbox:
+  initial_backoff: 2h
+  max_backoff: 1h
+notifiers:
+  - type: email
+  - type: webhook
+    webhook:
+      url: hooks.example.com
+  - type: webhook
+  - type: exec
+    exec:
+      command: notify
+      dir: missing
+routes:
+  - match:
+      severity: urgent
+    notifiers: [pager]
+resolver:
+  servers: [192.0.2.53, "192.0.2.54:53", dns.example.com]
+`)
+
+	expected := []Problem{
+		{"log.level", "unknown level"},
+		{"log.gelf.address", "expected host:port"},
+		{"log.file.filename", "directory not found"},
+		{"log.syslog.facility", "invalid value"},
+		{"smtp.port", "between 1 and 65535"},
+		{"smtp.from", "invalid address"},
+		{"smtp.to", "at least one recipient"},
+		{"smtp.cc", `"bad@"`},
+		{"smtp.template_path", "file not found"},
+		{"smtp.smime", "must be set together"},
+		{"smtp.smime.cert_file", "file not found"},
+		{"policy.rate_limit.window", "required"},
+		{"policy.quiet_hours.end", "expected HH:MM"},
+		{"outbox.initial_backoff", "longer than"},
+		{"notifiers[1].webhook.url", "expected an http or https URL"},
+		{"notifiers[2].name", "duplicate name"},
+		{"notifiers[2].webhook", "required"},
+		{"notifiers[3].exec.dir", "directory not found"},
+		{"routes[0].match.severity", "invalid value"},
+		{"routes[0].notifiers", `no notifier named "pager"`},
+		{"resolver.servers", `"dns.example.com"`},
+	}
+	for _, e := range expected {
+		if !hasProblem(problems, e.Path, e.Message) {
+			t.Errorf("expected %s", e)
+		}
+	}
+	if len(problems) != len(expected) {
+		t.Errorf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+
+	err := error(problems)
+	if !strings.HasPrefix(err.Error(), "21 problems in config: log.level: unknown level") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateSMTPOnlyWhenUsed(t *testing.T) {
+	// Without an email notifier the smtp section may be left out
+	_, problems := checkYAML(t, `
+notifiers:
+  - type: exec
+    exec:
+      command: notify
+`)
+	if len(problems) > 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	_, problems = checkYAML(t, "smtp:\n  host: smtp.example.com\n")
+	if !hasProblem(problems, "smtp.to", "at least one recipient") || !hasProblem(problems, "smtp.from", "invalid address") {
+		t.Errorf("expected smtp problems, got %v", problems)
+	}
+}
+
+func TestValidateService(t *testing.T) {
+	// digger runs without the service settings
+	cfg, problems := checkYAML(t, "service:\n  digger_path: missing.exe\n")
+	if len(problems) > 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+
+	problems = cfg.ValidateService()
+	if len(problems) != 1 || !hasProblem(problems, "service.digger_path", "file not found") {
+		t.Errorf("expected a missing digger_path, got %v", problems)
+	}
+
+	cfg.Service.DiggerPath = ""
+	problems = cfg.ValidateService()
+	if len(problems) != 1 || !hasProblem(problems, "service.digger_path", "required") {
+		t.Errorf("expected digger_path to be required, got %v", problems)
+	}
+}
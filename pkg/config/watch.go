@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports when watched files are written. Editors often save in
+// several steps, or by replacing the file, so the directories are watched
+// and a change is only reported once the files have been quiet for the
+// settle time.
+type Watcher struct {
+	// Changes receives the files that changed, sorted.
+	Changes <-chan []string
+	// Errors receives errors from the file system watcher.
+	Errors <-chan error
+
+	fw   *fsnotify.Watcher
+	done chan struct{}
+}
+
+// Watch starts watching files.
+func Watch(settle time.Duration, files ...string) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %w", err)
+	}
+
+	watched := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			fw.Close()
+			return nil, err
+		}
+		watched[abs] = true
+
+		dir := filepath.Dir(abs)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		err = fw.Add(dir)
+		if err != nil {
+			fw.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
+
+	changes := make(chan []string)
+	errs := make(chan error)
+	w := &Watcher{Changes: changes, Errors: errs, fw: fw, done: make(chan struct{})}
+	go w.run(watched, settle, changes, errs)
+	return w, nil
+}
+
+func (w *Watcher) run(watched map[string]bool, settle time.Duration, changes chan<- []string, errs chan<- error) {
+	pending := make(map[string]bool)
+	timer := time.NewTimer(settle)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case ev, ok := <-w.fw.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(ev.Name)
+			if !watched[name] || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			pending[name] = true
+			timer.Reset(settle)
+
+		case err, ok := <-w.fw.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case errs <- err:
+			case <-w.done:
+				return
+			}
+
+		case <-timer.C:
+			var names []string
+			for name := range pending {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			pending = make(map[string]bool)
+
+			select {
+			case changes <- names:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// Close stops watching.
+func (w *Watcher) Close() error {
+	close(w.done)
+	return w.fw.Close()
+}
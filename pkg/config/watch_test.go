@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.yaml")
+	sites := filepath.Join(dir, "sites.csv")
+	for _, f := range []string{cfgFile, sites} {
+		err := os.WriteFile(f, []byte("a"), 0644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %v", f, err)
+		}
+	}
+
+	w, err := Watch(200*time.Millisecond, cfgFile, sites)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.Close()
+
+	next := func() []string {
+		select {
+		case files := <-w.Changes:
+			return files
+		case err := <-w.Errors:
+			t.Fatalf("watch error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a change")
+		}
+		return nil
+	}
+
+	// Several writes, and files that are not watched, settle into one change
+	for i := 0; i < 3; i++ {
+		os.WriteFile(cfgFile, []byte("b"), 0644)
+		os.WriteFile(filepath.Join(dir, "other.txt"), []byte("b"), 0644)
+	}
+	files := next()
+	if len(files) != 1 || files[0] != cfgFile {
+		t.Errorf("expected %s, got %v", cfgFile, files)
+	}
+
+	// Replacing a file, as many editors do, is seen too
+	tmp := filepath.Join(dir, "sites.csv.tmp")
+	os.WriteFile(tmp, []byte("c"), 0644)
+	os.Rename(tmp, sites)
+	os.WriteFile(cfgFile, []byte("c"), 0644)
+	files = next()
+	if len(files) != 2 || files[0] != cfgFile || files[1] != sites {
+		t.Errorf("expected both files, got %v", files)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "config.yaml")
+	snapshot := filepath.Join(dir, "config.applied.yaml")
+
+	err := os.WriteFile(src, []byte("service:\n  schedule: 2h\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	cfg, err := Snapshot(src, snapshot, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Path != snapshot || cfg.Service.Schedule != 2*time.Hour {
+		t.Errorf("unexpected config from %s: %+v", cfg.Path, cfg.Service)
+	}
+
+	// An invalid file leaves the snapshot alone
+	err = os.WriteFile(src, []byte("service:\n  schedul: 1h\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	_, err = Snapshot(src, snapshot, nil)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	data, err := os.ReadFile(snapshot)
+	if err != nil || string(data) != "service:\n  schedule: 2h\n" {
+		t.Errorf("expected the snapshot to be kept, got %q, %v", data, err)
+	}
+
+	// So does one the extra check rejects
+	err = os.WriteFile(src, []byte("service:\n  digger_path: missing.exe\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	_, err = Snapshot(src, snapshot, (*Config).ValidateService)
+	if err == nil || !strings.Contains(err.Error(), "service.digger_path: file not found") {
+		t.Fatalf("expected a digger_path error, got %v", err)
+	}
+	data, err = os.ReadFile(snapshot)
+	if err != nil || string(data) != "service:\n  schedule: 2h\n" {
+		t.Errorf("expected the snapshot to be kept, got %q, %v", data, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Errorf("expected no temporary files left, got %v", entries)
+	}
+}
@@ -0,0 +1,260 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/report"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"go.etcd.io/bbolt"
+)
+
+// lastSentKey is the meta bucket key holding the end of the last period a
+// digest was sent for.
+const lastSentKey = "digest_last_sent"
+
+// Failure is a site whose most recent lookup did not produce an address.
+type Failure struct {
+	Hostname   string
+	Port       int
+	EntityName string
+	Since      time.Time
+	Error      string
+}
+
+// Unacknowledged is a change whose new addresses are not yet in the
+// inventory file.
+type Unacknowledged struct {
+	Change       event.ChangeEvent
+	InventoryIPs []string
+}
+
+// Data is everything the digest template renders.
+type Data struct {
+	From           time.Time
+	To             time.Time
+	Changes        []event.ChangeEvent
+	Failing        []Failure
+	Pending        []event.ChangeEvent
+	Unacknowledged []Unacknowledged
+	Allowlist      []report.Site
+}
+
+// Period returns the period the next digest covers and whether it is due.
+// A period starts where the last one sent ended, so a missed run makes the
+// next digest cover more time rather than skip anything.
+func Period(db *bbolt.DB, every time.Duration, now time.Time) (time.Time, bool, error) {
+	var from time.Time
+	err := db.View(func(tx *bbolt.Tx) error {
+		v := store.GetMeta(tx, lastSentKey)
+		if v == nil {
+			from = now.Add(-every)
+			return nil
+		}
+
+		var err error
+		from, err = time.Parse(time.RFC3339Nano, string(v))
+		if err != nil {
+			return fmt.Errorf("invalid last digest time %q: %w", v, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return from, !now.Before(from.Add(every)), nil
+}
+
+// MarkSent records that a digest covering up to to has been sent.
+func MarkSent(db *bbolt.DB, to time.Time) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		return store.PutMeta(tx, lastSentKey, []byte(to.Format(time.RFC3339Nano)))
+	})
+}
+
+// Build collects digest data for the period [from, to). allowlist is the
+// current state of every site and inventory is what the inventory file
+// currently lists.
+func Build(db *bbolt.DB, allowlist, inventory []report.Site, from, to time.Time) (Data, error) {
+	data := Data{
+		From:      from,
+		To:        to,
+		Allowlist: allowlist,
+	}
+
+	events, err := store.ListEvents(db)
+	if err != nil {
+		return data, err
+	}
+
+	for _, ev := range report.Select(events, report.Filter{Since: from, Until: to}) {
+		if ipChange(ev) {
+			data.Changes = append(data.Changes, ev)
+		}
+	}
+
+	latest := make(map[string]event.ChangeEvent)
+	for _, ev := range report.Select(events, report.Filter{Until: to}) {
+		switch ev.NotificationStatus {
+		case event.StatusSent, event.StatusUnknown, event.StatusSuppressed:
+		default:
+			data.Pending = append(data.Pending, ev)
+		}
+		if ipChange(ev) {
+			latest[ev.Hostname] = ev
+		}
+	}
+
+	inventoryIPs := make(map[string][]string)
+	for _, s := range inventory {
+		inventoryIPs[s.Hostname] = s.IPs
+	}
+
+	for hostname, ev := range latest {
+		ips, ok := inventoryIPs[hostname]
+		if !ok || overlaps(ips, ev.NewIPs) {
+			continue
+		}
+		data.Unacknowledged = append(data.Unacknowledged, Unacknowledged{Change: ev, InventoryIPs: ips})
+	}
+	sort.Slice(data.Unacknowledged, func(i, j int) bool {
+		return data.Unacknowledged[i].Change.Hostname < data.Unacknowledged[j].Change.Hostname
+	})
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		for _, s := range allowlist {
+			o, err := store.ObservationAt(tx, s.Hostname, to)
+			if err != nil {
+				return err
+			}
+			if o == nil || (o.Error == "" && hasIPv4(o.Answers)) {
+				continue
+			}
+
+			msg := o.Error
+			if msg == "" {
+				msg = "no IPv4 addresses found"
+			}
+			data.Failing = append(data.Failing, Failure{
+				Hostname:   s.Hostname,
+				Port:       s.Port,
+				EntityName: s.EntityName,
+				Since:      o.Time,
+				Error:      msg,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return data, err
+	}
+
+	return data, nil
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasIPv4(answers []string) bool {
+	for _, a := range answers {
+		ip := net.ParseIP(a)
+		if ip != nil && ip.To4() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Render executes the HTML digest template at templatePath.
+func Render(templatePath string, data Data) (string, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read digest template file: %w", err)
+	}
+
+	t, err := template.New(filepath.Base(templatePath)).Funcs(report.TemplateFuncs).Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse digest template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render digest template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+func Subject(data Data) string {
+	return fmt.Sprintf("Digger digest %s to %s: %d changes, %d failing sites",
+		data.From.Format("2006-01-02 15:04"), data.To.Format("2006-01-02 15:04"), len(data.Changes), len(data.Failing))
+}
+
+// RenderText executes the plain text digest template at templatePath.
+func RenderText(templatePath string, data Data) (string, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read plain text digest template file: %w", err)
+	}
+
+	t, err := texttemplate.New(filepath.Base(templatePath)).Funcs(report.TemplateFuncs).Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse plain text digest template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render plain text digest template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// Send renders the digest with the HTML and plain text templates and
+// emails it using the SMTP settings in cfg, then records the period as
+// sent.
+func Send(cfg *config.Config, db *bbolt.DB, htmlPath, textPath string, data Data) error {
+	html, err := Render(htmlPath, data)
+	if err != nil {
+		return err
+	}
+	text, err := RenderText(textPath, data)
+	if err != nil {
+		return err
+	}
+
+	err = notification.SendMail(cfg, Subject(data), text, html)
+	if err != nil {
+		return err
+	}
+
+	return MarkSent(db, data.To)
+}
+
+// ipChange reports whether ev records an address change. Lookup failures
+// and unreachable sites are stored alongside changes but leave the
+// site's addresses as they were.
+func ipChange(ev event.ChangeEvent) bool {
+	return ev.Kind == event.KindIPChanged || ev.Kind == ""
+}
@@ -0,0 +1,105 @@
+package digest
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/report"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestPeriod(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// First run covers the last period and is due
+	from, due, err := Period(db, 24*time.Hour, now)
+	require.NoError(t, err)
+	assert.True(t, due)
+	assert.Equal(t, now.Add(-24*time.Hour), from)
+
+	require.NoError(t, MarkSent(db, now))
+
+	from, due, err = Period(db, 24*time.Hour, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, due)
+	assert.True(t, from.Equal(now))
+
+	// A missed day widens the next period instead of skipping it
+	from, due, err = Period(db, 24*time.Hour, now.Add(72*time.Hour))
+	require.NoError(t, err)
+	assert.True(t, due)
+	assert.True(t, from.Equal(now))
+}
+
+func TestBuildAndRender(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	from := now.Add(-24 * time.Hour)
+
+	events := []*event.ChangeEvent{
+		{SiteID: "a.example.com", Hostname: "a.example.com", Time: from.Add(-time.Hour), NewIPs: []string{"1.1.1.1"}, NotificationStatus: event.StatusFailed},
+		{SiteID: "a.example.com", Hostname: "a.example.com", Time: from.Add(time.Hour), OldIPs: []string{"1.1.1.1"}, NewIPs: []string{"1.1.1.2"}, NotificationStatus: event.StatusSent},
+		{SiteID: "b.example.com", Hostname: "b.example.com", Time: from.Add(2 * time.Hour), NewIPs: []string{"2.2.2.2"}, NotificationStatus: event.StatusSent},
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, ev := range events {
+			require.NoError(t, store.PutEvent(tx, ev))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, store.PutObservations(db, []store.Observation{
+		{Hostname: "a.example.com", Time: now.Add(-time.Hour), Answers: []string{"1.1.1.2"}},
+		{Hostname: "c.example.com", Time: now.Add(-time.Hour), Error: "no such host"},
+	}))
+
+	allowlist := []report.Site{
+		{Hostname: "a.example.com", Port: 22, IPs: []string{"1.1.1.2"}},
+		{Hostname: "b.example.com", Port: 443, IPs: []string{"2.2.2.2"}},
+		{Hostname: "c.example.com", Port: 443, IPs: []string{"3.3.3.3"}},
+	}
+	inventory := []report.Site{
+		{Hostname: "a.example.com", IPs: []string{"1.1.1.1"}},
+		{Hostname: "b.example.com", IPs: []string{"2.2.2.2"}},
+		{Hostname: "c.example.com", IPs: []string{"3.3.3.3"}},
+	}
+
+	data, err := Build(db, allowlist, inventory, from, now)
+	require.NoError(t, err)
+
+	assert.Len(t, data.Changes, 2)
+	require.Len(t, data.Pending, 1)
+	assert.Equal(t, event.StatusFailed, data.Pending[0].NotificationStatus)
+	require.Len(t, data.Unacknowledged, 1)
+	assert.Equal(t, "a.example.com", data.Unacknowledged[0].Change.Hostname)
+	require.Len(t, data.Failing, 1)
+	assert.Equal(t, "c.example.com", data.Failing[0].Hostname)
+	assert.Equal(t, "no such host", data.Failing[0].Error)
+
+	body, err := Render("../../templates/digest.html", data)
+	require.NoError(t, err)
+	assert.Contains(t, body, "1.1.1.2")
+	assert.Contains(t, body, "no such host")
+	assert.Contains(t, body, "3.3.3.3")
+
+	text, err := RenderText("../../templates/digest.txt", data)
+	require.NoError(t, err)
+	assert.Contains(t, text, "1.1.1.1 -> 1.1.1.2")
+	assert.Contains(t, text, "Sites failing to resolve (1)")
+	assert.NotContains(t, text, "<")
+
+	assert.Contains(t, Subject(data), "2 changes, 1 failing sites")
+}
@@ -0,0 +1,80 @@
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+type Kind string
+
+const (
+	KindIPChanged    Kind = "ip_changed"
+	KindLookupFailed Kind = "lookup_failed"
+	KindUnreachable  Kind = "unreachable"
+)
+
+// Kinds lists every kind of event.
+var Kinds = []Kind{KindIPChanged, KindLookupFailed, KindUnreachable}
+
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusSent    Status = "sent"
+	StatusFailed  Status = "failed"
+	StatusPartial Status = "partial"
+	StatusUnknown Status = "unknown"
+	// StatusSuppressed and StatusHeld are set when the notification policy
+	// drops an event or holds it until quiet hours end.
+	StatusSuppressed Status = "suppressed"
+	StatusHeld       Status = "held"
+)
+
+// Delivery is the outcome of handing an event to one notification backend.
+type Delivery struct {
+	Backend  string    `json:"backend"`
+	Status   Status    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error,omitempty"`
+	Ticket   string    `json:"ticket,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// ChangeEvent records a single change detected for a site. It is what gets
+// stored in the changes bucket and what every report reads back.
+type ChangeEvent struct {
+	ID                 string     `json:"id"`
+	SiteID             string     `json:"site_id"`
+	Hostname           string     `json:"hostname"`
+	Port               int        `json:"port"`
+	EntityName         string     `json:"entity_name"`
+	Clients            []string   `json:"clients,omitempty"`
+	Tags               []string   `json:"tags,omitempty"`
+	Owners             []string   `json:"owners,omitempty"`
+	Kind               Kind       `json:"kind"`
+	OldIPs             []string   `json:"old_ips"`
+	NewIPs             []string   `json:"new_ips"`
+	Error              string     `json:"error,omitempty"`
+	Resolver           string     `json:"resolver"`
+	RunID              string     `json:"run_id"`
+	Severity           Severity   `json:"severity"`
+	Time               time.Time  `json:"time"`
+	NotificationStatus Status     `json:"notification_status"`
+	Deliveries         []Delivery `json:"deliveries,omitempty"`
+}
+
+// NewRunID returns an identifier shared by every event produced in one run.
+func NewRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
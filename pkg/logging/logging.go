@@ -27,7 +27,11 @@ func SetupLogging(cfg *config.Config) (*os.File, error) {
 
 	logrus.SetLevel(level)
 
-	// Set up logging to a GELF server
+	// Set up logging to a GELF server, if one is configured
+	if cfg.Log.Gelf.Address == "" {
+		return file, nil
+	}
+
 	gelfWriter, err := gelf.NewWriter(cfg.Log.Gelf.Address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup GELF server: %w", err)
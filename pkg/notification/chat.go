@@ -0,0 +1,152 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+)
+
+func init() {
+	Register("slack", newChatNotifier)
+	Register("teams", newChatNotifier)
+	Register("mattermost", newChatNotifier)
+}
+
+// ChatData is what chat message templates render.
+type ChatData struct {
+	Event *event.ChangeEvent
+}
+
+// ChatNotifier posts change events to a Slack, Microsoft Teams or Mattermost
+// incoming webhook. The message layout comes from <type>.json in the
+// templates directory, or from chat.template_path.
+type ChatNotifier struct {
+	name   string
+	url    string
+	tmpl   *template.Template
+	client *http.Client
+	retry  RetryPolicy
+}
+
+func newChatNotifier(nc config.NotifierConfig, env Env) (Notifier, error) {
+	if nc.Chat == nil {
+		return nil, errors.New("chat settings missing")
+	}
+
+	u, err := url.Parse(nc.Chat.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s webhook url", nc.Type)
+	}
+
+	tmpl, err := loadTemplate(nc.Chat.TemplatePath, nc.Type+".json", templateFuncs)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := nc.Chat.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &ChatNotifier{
+		name:   nc.Name,
+		url:    nc.Chat.URL,
+		tmpl:   tmpl,
+		client: &http.Client{Timeout: timeout},
+		retry:  NewRetryPolicy(nc.Chat.Retry),
+	}, nil
+}
+
+func (n *ChatNotifier) Name() string {
+	return n.name
+}
+
+func (n *ChatNotifier) RetryPolicy() RetryPolicy {
+	return n.retry
+}
+
+// Render produces the JSON message for ev.
+func (n *ChatNotifier) Render(ev *event.ChangeEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	err := n.tmpl.Execute(&buf, ChatData{Event: ev})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s template: %w", n.name, err)
+	}
+
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("%s template did not produce valid JSON", n.name)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (n *ChatNotifier) Preview(ev *event.ChangeEvent) (Preview, error) {
+	body, err := n.Render(ev)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	var out bytes.Buffer
+	json.Indent(&out, body, "", "  ")
+	return Preview{Subject: Title(ev), Target: endpoint(n.url), Body: out.String()}, nil
+}
+
+func (n *ChatNotifier) Notify(ctx context.Context, ev *event.ChangeEvent) error {
+	body, err := n.Render(ev)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	return hidePath(postJSON(ctx, n.client, n.url, nil, "", "", body), n.url)
+}
+
+// hidePath removes the path of a chat webhook URL, which is its
+// credential, from err so it does not end up in the log.
+func hidePath(err error, raw string) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := strings.ReplaceAll(err.Error(), raw, endpoint(raw))
+	if u, perr := url.Parse(raw); perr == nil {
+		msg = strings.ReplaceAll(msg, u.Redacted(), endpoint(raw))
+	}
+
+	hidden := errors.New(msg)
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return Permanent(hidden)
+	}
+	return hidden
+}
+
+func severityColor(s event.Severity) string {
+	switch s {
+	case event.SeverityCritical:
+		return "#d00000"
+	case event.SeverityWarning:
+		return "#f2c744"
+	}
+	return "#36a64f"
+}
+
+// teamsColor maps severity onto the Adaptive Card text colours.
+func teamsColor(s event.Severity) string {
+	switch s {
+	case event.SeverityCritical:
+		return "Attention"
+	case event.SeverityWarning:
+		return "Warning"
+	}
+	return "Good"
+}
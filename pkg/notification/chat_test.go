@@ -0,0 +1,132 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chatEvent() *event.ChangeEvent {
+	return &event.ChangeEvent{
+		ID:         "0001",
+		Hostname:   "sftp.vendor.com",
+		Port:       22,
+		EntityName: `Vendor "Quoted" Inc`,
+		Kind:       event.KindIPChanged,
+		OldIPs:     []string{"1.1.1.1"},
+		NewIPs:     []string{"2.2.2.2", "2.2.2.3"},
+		Severity:   event.SeverityCritical,
+		Time:       time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestChatTemplates(t *testing.T) {
+	for _, kind := range []string{"slack", "teams", "mattermost"} {
+		t.Run(kind, func(t *testing.T) {
+			n, err := newChatNotifier(config.NotifierConfig{
+				Type: kind,
+				Name: kind,
+				Chat: &config.ChatConfig{URL: "https://chat.example.com/hook"},
+			}, Env{})
+			require.NoError(t, err)
+
+			body, err := n.(*ChatNotifier).Render(chatEvent())
+			require.NoError(t, err)
+
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &decoded))
+
+			rendered := string(body)
+			assert.Contains(t, rendered, "sftp.vendor.com")
+			assert.Contains(t, rendered, "22")
+			assert.Contains(t, rendered, `Vendor \"Quoted\" Inc`)
+			assert.Contains(t, rendered, "1.1.1.1")
+			assert.Contains(t, rendered, "2.2.2.2, 2.2.2.3")
+			assert.Contains(t, rendered, "critical")
+		})
+	}
+}
+
+func TestChatTemplateOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slack.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"text": {{json .Event.Hostname}}}`), 0644))
+
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(body, &got))
+	}))
+	defer server.Close()
+
+	n, err := newChatNotifier(config.NotifierConfig{
+		Type: "slack",
+		Name: "slack",
+		Chat: &config.ChatConfig{URL: server.URL, TemplatePath: path},
+	}, Env{})
+	require.NoError(t, err)
+
+	require.NoError(t, n.Notify(context.Background(), chatEvent()))
+	assert.Equal(t, "sftp.vendor.com", got["text"])
+}
+
+func TestChatInvalidTemplateOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teams.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"text": {{.Event.Hostname}}}`), 0644))
+
+	n, err := newChatNotifier(config.NotifierConfig{
+		Type: "teams",
+		Name: "teams",
+		Chat: &config.ChatConfig{URL: "https://chat.example.com/hook", TemplatePath: path},
+	}, Env{})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), chatEvent())
+	assert.ErrorContains(t, err, "valid JSON")
+}
+
+func TestChatConfigErrors(t *testing.T) {
+	_, err := newChatNotifier(config.NotifierConfig{Type: "slack"}, Env{})
+	assert.Error(t, err)
+
+	_, err = newChatNotifier(config.NotifierConfig{Type: "slack", Chat: &config.ChatConfig{URL: "not a url"}}, Env{})
+	assert.Error(t, err)
+
+	_, err = newChatNotifier(config.NotifierConfig{Type: "slack", Chat: &config.ChatConfig{URL: "https://x", TemplatePath: "missing.json"}}, Env{})
+	assert.Error(t, err)
+}
+
+func TestChatErrorsHideWebhookPath(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	url := srv.URL + "/services/T000/B000/XXXX"
+	n, err := newChatNotifier(config.NotifierConfig{Type: "slack", Name: "slack", Chat: &config.ChatConfig{URL: url}}, Env{})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), chatEvent())
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "XXXX")
+	assert.Contains(t, err.Error(), srv.URL+" returned 404")
+	var permanent *permanentError
+	assert.ErrorAs(t, err, &permanent)
+
+	// Connection errors quote the URL too
+	srv.Close()
+	err = n.Notify(context.Background(), chatEvent())
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "XXXX")
+}
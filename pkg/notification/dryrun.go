@@ -0,0 +1,232 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+)
+
+// Preview is a notification rendered the way a backend would send it.
+// Email previews have recipients, other backends name where the
+// notification would go in Target.
+type Preview struct {
+	Backend  string
+	Hostname string
+	Subject  string
+	Recipients
+	Target string
+	Body   string
+	HTML   string
+}
+
+// Previewer is implemented by backends that can render a notification
+// without sending it.
+type Previewer interface {
+	Preview(ev *event.ChangeEvent) (Preview, error)
+}
+
+// BatchPreviewer renders what NotifyBatch would send.
+type BatchPreviewer interface {
+	PreviewBatch(evs []*event.ChangeEvent) ([]Preview, error)
+}
+
+// preview renders ev for n. Backends without their own preview show the
+// webhook payload they would be handed.
+func preview(n Notifier, ev *event.ChangeEvent) (Preview, error) {
+	var p Preview
+	var err error
+	if pv, ok := n.(Previewer); ok {
+		p, err = pv.Preview(ev)
+	} else {
+		p.Subject = Title(ev)
+		p.Body, err = previewPayload(ev)
+	}
+	if err != nil {
+		return p, err
+	}
+
+	p.Backend = n.Name()
+	if p.Hostname == "" {
+		p.Hostname = ev.Hostname
+	}
+	return p, nil
+}
+
+// previewPayload is the webhook payload for ev, indented for reading.
+func previewPayload(ev *event.ChangeEvent) (string, error) {
+	body, err := json.MarshalIndent(WebhookPayload{
+		Version: WebhookPayloadVersion,
+		Type:    ev.Kind,
+		SentAt:  time.Now().UTC(),
+		Event:   ev,
+	}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return string(body), nil
+}
+
+// PreviewWriter prints previews to out, or writes each to its own file in
+// dir and prints the file names.
+type PreviewWriter struct {
+	mu    sync.Mutex
+	out   io.Writer
+	dir   string
+	count int
+}
+
+func NewPreviewWriter(out io.Writer, dir string) (*PreviewWriter, error) {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create preview directory: %w", err)
+		}
+	}
+
+	return &PreviewWriter{out: out, dir: dir}, nil
+}
+
+// Count returns the number of previews written.
+func (w *PreviewWriter) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (w *PreviewWriter) Write(p Preview) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.count++
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Backend: %s\n", p.Backend)
+	if p.Subject != "" {
+		fmt.Fprintf(&buf, "Subject: %s\n", p.Subject)
+	}
+	for _, h := range []struct {
+		name string
+		list []string
+	}{{"To", p.To}, {"Cc", p.CC}, {"Bcc", p.BCC}} {
+		if len(h.list) > 0 {
+			fmt.Fprintf(&buf, "%s: %s\n", h.name, strings.Join(h.list, ", "))
+		}
+	}
+	if p.Target != "" {
+		fmt.Fprintf(&buf, "Target: %s\n", p.Target)
+	}
+	buf.WriteString("\n")
+	buf.WriteString(strings.TrimRight(p.Body, "\n"))
+	buf.WriteString("\n")
+
+	if w.dir == "" {
+		_, err := fmt.Fprintf(w.out, "----- %d -----\n%s\n", w.count, buf.String())
+		return err
+	}
+
+	base := filepath.Join(w.dir, fmt.Sprintf("%03d-%s-%s", w.count, fileName(p.Backend), fileName(p.Hostname)))
+	err := os.WriteFile(base+".txt", []byte(buf.String()), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write preview: %w", err)
+	}
+	fmt.Fprintln(w.out, base+".txt")
+
+	if p.HTML != "" {
+		err = os.WriteFile(base+".html", []byte(p.HTML), 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to write preview: %w", err)
+		}
+		fmt.Fprintln(w.out, base+".html")
+	}
+
+	return nil
+}
+
+// endpoint shortens a URL to its scheme and host, as the rest of a chat
+// webhook URL is its secret.
+func endpoint(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func fileName(s string) string {
+	s = unsafeFileChars.ReplaceAllString(s, "_")
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+// dryRunNotifier renders notifications to a PreviewWriter instead of
+// sending them.
+type dryRunNotifier struct {
+	Notifier
+	w *PreviewWriter
+}
+
+// DryRun wraps notifiers so every notification is previewed to w instead of
+// sent. The wrapped notifiers keep their subscriptions and batching.
+func DryRun(notifiers []Notifier, w *PreviewWriter) []Notifier {
+	wrapped := make([]Notifier, len(notifiers))
+	for i, n := range notifiers {
+		wrapped[i] = &dryRunNotifier{Notifier: n, w: w}
+	}
+	return wrapped
+}
+
+func (n *dryRunNotifier) Subscribes(kind event.Kind) bool {
+	return subscribes(n.Notifier, kind)
+}
+
+func (n *dryRunNotifier) Batching() bool {
+	return batching(n.Notifier)
+}
+
+func (n *dryRunNotifier) Notify(_ context.Context, ev *event.ChangeEvent) error {
+	p, err := preview(n.Notifier, ev)
+	if err != nil {
+		return Permanent(err)
+	}
+	return n.w.Write(p)
+}
+
+func (n *dryRunNotifier) NotifyBatch(ctx context.Context, evs []*event.ChangeEvent) error {
+	b, ok := n.Notifier.(BatchPreviewer)
+	if !ok {
+		for _, ev := range evs {
+			err := n.Notify(ctx, ev)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	previews, err := b.PreviewBatch(evs)
+	if err != nil {
+		return Permanent(err)
+	}
+	for _, p := range previews {
+		p.Backend = n.Name()
+		err = n.w.Write(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
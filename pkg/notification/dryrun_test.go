@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	cfg := routeConfig()
+	cfg.SMTP.Host = "127.0.0.1"
+	cfg.SMTP.Port = 1 // nothing listens here
+	cfg.Routes = cfg.Routes[:1]
+
+	email, err := newEmailNotifier(config.NotifierConfig{Name: "email"}, Env{Config: cfg})
+	require.NoError(t, err)
+	hook := &fakeNotifier{name: "hook"}
+
+	var out bytes.Buffer
+	w, err := NewPreviewWriter(&out, "")
+	require.NoError(t, err)
+	d := NewDispatcher(DryRun([]Notifier{email, hook}, w))
+
+	ev := testChange()
+	ev.EntityName = "Acme Payments"
+	d.Dispatch(context.Background(), ev)
+
+	assert.Empty(t, hook.got)
+	assert.Equal(t, event.StatusSent, ev.NotificationStatus)
+	assert.Equal(t, 2, w.Count())
+
+	text := out.String()
+	assert.Contains(t, text, "Backend: email\nSubject: IP Address Change Notification: "+ev.Hostname)
+	assert.Contains(t, text, `To: payments@example.com, "Payments Lead" <lead@example.com>`)
+	assert.Contains(t, text, "Cc: noc@example.com")
+	assert.Contains(t, text, "Backend: hook\n")
+	assert.Contains(t, text, `"type": "ip_changed"`)
+
+	// Lookup failures only go to notifiers that subscribe to them
+	failure := &event.ChangeEvent{Hostname: "gone.example.com", Kind: event.KindLookupFailed}
+	d.Dispatch(context.Background(), failure)
+	assert.Equal(t, 2, w.Count())
+}
+
+func TestDryRunBatchToFiles(t *testing.T) {
+	cfg := routeConfig()
+	cfg.SMTP.Host = "127.0.0.1"
+	cfg.SMTP.Port = 1
+	cfg.Routes = cfg.Routes[:1]
+
+	email, err := newEmailNotifier(config.NotifierConfig{Name: "email", Batch: true}, Env{Config: cfg})
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "previews")
+	var out bytes.Buffer
+	w, err := NewPreviewWriter(&out, dir)
+	require.NoError(t, err)
+	d := NewDispatcher(DryRun([]Notifier{email}, w))
+	require.True(t, d.Batching())
+
+	evs := []*event.ChangeEvent{
+		{Hostname: "a.example.com", EntityName: "Acme Payments", Kind: event.KindIPChanged},
+		{Hostname: "b.example.com", EntityName: "Other", Kind: event.KindIPChanged},
+	}
+	d.DispatchBatch(context.Background(), evs)
+	assert.Equal(t, 2, w.Count())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "001-email-batch.html"),
+		filepath.Join(dir, "001-email-batch.txt"),
+		filepath.Join(dir, "002-email-batch.html"),
+		filepath.Join(dir, "002-email-batch.txt"),
+	}, files)
+	assert.Contains(t, out.String(), "001-email-batch.txt")
+
+	data, err := os.ReadFile(filepath.Join(dir, "002-email-batch.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Subject: IP Address Change Notification: 1 changes\nTo: noc@example.com\nBcc: audit@example.com\n")
+	assert.Contains(t, string(data), "b.example.com")
+}
+
+func TestPreviewHidesChatWebhookPath(t *testing.T) {
+	assert.Equal(t, "https://hooks.slack.com", endpoint("https://hooks.slack.com/services/T000/B000/XXXX"))
+}
@@ -0,0 +1,160 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register("email", newEmailNotifier)
+}
+
+// EmailNotifier sends change events through the SMTP settings in config.yaml.
+// With batch set, every change from a run goes out in one email.
+type EmailNotifier struct {
+	name      string
+	cfg       *config.Config
+	templates *EmailTemplates
+	transport *SMTPTransport
+	routes    *Routes
+	batch     bool
+}
+
+func newEmailNotifier(nc config.NotifierConfig, env Env) (Notifier, error) {
+	templates, err := NewEmailTemplates(env.Config.SMTP)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := NewSMTPTransport(env.Config.SMTP)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := NewRoutes(env.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailNotifier{
+		name:      nc.Name,
+		cfg:       env.Config,
+		templates: templates,
+		transport: transport,
+		routes:    routes,
+		batch:     nc.Batch,
+	}, nil
+}
+
+func (n *EmailNotifier) Name() string {
+	return n.name
+}
+
+func (n *EmailNotifier) Notify(_ context.Context, ev *event.ChangeEvent) error {
+	rcpt := n.routes.Recipients(ev)
+	subject, text, html, err := n.templates.Render(NewEmailData(n.cfg.SMTP, rcpt, ev))
+	if err != nil {
+		return Permanent(err)
+	}
+
+	if len(rcpt.To)+len(rcpt.CC)+len(rcpt.BCC) == 0 {
+		return Permanent(fmt.Errorf("no recipients for %s", ev.Hostname))
+	}
+
+	logrus.Infof("Sending email notification to %s", describe(rcpt))
+	return n.transport.Send(NewMessage(n.cfg.SMTP, rcpt, subject, text, html))
+}
+
+func (n *EmailNotifier) Preview(ev *event.ChangeEvent) (Preview, error) {
+	rcpt := n.routes.Recipients(ev)
+	subject, text, html, err := n.templates.Render(NewEmailData(n.cfg.SMTP, rcpt, ev))
+	if err != nil {
+		return Preview{}, err
+	}
+
+	return Preview{
+		Subject:    subject,
+		Recipients: rcpt,
+		Body:       text,
+		HTML:       html,
+	}, nil
+}
+
+func (n *EmailNotifier) PreviewBatch(evs []*event.ChangeEvent) ([]Preview, error) {
+	rcpts, groups := groupByRecipients(n.routes, evs)
+
+	previews := make([]Preview, 0, len(rcpts))
+	for i, rcpt := range rcpts {
+		subject, text, html, err := n.templates.RenderBatch(NewBatchEmailData(n.cfg.SMTP, rcpt, groups[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		previews = append(previews, Preview{
+			Hostname:   "batch",
+			Subject:    subject,
+			Recipients: rcpt,
+			Body:       text,
+			HTML:       html,
+		})
+	}
+
+	return previews, nil
+}
+
+func (n *EmailNotifier) Batching() bool {
+	return n.batch
+}
+
+// NotifyBatch sends one email to each set of recipients, listing the
+// changes routed to them. When some emails fail it returns a BatchError
+// naming the changes they listed.
+func (n *EmailNotifier) NotifyBatch(_ context.Context, evs []*event.ChangeEvent) error {
+	rcpts, groups := groupByRecipients(n.routes, evs)
+
+	failed := make(map[*event.ChangeEvent]error)
+	for i, rcpt := range rcpts {
+		err := n.sendBatch(rcpt, groups[i])
+		if err != nil {
+			for _, ev := range groups[i] {
+				failed[ev] = err
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed}
+	}
+	return nil
+}
+
+func (n *EmailNotifier) sendBatch(rcpt Recipients, evs []*event.ChangeEvent) error {
+	if len(rcpt.To)+len(rcpt.CC)+len(rcpt.BCC) == 0 {
+		return Permanent(fmt.Errorf("no recipients for %d changes", len(evs)))
+	}
+
+	subject, text, html, err := n.templates.RenderBatch(NewBatchEmailData(n.cfg.SMTP, rcpt, evs))
+	if err != nil {
+		return Permanent(err)
+	}
+
+	logrus.Infof("Sending email notification for %d changes to %s", len(evs), describe(rcpt))
+	return n.transport.Send(NewMessage(n.cfg.SMTP, rcpt, subject, text, html))
+}
+
+// describe lists recipients for log messages.
+func describe(r Recipients) string {
+	s := strings.Join(r.To, ", ")
+	if len(r.CC) > 0 {
+		s += " cc " + strings.Join(r.CC, ", ")
+	}
+	if len(r.BCC) > 0 {
+		s += " bcc " + strings.Join(r.BCC, ", ")
+	}
+	return s
+}
@@ -0,0 +1,199 @@
+package notification
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	Register("exec", newExecNotifier)
+}
+
+// ExecNotifier runs a local command for each event. The command gets the
+// webhook payload as JSON on stdin and the key fields in DIGGER_*
+// environment variables. Its output is logged and a non-zero exit status
+// fails the delivery.
+type ExecNotifier struct {
+	name    string
+	cfg     config.ExecConfig
+	timeout time.Duration
+	retry   RetryPolicy
+}
+
+func newExecNotifier(nc config.NotifierConfig, env Env) (Notifier, error) {
+	if nc.Exec == nil || nc.Exec.Command == "" {
+		return nil, errors.New("exec command missing")
+	}
+
+	timeout := nc.Exec.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	return &ExecNotifier{
+		name:    nc.Name,
+		cfg:     *nc.Exec,
+		timeout: timeout,
+		retry:   NewRetryPolicy(nc.Exec.Retry),
+	}, nil
+}
+
+func (n *ExecNotifier) Name() string {
+	return n.name
+}
+
+func (n *ExecNotifier) RetryPolicy() RetryPolicy {
+	return n.retry
+}
+
+func (n *ExecNotifier) Timeout() time.Duration {
+	return n.timeout
+}
+
+// Subscribes takes every kind of event.
+func (n *ExecNotifier) Subscribes(event.Kind) bool {
+	return true
+}
+
+func (n *ExecNotifier) Notify(ctx context.Context, ev *event.ChangeEvent) error {
+	body, err := json.Marshal(WebhookPayload{
+		Version: WebhookPayloadVersion,
+		Type:    ev.Kind,
+		SentAt:  time.Now().UTC(),
+		Event:   ev,
+	})
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to marshal event: %w", err))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.cfg.Command, n.cfg.Args...)
+	cmd.Dir = n.cfg.Dir
+	cmd.Env = append(inheritedEnv(), EventEnv(ev)...)
+	for k, v := range n.cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.WaitDelay = 5 * time.Second
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to capture stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to capture stderr: %w", err)
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to start %s: %w", n.cfg.Command, err))
+	}
+
+	var wg sync.WaitGroup
+	var lastErr string
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n.logOutput(stdout, logrus.InfoLevel, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		n.logOutput(stderr, logrus.WarnLevel, &lastErr)
+	}()
+	wg.Wait()
+
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s", n.cfg.Command, n.timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if lastErr != "" {
+			return fmt.Errorf("%s exited with status %d: %s", n.cfg.Command, exitErr.ExitCode(), lastErr)
+		}
+		return fmt.Errorf("%s exited with status %d", n.cfg.Command, exitErr.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w", n.cfg.Command, err)
+	}
+
+	return nil
+}
+
+func (n *ExecNotifier) Preview(ev *event.ChangeEvent) (Preview, error) {
+	body, err := previewPayload(ev)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	target := strings.Join(append([]string{n.cfg.Command}, n.cfg.Args...), " ")
+	return Preview{
+		Subject: Title(ev),
+		Target:  target,
+		Body:    strings.Join(EventEnv(ev), "\n") + "\n\n" + body,
+	}, nil
+}
+
+// logOutput logs each line the command writes. The last line is kept in
+// last so it can be reported with a failure.
+func (n *ExecNotifier) logOutput(r io.Reader, level logrus.Level, last *string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		logrus.StandardLogger().Logf(level, "%s: %s", n.name, line)
+		if last != nil {
+			*last = line
+		}
+	}
+}
+
+// inheritedEnv is digger's environment without its DIGGER_* variables,
+// which can hold config overrides such as the SMTP password.
+func inheritedEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(strings.ToUpper(kv), "DIGGER_") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// EventEnv returns the DIGGER_* environment variables describing ev.
+func EventEnv(ev *event.ChangeEvent) []string {
+	return []string{
+		"DIGGER_EVENT_ID=" + ev.ID,
+		"DIGGER_EVENT_KIND=" + string(ev.Kind),
+		"DIGGER_HOSTNAME=" + ev.Hostname,
+		"DIGGER_PORT=" + strconv.Itoa(ev.Port),
+		"DIGGER_ENTITY=" + ev.EntityName,
+		"DIGGER_OLD_IPS=" + strings.Join(ev.OldIPs, ","),
+		"DIGGER_NEW_IPS=" + strings.Join(ev.NewIPs, ","),
+		"DIGGER_SEVERITY=" + string(ev.Severity),
+		"DIGGER_RUN_ID=" + ev.RunID,
+		"DIGGER_TIME=" + ev.Time.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,139 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExecHelperProcess is the command the exec tests run. It is a no-op
+// unless started by them.
+func TestExecHelperProcess(t *testing.T) {
+	mode := os.Getenv("EXEC_HELPER_MODE")
+	if mode == "" {
+		return
+	}
+
+	switch mode {
+	case "echo":
+		var payload WebhookPayload
+		err := json.NewDecoder(os.Stdin).Decode(&payload)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		out := map[string]string{
+			"stdin_host": payload.Event.Hostname,
+			"env_host":   os.Getenv("DIGGER_HOSTNAME"),
+			"env_new":    os.Getenv("DIGGER_NEW_IPS"),
+			"env_kind":   os.Getenv("DIGGER_EVENT_KIND"),
+			"extra":      os.Getenv("PROXY_FILE"),
+			"password":   os.Getenv("DIGGER_SMTP_PASSWORD"),
+		}
+		json.NewEncoder(os.Stdout).Encode(out)
+		os.WriteFile(os.Getenv("EXEC_HELPER_OUT"), mustJSON(out), 0644)
+		os.Exit(0)
+	case "fail":
+		io.Copy(io.Discard, os.Stdin)
+		fmt.Fprintln(os.Stderr, "proxy config is locked")
+		os.Exit(3)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+}
+
+func mustJSON(v interface{}) []byte {
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func execConfig(t *testing.T, mode string, timeout time.Duration) config.NotifierConfig {
+	t.Setenv("EXEC_HELPER_MODE", mode)
+	return config.NotifierConfig{
+		Type: "exec",
+		Name: "proxy-update",
+		Exec: &config.ExecConfig{
+			Command: os.Args[0],
+			Args:    []string{"-test.run=TestExecHelperProcess"},
+			Env:     map[string]string{"PROXY_FILE": "proxy.pac"},
+			Timeout: timeout,
+		},
+	}
+}
+
+func TestExecNotifier(t *testing.T) {
+	out := t.TempDir() + "/out.json"
+	t.Setenv("EXEC_HELPER_OUT", out)
+	// Config overrides in digger's environment are not passed on
+	t.Setenv("DIGGER_SMTP_PASSWORD", "hunter2")
+
+	n, err := newExecNotifier(execConfig(t, "echo", 30*time.Second), Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "sftp.vendor.com", Kind: event.KindIPChanged, NewIPs: []string{"2.2.2.2", "2.2.2.3"}}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	require.Len(t, ev.Deliveries, 1)
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status, ev.Deliveries[0].Error)
+
+	data, err := os.ReadFile(out)
+	require.NoError(t, err)
+	var got map[string]string
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, map[string]string{
+		"stdin_host": "sftp.vendor.com",
+		"env_host":   "sftp.vendor.com",
+		"env_new":    "2.2.2.2,2.2.2.3",
+		"env_kind":   "ip_changed",
+		"extra":      "proxy.pac",
+		"password":   "",
+	}, got)
+
+	// Scripts are told about every kind of event, not just IP changes
+	ev = &event.ChangeEvent{Hostname: "sftp.vendor.com", Kind: event.KindUnreachable}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+	require.Len(t, ev.Deliveries, 1)
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status, ev.Deliveries[0].Error)
+}
+
+func TestExecNotifierExitStatus(t *testing.T) {
+	n, err := newExecNotifier(execConfig(t, "fail", 30*time.Second), Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "example.com", Kind: event.KindIPChanged}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	assert.Equal(t, event.StatusFailed, ev.Deliveries[0].Status)
+	assert.Contains(t, ev.Deliveries[0].Error, "exited with status 3: proxy config is locked")
+}
+
+func TestExecNotifierTimeout(t *testing.T) {
+	n, err := newExecNotifier(execConfig(t, "hang", 200*time.Millisecond), Env{})
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = n.Notify(context.Background(), &event.ChangeEvent{Hostname: "example.com"})
+	assert.ErrorContains(t, err, "timed out")
+	assert.Less(t, time.Since(start), 20*time.Second)
+}
+
+func TestExecNotifierConfigErrors(t *testing.T) {
+	_, err := newExecNotifier(config.NotifierConfig{Type: "exec"}, Env{})
+	assert.Error(t, err)
+
+	n, err := newExecNotifier(config.NotifierConfig{Type: "exec", Exec: &config.ExecConfig{Command: "/no/such/command"}}, Env{})
+	require.NoError(t, err)
+	err = n.Notify(context.Background(), &event.ChangeEvent{})
+	var permanent *permanentError
+	assert.ErrorAs(t, err, &permanent)
+}
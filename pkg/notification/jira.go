@@ -0,0 +1,95 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+)
+
+func init() {
+	Register("jira", newJiraNotifier)
+}
+
+const defaultJiraIssueType = "Task"
+
+// jira talks to the Jira REST API v2.
+type jira struct {
+	client    *ticketClient
+	project   string
+	issueType string
+}
+
+func newJiraNotifier(nc config.NotifierConfig, env Env) (Notifier, error) {
+	if nc.Ticket == nil {
+		return nil, errors.New("ticket settings missing")
+	}
+	if nc.Ticket.Project == "" {
+		return nil, errors.New("jira project missing")
+	}
+
+	client, err := newTicketClient(nc.Ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	issueType := nc.Ticket.IssueType
+	if issueType == "" {
+		issueType = defaultJiraIssueType
+	}
+
+	return newTicketNotifier(nc, env, &jira{
+		client:    client,
+		project:   nc.Ticket.Project,
+		issueType: issueType,
+	})
+}
+
+func (j *jira) Create(ctx context.Context, t ticket) (string, error) {
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": j.project},
+		"issuetype":   map[string]string{"name": j.issueType},
+		"summary":     t.Summary,
+		"description": t.Description,
+	}
+	for k, v := range t.Fields {
+		fields[k] = v
+	}
+
+	var resp struct {
+		Key string `json:"key"`
+	}
+	err := j.client.do(ctx, "POST", "/rest/api/2/issue", nil, map[string]interface{}{"fields": fields}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Key == "" {
+		return "", errors.New("jira did not return an issue key")
+	}
+
+	return resp.Key, nil
+}
+
+// IsOpen treats every status outside the "done" category as open.
+func (j *jira) IsOpen(ctx context.Context, key string) (bool, error) {
+	var resp struct {
+		Fields struct {
+			Status struct {
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			} `json:"status"`
+		} `json:"fields"`
+	}
+	err := j.client.do(ctx, "GET", "/rest/api/2/issue/"+url.PathEscape(key), url.Values{"fields": {"status"}}, nil, &resp)
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Fields.Status.StatusCategory.Key != "done", nil
+}
+
+func (j *jira) Comment(ctx context.Context, key, text string) error {
+	return j.client.do(ctx, "POST", "/rest/api/2/issue/"+url.PathEscape(key)+"/comment", nil, map[string]string{"body": text}, nil)
+}
@@ -0,0 +1,183 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJira is a minimal stand-in for the Jira REST API.
+type fakeJira struct {
+	mu       sync.Mutex
+	issues   map[string]map[string]interface{}
+	done     map[string]bool
+	comments map[string][]string
+}
+
+func newFakeJira(t *testing.T) (*fakeJira, *httptest.Server) {
+	j := &fakeJira{
+		issues:   make(map[string]map[string]interface{}),
+		done:     make(map[string]bool),
+		comments: make(map[string][]string),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "bot@example.com" || pass != "api-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		path := strings.TrimPrefix(r.URL.Path, "/rest/api/2/issue")
+		switch {
+		case r.Method == http.MethodPost && path == "":
+			var body struct {
+				Fields map[string]interface{} `json:"fields"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			key := fmt.Sprintf("NET-%d", len(j.issues)+1)
+			j.issues[key] = body.Fields
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"id": "1", "key": key})
+
+		case r.Method == http.MethodPost && strings.HasSuffix(path, "/comment"):
+			key := strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/comment")
+			var body struct {
+				Body string `json:"body"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			j.comments[key] = append(j.comments[key], body.Body)
+			w.WriteHeader(http.StatusCreated)
+
+		case r.Method == http.MethodGet:
+			key := strings.TrimPrefix(path, "/")
+			if _, ok := j.issues[key]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			category := "indeterminate"
+			if j.done[key] {
+				category = "done"
+			}
+			fmt.Fprintf(w, `{"key":%q,"fields":{"status":{"name":"x","statusCategory":{"key":%q}}}}`, key, category)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return j, server
+}
+
+func jiraConfig(url string) config.NotifierConfig {
+	return config.NotifierConfig{
+		Type: "jira",
+		Name: "jira",
+		Ticket: &config.TicketConfig{
+			URL:       url,
+			Username:  "bot@example.com",
+			Token:     "api-token",
+			Project:   "NET",
+			IssueType: "Firewall Change",
+			Fields: map[string]interface{}{
+				"labels":            []interface{}{"digger", "{{.Event.Severity}}"},
+				"customfield_10010": "{{.Event.EntityName}}",
+				"priority":          map[interface{}]interface{}{"name": "High"},
+			},
+		},
+	}
+}
+
+func TestJiraOpensTicketsAndComments(t *testing.T) {
+	j, server := newFakeJira(t)
+
+	tickets := map[string]string{}
+	env := Env{LastTicket: func(backend, siteID string) (string, error) {
+		return tickets[backend+"/"+siteID], nil
+	}}
+
+	n, err := newJiraNotifier(jiraConfig(server.URL), env)
+	require.NoError(t, err)
+	d := NewDispatcher([]Notifier{n})
+
+	ev := &event.ChangeEvent{ID: "e1", SiteID: "sftp.vendor.com", Hostname: "sftp.vendor.com", Port: 22, EntityName: "Vendor",
+		Kind: event.KindIPChanged, OldIPs: []string{"1.1.1.1"}, NewIPs: []string{"2.2.2.2"}, Severity: event.SeverityCritical}
+	d.Dispatch(context.Background(), ev)
+
+	require.Len(t, ev.Deliveries, 1)
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status)
+	assert.Equal(t, "NET-1", ev.Deliveries[0].Ticket)
+
+	fields := j.issues["NET-1"]
+	assert.Equal(t, "IP address change for sftp.vendor.com", fields["summary"])
+	assert.Contains(t, fields["description"], "New IPs: 2.2.2.2")
+	assert.Equal(t, map[string]interface{}{"key": "NET"}, fields["project"])
+	assert.Equal(t, map[string]interface{}{"name": "Firewall Change"}, fields["issuetype"])
+	assert.Equal(t, []interface{}{"digger", "critical"}, fields["labels"])
+	assert.Equal(t, "Vendor", fields["customfield_10010"])
+	assert.Equal(t, map[string]interface{}{"name": "High"}, fields["priority"])
+
+	// A repeat change while NET-1 is open is added as a comment.
+	tickets["jira/sftp.vendor.com"] = "NET-1"
+	repeat := &event.ChangeEvent{ID: "e2", SiteID: "sftp.vendor.com", Hostname: "sftp.vendor.com",
+		Kind: event.KindIPChanged, OldIPs: []string{"2.2.2.2"}, NewIPs: []string{"3.3.3.3"}}
+	d.Dispatch(context.Background(), repeat)
+
+	assert.Equal(t, "NET-1", repeat.Deliveries[0].Ticket)
+	assert.Len(t, j.issues, 1)
+	require.Len(t, j.comments["NET-1"], 1)
+	assert.Contains(t, j.comments["NET-1"][0], "2.2.2.2 -> 3.3.3.3")
+
+	// Once NET-1 is done the next change opens a new ticket.
+	j.done["NET-1"] = true
+	another := &event.ChangeEvent{ID: "e3", SiteID: "sftp.vendor.com", Hostname: "sftp.vendor.com", Kind: event.KindIPChanged}
+	d.Dispatch(context.Background(), another)
+
+	assert.Equal(t, "NET-2", another.Deliveries[0].Ticket)
+	assert.Len(t, j.issues, 2)
+}
+
+func TestJiraAuthFailureIsPermanent(t *testing.T) {
+	_, server := newFakeJira(t)
+
+	nc := jiraConfig(server.URL)
+	nc.Ticket.Token = "wrong"
+	nc.Ticket.Retry = config.RetryConfig{MaxAttempts: 3}
+	n, err := newJiraNotifier(nc, Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "example.com"}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	assert.Equal(t, event.StatusFailed, ev.Deliveries[0].Status)
+	assert.Equal(t, 1, ev.Deliveries[0].Attempts)
+	assert.Empty(t, ev.Deliveries[0].Ticket)
+}
+
+func TestJiraConfigErrors(t *testing.T) {
+	_, err := newJiraNotifier(config.NotifierConfig{Type: "jira"}, Env{})
+	assert.Error(t, err)
+
+	_, err = newJiraNotifier(config.NotifierConfig{Type: "jira", Ticket: &config.TicketConfig{URL: "https://jira.example.com"}}, Env{})
+	assert.ErrorContains(t, err, "project")
+
+	_, err = newJiraNotifier(config.NotifierConfig{Type: "jira", Ticket: &config.TicketConfig{URL: "https://jira.example.com", Project: "NET", Summary: "{{"}}, Env{})
+	assert.ErrorContains(t, err, "summary")
+
+	_, err = newJiraNotifier(config.NotifierConfig{Type: "jira", Ticket: &config.TicketConfig{URL: "https://jira.example.com", Project: "NET",
+		Fields: map[string]interface{}{"labels": []interface{}{"{{.Nope"}}}}, Env{})
+	assert.ErrorContains(t, err, "labels")
+}
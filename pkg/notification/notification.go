@@ -2,15 +2,21 @@ package notification
 
 import (
 	"bytes"
-	"crypto/tls"
 	"fmt"
-	"os"
+	"strings"
 	"text/template"
 
 	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
 	"github.com/gophish/gomail"
 )
 
+const (
+	defaultRecipientName = "Network Security Team"
+	defaultSubject       = `IP Address Change Notification: {{.Hostname}}`
+	defaultBatchSubject  = `IP Address Change Notification: {{.Count}} changes`
+)
+
 type EmailData struct {
 	Name     string
 	Email    string
@@ -19,54 +25,237 @@ type EmailData struct {
 	Vendor   string
 	OldIP    string
 	NewIP    string
+	Clients  []string
+	Severity event.Severity
+	Event    *event.ChangeEvent
+}
+
+// BatchEmailData is what the batch email template renders: every change
+// from one run.
+type BatchEmailData struct {
+	Name    string
+	Email   string
+	Count   int
+	Changes []EmailData
+}
+
+// NewEmailData is the template data for one change sent to rcpt.
+func NewEmailData(cfg config.SMTPConfig, rcpt Recipients, ev *event.ChangeEvent) EmailData {
+	return EmailData{
+		Name:     recipientName(cfg),
+		Email:    strings.Join(rcpt.To, ", "),
+		Hostname: ev.Hostname,
+		Port:     ev.Port,
+		Vendor:   ev.EntityName,
+		OldIP:    strings.Join(ev.OldIPs, ";"),
+		NewIP:    strings.Join(ev.NewIPs, ";"),
+		Clients:  ev.Clients,
+		Severity: ev.Severity,
+		Event:    ev,
+	}
+}
+
+// NewBatchEmailData is the template data for several changes sent to rcpt.
+func NewBatchEmailData(cfg config.SMTPConfig, rcpt Recipients, evs []*event.ChangeEvent) BatchEmailData {
+	data := BatchEmailData{
+		Name:  recipientName(cfg),
+		Email: strings.Join(rcpt.To, ", "),
+		Count: len(evs),
+	}
+	for _, ev := range evs {
+		data.Changes = append(data.Changes, NewEmailData(cfg, rcpt, ev))
+	}
+	return data
+}
+
+func recipientName(cfg config.SMTPConfig) string {
+	if cfg.RecipientName == "" {
+		return defaultRecipientName
+	}
+	return cfg.RecipientName
+}
+
+// emailTemplate renders the subject and both bodies of an email.
+type emailTemplate struct {
+	subject *template.Template
+	html    *template.Template
+	text    *template.Template
 }
 
-func SendIPChangeNotification(cfg *config.Config, hostname string, port int, entityName string, oldIP, newIP string) error {
-	// Read the template file
-	templateContent, err := os.ReadFile(cfg.SMTP.TemplatePath)
+func (t emailTemplate) render(data interface{}) (subject, text, html string, err error) {
+	var buf bytes.Buffer
+	err = t.subject.Execute(&buf, data)
 	if err != nil {
-		return fmt.Errorf("failed to read email template file: %w", err)
+		return "", "", "", fmt.Errorf("failed to render email subject: %w", err)
 	}
+	subject = strings.Join(strings.Fields(buf.String()), " ")
 
-	// Parse the email template
-	t, err := template.New("email").Parse(string(templateContent))
+	buf.Reset()
+	err = t.text.Execute(&buf, data)
 	if err != nil {
-		return fmt.Errorf("failed to parse email template: %w", err)
+		return "", "", "", fmt.Errorf("failed to render plain text email template: %w", err)
 	}
+	text = buf.String()
 
-	// Prepare dynamic data for the template
-	data := EmailData{
-		Name:     "Network Security Team",
-		Email:    cfg.SMTP.To,
-		Hostname: hostname,
-		Port:     port,
-		Vendor:   entityName,
-		OldIP:    oldIP,
-		NewIP:    newIP,
+	buf.Reset()
+	err = t.html.Execute(&buf, data)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to render email template: %w", err)
 	}
+	html = buf.String()
+
+	return subject, text, html, nil
+}
+
+// emailRule uses its template for changes matching entity and severity.
+type emailRule struct {
+	entity   string
+	severity string
+	tmpl     emailTemplate
+}
+
+// EmailTemplates holds the email templates from the smtp settings, parsed
+// once. Templates without a path come from the templates directory or the
+// built-in copies.
+type EmailTemplates struct {
+	single emailTemplate
+	batch  emailTemplate
+	rules  []emailRule
+}
+
+func NewEmailTemplates(cfg config.SMTPConfig) (*EmailTemplates, error) {
+	t := &EmailTemplates{}
 
-	// Render the template into a string
-	var renderedEmail bytes.Buffer
-	err = t.Execute(&renderedEmail, data)
+	var err error
+	t.single, err = parseEmailTemplate(cfg.Subject, defaultSubject, cfg.TemplatePath, "email.html", cfg.TextTemplatePath, "email.txt")
 	if err != nil {
-		return fmt.Errorf("failed to render email template: %w", err)
+		return nil, err
 	}
+	t.batch, err = parseEmailTemplate(cfg.BatchSubject, defaultBatchSubject, cfg.BatchTemplatePath, "email_batch.html", cfg.BatchTextTemplatePath, "email_batch.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	for i, rc := range cfg.Templates {
+		rule := emailRule{
+			entity:   rc.Entity,
+			severity: rc.Severity,
+			tmpl:     t.single,
+		}
+
+		if rc.Subject != "" {
+			rule.tmpl.subject, err = parseSubject(rc.Subject)
+			if err != nil {
+				return nil, fmt.Errorf("smtp template %d: %w", i+1, err)
+			}
+		}
+		if rc.TemplatePath != "" {
+			rule.tmpl.html, err = loadTemplate(rc.TemplatePath, "email.html", templateFuncs)
+			if err != nil {
+				return nil, fmt.Errorf("smtp template %d: %w", i+1, err)
+			}
+		}
+		if rc.TextTemplatePath != "" {
+			rule.tmpl.text, err = loadTemplate(rc.TextTemplatePath, "email.txt", templateFuncs)
+			if err != nil {
+				return nil, fmt.Errorf("smtp template %d: %w", i+1, err)
+			}
+		}
 
-	// Create new email message
+		t.rules = append(t.rules, rule)
+	}
+
+	return t, nil
+}
+
+func parseEmailTemplate(subject, defaultSubject, htmlPath, htmlName, textPath, textName string) (emailTemplate, error) {
+	if subject == "" {
+		subject = defaultSubject
+	}
+
+	var t emailTemplate
+	var err error
+	t.subject, err = parseSubject(subject)
+	if err != nil {
+		return t, err
+	}
+	t.html, err = loadTemplate(htmlPath, htmlName, templateFuncs)
+	if err != nil {
+		return t, err
+	}
+	t.text, err = loadTemplate(textPath, textName, templateFuncs)
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+func parseSubject(text string) (*template.Template, error) {
+	t, err := template.New("subject").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email subject: %w", err)
+	}
+	return t, nil
+}
+
+// Render returns the subject, plain text body and HTML body for one change,
+// using the first smtp.templates entry that matches it.
+func (t *EmailTemplates) Render(data EmailData) (string, string, string, error) {
+	for _, r := range t.rules {
+		if r.entity != "" && !strings.EqualFold(r.entity, data.Vendor) {
+			continue
+		}
+		if r.severity != "" && !strings.EqualFold(r.severity, string(data.Severity)) {
+			continue
+		}
+		return r.tmpl.render(data)
+	}
+
+	return t.single.render(data)
+}
+
+// RenderBatch returns the subject, plain text body and HTML body listing
+// several changes.
+func (t *EmailTemplates) RenderBatch(data BatchEmailData) (string, string, string, error) {
+	return t.batch.render(data)
+}
+
+// NewMessage builds an email to rcpt. With a plain text body the HTML body
+// is sent as its alternative.
+func NewMessage(sc config.SMTPConfig, rcpt Recipients, subject, textBody, htmlBody string) *gomail.Message {
 	mail := gomail.NewMessage()
-	mail.SetHeader("From", cfg.SMTP.From)
-	mail.SetHeader("To", cfg.SMTP.To)
-	mail.SetHeader("Subject", "IP Address Change Notification")
-	mail.SetBody("text/html", renderedEmail.String())
+	mail.SetHeader("From", sc.From)
+	mail.SetHeader("To", rcpt.To...)
+	if len(rcpt.CC) > 0 {
+		mail.SetHeader("Cc", rcpt.CC...)
+	}
+	if len(rcpt.BCC) > 0 {
+		mail.SetHeader("Bcc", rcpt.BCC...)
+	}
+	mail.SetHeader("Subject", subject)
+	if textBody != "" {
+		mail.SetBody("text/plain", textBody)
+		mail.AddAlternative("text/html", htmlBody)
+	} else {
+		mail.SetBody("text/html", htmlBody)
+	}
 
-	// Set up the email dialer
-	d := gomail.NewDialer(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	return mail
+}
 
-	// Send the email
-	if err := d.DialAndSend(mail); err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+// SendMail sends an email to the smtp to, cc and bcc addresses using the
+// SMTP settings in cfg.
+func SendMail(cfg *config.Config, subject, textBody, htmlBody string) error {
+	rcpt, err := recipients(cfg.SMTP.To, cfg.SMTP.CC, cfg.SMTP.BCC)
+	if err != nil {
+		return fmt.Errorf("invalid smtp recipients: %w", err)
+	}
+
+	transport, err := NewSMTPTransport(cfg.SMTP)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return transport.Send(NewMessage(cfg.SMTP, rcpt, subject, textBody, htmlBody))
 }
@@ -1,117 +1,152 @@
 package notification
 
 import (
-	"bytes"
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
-	"text/template"
 
 	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestSendIPChangeNotification(t *testing.T) {
+func TestEmailNotifierNotify(t *testing.T) {
 	// Create a temporary template file for testing
 	tmpDir := t.TempDir()
 	templatePath := filepath.Join(tmpDir, "email.html")
 	err := os.WriteFile(templatePath, []byte(`<html>{{.Name}} - {{.Email}} - {{.Hostname}} - {{.Port}} - {{.Vendor}} - {{.OldIP}} - {{.NewIP}}</html>`), 0644)
 	require.NoError(t, err)
 
-	tests := []struct {
-		name       string
-		cfg        *config.Config
-		hostname   string
-		port       int
-		entityName string
-		oldIP      string
-		newIP      string
-		wantErr    bool
-	}{
-		{
-			name: "successful notification",
-			cfg: &config.Config{
-				SMTP: struct {
-					Host         string `yaml:"host"`
-					Port         int    `yaml:"port"`
-					Username     string `yaml:"username"`
-					Password     string `yaml:"password"`
-					From         string `yaml:"from"`
-					To           string `yaml:"to"`
-					TemplatePath string `yaml:"template_path"`
-				}{
-					Host:         "test.smtp.server",
-					Port:         25,
-					Username:     "testuser",
-					Password:     "testpass",
-					From:         "from@test.com",
-					To:           "to@test.com",
-					TemplatePath: templatePath,
-				},
-			},
-			hostname:   "test.example.com",
-			port:       443,
-			entityName: "Test Vendor",
-			oldIP:      "192.168.1.1",
-			newIP:      "192.168.1.2",
-			wantErr:    true, // Set to true since we don't have a real SMTP server in tests
+	cfg := &config.Config{
+		SMTP: config.SMTPConfig{
+			Host:         "127.0.0.1",
+			Port:         1, // nothing listens here
+			Username:     "testuser",
+			Password:     "testpass",
+			From:         "from@test.com",
+			To:           config.StringList{"to@test.com"},
+			TemplatePath: templatePath,
 		},
 	}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := SendIPChangeNotification(tt.cfg, tt.hostname, tt.port, tt.entityName, tt.oldIP, tt.newIP)
-			if tt.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
-		})
+	n, err := newEmailNotifier(config.NotifierConfig{Name: "email"}, Env{Config: cfg})
+	require.NoError(t, err)
+
+	err = n.Notify(context.Background(), &event.ChangeEvent{
+		Hostname:   "test.example.com",
+		Port:       443,
+		EntityName: "Test Vendor",
+		OldIPs:     []string{"192.168.1.1"},
+		NewIPs:     []string{"192.168.1.2"},
+	})
+	assert.ErrorContains(t, err, "failed to connect to smtp server")
+
+	cfg.SMTP.TemplatePath = filepath.Join(tmpDir, "missing.html")
+	_, err = newEmailNotifier(config.NotifierConfig{Name: "email"}, Env{Config: cfg})
+	assert.Error(t, err)
+}
+
+func testChange() *event.ChangeEvent {
+	return &event.ChangeEvent{
+		Hostname:   "test.host.com",
+		Port:       443,
+		EntityName: "Test Vendor",
+		OldIPs:     []string{"192.168.1.1"},
+		NewIPs:     []string{"192.168.1.2"},
+		Clients:    []string{"LCAPP172", "LCAPP173"},
+		Kind:       event.KindIPChanged,
+		Severity:   event.SeverityCritical,
 	}
 }
 
 func TestEmailTemplateRendering(t *testing.T) {
-	// Create a temporary template file for testing
-	tmpDir := t.TempDir()
-	templatePath := filepath.Join(tmpDir, "email.html")
-	templateContent, err := os.ReadFile("../../templates/email.html") // Read the actual template
+	smtp := config.SMTPConfig{
+		To:           config.StringList{"test@example.com"},
+		TemplatePath: "../../templates/email.html",
+	}
+	templates, err := NewEmailTemplates(smtp)
 	require.NoError(t, err)
 
-	err = os.WriteFile(templatePath, templateContent, 0644)
+	data := NewEmailData(smtp, Recipients{To: smtp.To}, testChange())
+	subject, text, html, err := templates.Render(data)
 	require.NoError(t, err)
 
-	// Test data
-	data := EmailData{
-		Name:     "Test Team",
-		Email:    "test@example.com",
-		Hostname: "test.host.com",
-		Port:     443,
-		Vendor:   "Test Vendor",
-		OldIP:    "192.168.1.1",
-		NewIP:    "192.168.1.2",
+	assert.Equal(t, "IP Address Change Notification: test.host.com", subject)
+	for _, rendered := range []string{text, html} {
+		assert.Contains(t, rendered, "Network Security Team")
+		assert.Contains(t, rendered, data.Email)
+		assert.Contains(t, rendered, data.Hostname)
+		assert.Contains(t, rendered, data.Vendor)
+		assert.Contains(t, rendered, data.OldIP)
+		assert.Contains(t, rendered, data.NewIP)
+		assert.Contains(t, rendered, "LCAPP172")
+		assert.Contains(t, rendered, "LCAPP173")
 	}
+	assert.Contains(t, html, "<b>LCAPP172</b>")
+	assert.NotContains(t, text, "<")
+}
 
-	// Parse template from file
-	templateContent, err = os.ReadFile(templatePath)
+func TestEmailTemplateSelection(t *testing.T) {
+	smtp := config.SMTPConfig{
+		RecipientName: "Firewall Team",
+		Subject:       "{{.Vendor}}: {{.Hostname}}",
+		Templates: []config.EmailTemplateConfig{
+			{Entity: "other vendor", Subject: "Other: {{.Hostname}}"},
+			{Severity: "warning", Subject: "Warning: {{title .Event}}"},
+		},
+	}
+	templates, err := NewEmailTemplates(smtp)
 	require.NoError(t, err)
 
-	tmpl, err := template.New("email").Parse(string(templateContent))
+	ev := testChange()
+	subject, text, _, err := templates.Render(NewEmailData(smtp, Recipients{To: smtp.To}, ev))
 	require.NoError(t, err)
+	assert.Equal(t, "Test Vendor: test.host.com", subject)
+	assert.Contains(t, text, "Greetings, Firewall Team!")
 
-	// Render template
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, data)
+	ev.EntityName = "Other Vendor"
+	subject, _, _, err = templates.Render(NewEmailData(smtp, Recipients{To: smtp.To}, ev))
 	require.NoError(t, err)
+	assert.Equal(t, "Other: test.host.com", subject)
 
-	// Verify rendered content
-	rendered := buf.String()
-	assert.Contains(t, rendered, data.Name)
-	assert.Contains(t, rendered, data.Email)
-	assert.Contains(t, rendered, data.Hostname)
-	assert.Contains(t, rendered, data.Vendor)
-	assert.Contains(t, rendered, data.OldIP)
-	assert.Contains(t, rendered, data.NewIP)
-	assert.Contains(t, rendered, "LCAPP172")
-	assert.Contains(t, rendered, "LCAPP173")
+	ev.EntityName = "Test Vendor"
+	ev.Severity = event.SeverityWarning
+	subject, _, _, err = templates.Render(NewEmailData(smtp, Recipients{To: smtp.To}, ev))
+	require.NoError(t, err)
+	assert.Equal(t, "Warning: IP address change for test.host.com", subject)
+
+	smtp.Templates = []config.EmailTemplateConfig{{Entity: "x", Subject: "{{.Broken"}}
+	_, err = NewEmailTemplates(smtp)
+	assert.ErrorContains(t, err, "smtp template 1")
+}
+
+func TestBatchEmailTemplateRendering(t *testing.T) {
+	smtp := config.SMTPConfig{
+		To:                config.StringList{"test@example.com"},
+		BatchTemplatePath: "../../templates/email_batch.html",
+	}
+	templates, err := NewEmailTemplates(smtp)
+	require.NoError(t, err)
+
+	a := testChange()
+	b := &event.ChangeEvent{Hostname: "b.example.com", Port: 22, EntityName: "Vendor B", OldIPs: []string{"3.3.3.3"}, NewIPs: []string{"4.4.4.4"}}
+	data := NewBatchEmailData(smtp, Recipients{To: smtp.To}, []*event.ChangeEvent{a, b})
+
+	subject, text, html, err := templates.RenderBatch(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "IP Address Change Notification: 2 changes", subject)
+	assert.Contains(t, html, "DNS resolution for 2 3rd party vendor")
+	assert.Contains(t, html, "<td>LCAPP172, LCAPP173</td>")
+	for _, rendered := range []string{text, html} {
+		assert.Contains(t, rendered, "test@example.com")
+		for _, c := range data.Changes {
+			assert.Contains(t, rendered, c.Hostname)
+			assert.Contains(t, rendered, c.Vendor)
+			assert.Contains(t, rendered, c.NewIP)
+		}
+	}
+	assert.Contains(t, html, "<td>b.example.com</td>")
 }
@@ -0,0 +1,593 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+)
+
+// Notifier delivers change events to a single backend.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, ev *event.ChangeEvent) error
+}
+
+// Ticketer is implemented by backends that raise tickets. The dispatcher
+// calls RaiseTicket instead of Notify and records the returned key on the
+// delivery.
+type Ticketer interface {
+	RaiseTicket(ctx context.Context, ev *event.ChangeEvent) (string, error)
+}
+
+// Subscriber is implemented by notifiers that want events other than IP
+// changes. Notifiers without it only receive KindIPChanged events.
+type Subscriber interface {
+	Subscribes(kind event.Kind) bool
+}
+
+// BatchNotifier is implemented by backends that can deliver several change
+// events as one notification. Batching reports whether the backend was
+// configured to.
+type BatchNotifier interface {
+	Notifier
+	Batching() bool
+	NotifyBatch(ctx context.Context, evs []*event.ChangeEvent) error
+}
+
+// BatchError is returned by NotifyBatch when only some of the events were
+// delivered. Failed holds the error for each event that was not; the rest
+// were delivered.
+type BatchError struct {
+	Failed map[*event.ChangeEvent]error
+}
+
+func (e *BatchError) Error() string {
+	seen := make(map[string]bool)
+	var msgs []string
+	for _, err := range e.Failed {
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			msgs = append(msgs, err.Error())
+		}
+	}
+	sort.Strings(msgs)
+	return strings.Join(msgs, "\n")
+}
+
+// Retrier is implemented by notifiers whose failed deliveries should be
+// retried.
+type Retrier interface {
+	RetryPolicy() RetryPolicy
+}
+
+// RetryPolicy controls retries with exponential backoff. The wait before
+// retry n is InitialBackoff * 2^(n-1), capped at MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// NewRetryPolicy fills in defaults for unset fields of a configured policy.
+func NewRetryPolicy(rc config.RetryConfig) RetryPolicy {
+	p := RetryPolicy{
+		MaxAttempts:    rc.MaxAttempts,
+		InitialBackoff: rc.InitialBackoff,
+		MaxBackoff:     rc.MaxBackoff,
+	}
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 1
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = time.Second
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = time.Minute
+	}
+	return p
+}
+
+// Backoff returns the wait before the given retry, counting from 1.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// permanentError marks a failure that retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the dispatcher does not retry it.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Env carries what a backend may need beyond its own settings.
+type Env struct {
+	Config *config.Config
+
+	// LastTicket returns the latest ticket a backend raised for a site, so
+	// repeat changes can be added to it instead of opening another.
+	LastTicket func(backend, siteID string) (string, error)
+}
+
+// Factory builds a notifier from its entry in the notifiers list.
+type Factory func(nc config.NotifierConfig, env Env) (Notifier, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available under kind for the notifiers list in
+// config.yaml. Backends register themselves from init.
+func Register(kind string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, dup := factories[kind]; dup {
+		panic("notification: backend registered twice: " + kind)
+	}
+	factories[kind] = f
+}
+
+// Kinds lists the registered backend types.
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	kinds := make([]string, 0, len(factories))
+	for k := range factories {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Build creates the notifiers listed in cfg. When none are listed the email
+// backend is used on its own, as before notifiers were configurable. A
+// syslog notifier is added when log.syslog is configured.
+func Build(cfg *config.Config, env Env) ([]Notifier, error) {
+	env.Config = cfg
+
+	entries := cfg.Notifiers
+	if len(entries) == 0 {
+		entries = []config.NotifierConfig{{Type: "email"}}
+	}
+
+	names := make(map[string]bool)
+	notifiers := make([]Notifier, 0, len(entries))
+	for i, nc := range entries {
+		factoriesMu.RLock()
+		f, ok := factories[nc.Type]
+		factoriesMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("notifiers[%d]: unknown type %q, expected one of %s", i, nc.Type, strings.Join(Kinds(), ", "))
+		}
+
+		if nc.Name == "" {
+			nc.Name = nc.Type
+		}
+		if names[nc.Name] {
+			return nil, fmt.Errorf("notifiers[%d]: duplicate name %q", i, nc.Name)
+		}
+		names[nc.Name] = true
+
+		n, err := f(nc, env)
+		if err != nil {
+			return nil, fmt.Errorf("notifiers[%d] (%s): %w", i, nc.Name, err)
+		}
+		if nc.Batch && !batching(n) {
+			return nil, fmt.Errorf("notifiers[%d] (%s): %s does not support batch delivery", i, nc.Name, nc.Type)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	routes, err := NewRoutes(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range routes.Notifiers() {
+		if !names[name] && !(name == "syslog" && cfg.Log.Syslog.Address != "") {
+			return nil, fmt.Errorf("route names unknown notifier %q", name)
+		}
+	}
+
+	// The SIEM feed is set up next to the other log outputs.
+	if cfg.Log.Syslog.Address != "" {
+		if names["syslog"] {
+			return nil, errors.New(`notifier name "syslog" is reserved for log.syslog`)
+		}
+
+		n, err := NewSyslogNotifier(cfg.Log.Syslog)
+		if err != nil {
+			return nil, fmt.Errorf("log.syslog: %w", err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	return notifiers, nil
+}
+
+// DefaultTimeout bounds a single delivery attempt.
+const DefaultTimeout = 30 * time.Second
+
+// Dispatcher fans an event out to every notifier and records the outcome
+// of each on the event. With Routes set, events only go to the notifiers
+// their routes allow.
+type Dispatcher struct {
+	Notifiers []Notifier
+	Routes    *Routes
+	Timeout   time.Duration
+}
+
+func NewDispatcher(notifiers []Notifier) *Dispatcher {
+	return &Dispatcher{
+		Notifiers: notifiers,
+		Timeout:   DefaultTimeout,
+	}
+}
+
+// Dispatch delivers ev to every notifier that wants it concurrently, except
+// batching notifiers, which get it through DispatchBatch. It sets
+// ev.Deliveries to one entry per notifier used and ev.NotificationStatus to
+// the overall result.
+func (d *Dispatcher) Dispatch(ctx context.Context, ev *event.ChangeEvent) {
+	var notifiers []Notifier
+	for _, n := range d.For(ev) {
+		if !batching(n) {
+			notifiers = append(notifiers, n)
+		}
+	}
+
+	ev.Deliveries = fanOut(notifiers, func(n Notifier) event.Delivery {
+		return d.Deliver(ctx, n, ev)
+	})
+	ev.NotificationStatus = Overall(ev.Deliveries)
+}
+
+// DispatchBatch hands the IP changes in evs to every batching notifier as a
+// single notification and adds the outcome to each event's deliveries.
+func (d *Dispatcher) DispatchBatch(ctx context.Context, evs []*event.ChangeEvent) {
+	if len(evs) == 0 {
+		return
+	}
+
+	var notifiers []Notifier
+	batches := make(map[Notifier][]*event.ChangeEvent)
+	for _, n := range d.Subscribed(event.KindIPChanged) {
+		if !batching(n) {
+			continue
+		}
+		for _, ev := range evs {
+			if d.allows(ev, n) {
+				batches[n] = append(batches[n], ev)
+			}
+		}
+		if len(batches[n]) > 0 {
+			notifiers = append(notifiers, n)
+		}
+	}
+	if len(notifiers) == 0 {
+		return
+	}
+
+	d.dispatchGroups(ctx, notifiers, batches)
+}
+
+// DispatchAll delivers evs together. Every notifier that wants some of them
+// and can deliver several events as one notification gets a single one,
+// whether or not it batches a run's changes. Other notifiers get each event
+// in turn. It sets each event's deliveries and overall status as Dispatch
+// does.
+func (d *Dispatcher) DispatchAll(ctx context.Context, evs []*event.ChangeEvent) {
+	var notifiers []Notifier
+	groups := make(map[Notifier][]*event.ChangeEvent)
+	for _, ev := range evs {
+		ev.Deliveries = nil
+		for _, n := range d.For(ev) {
+			if groups[n] == nil {
+				notifiers = append(notifiers, n)
+			}
+			groups[n] = append(groups[n], ev)
+		}
+	}
+
+	d.dispatchGroups(ctx, notifiers, groups)
+	for _, ev := range evs {
+		ev.NotificationStatus = Overall(ev.Deliveries)
+	}
+}
+
+// dispatchGroups hands each notifier its group of events concurrently, in
+// one notification when the backend supports it, and adds the outcome to
+// each event's deliveries.
+func (d *Dispatcher) dispatchGroups(ctx context.Context, notifiers []Notifier, groups map[Notifier][]*event.ChangeEvent) {
+	results := make([][]event.Delivery, len(notifiers))
+
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			results[i] = d.deliverGroup(ctx, n, groups[n])
+		}(i, n)
+	}
+	wg.Wait()
+
+	for i, n := range notifiers {
+		for j, ev := range groups[n] {
+			ev.Deliveries = append(ev.Deliveries, results[i][j])
+			ev.NotificationStatus = Overall(ev.Deliveries)
+		}
+	}
+}
+
+// deliverGroup delivers evs to n and returns the outcome for each event.
+func (d *Dispatcher) deliverGroup(ctx context.Context, n Notifier, evs []*event.ChangeEvent) []event.Delivery {
+	deliveries := make([]event.Delivery, len(evs))
+
+	b, ok := n.(BatchNotifier)
+	if !ok {
+		for i, ev := range evs {
+			deliveries[i] = d.Deliver(ctx, n, ev)
+		}
+		return deliveries
+	}
+
+	// Retries only send the events that failed, and events that failed
+	// for good are not sent again
+	pending := evs
+	failed := make(map[*event.ChangeEvent]error)
+	subject := fmt.Sprintf("%d changes", len(evs))
+	delivery := d.deliver(ctx, n, subject, func(ctx context.Context) (string, error) {
+		err := b.NotifyBatch(ctx, pending)
+
+		var batchErr *BatchError
+		if err != nil && !errors.As(err, &batchErr) {
+			for _, ev := range pending {
+				failed[ev] = err
+			}
+			return "", err
+		}
+
+		var retry []*event.ChangeEvent
+		for _, ev := range pending {
+			var evErr error
+			if batchErr != nil {
+				evErr = batchErr.Failed[ev]
+			}
+			if evErr == nil {
+				delete(failed, ev)
+				continue
+			}
+			failed[ev] = evErr
+			var permanent *permanentError
+			if !errors.As(evErr, &permanent) {
+				retry = append(retry, ev)
+			}
+		}
+		pending = retry
+
+		if err != nil && len(pending) == 0 {
+			return "", Permanent(err)
+		}
+		return "", err
+	})
+
+	for i, ev := range evs {
+		deliveries[i] = delivery
+		if err, ok := failed[ev]; ok {
+			if delivery.Status == event.StatusSent {
+				logrus.Errorf("Failed to deliver %s notification for %s: %v", n.Name(), ev.Hostname, err)
+			}
+			deliveries[i].Status = event.StatusFailed
+			deliveries[i].Error = err.Error()
+			continue
+		}
+		deliveries[i].Status = event.StatusSent
+		deliveries[i].Error = ""
+	}
+	return deliveries
+}
+
+// Batching reports whether any notifier collects a run's changes into one
+// notification.
+func (d *Dispatcher) Batching() bool {
+	for _, n := range d.Notifiers {
+		if batching(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// fanOut runs deliver for every notifier concurrently.
+func fanOut(notifiers []Notifier, deliver func(n Notifier) event.Delivery) []event.Delivery {
+	deliveries := make([]event.Delivery, len(notifiers))
+
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			deliveries[i] = deliver(n)
+		}(i, n)
+	}
+	wg.Wait()
+
+	return deliveries
+}
+
+// Subscribed returns the notifiers that take events of kind.
+func (d *Dispatcher) Subscribed(kind event.Kind) []Notifier {
+	var notifiers []Notifier
+	for _, n := range d.Notifiers {
+		if subscribes(n, kind) {
+			notifiers = append(notifiers, n)
+		}
+	}
+	return notifiers
+}
+
+// For returns the notifiers that take ev and that its routes allow.
+func (d *Dispatcher) For(ev *event.ChangeEvent) []Notifier {
+	var notifiers []Notifier
+	for _, n := range d.Subscribed(ev.Kind) {
+		if d.allows(ev, n) {
+			notifiers = append(notifiers, n)
+		}
+	}
+	return notifiers
+}
+
+// allows applies the routes to every notifier but the syslog feed, which
+// gets every event whatever the routes say.
+func (d *Dispatcher) allows(ev *event.ChangeEvent, n Notifier) bool {
+	if _, ok := n.(*SyslogNotifier); ok {
+		return true
+	}
+	return d.Routes == nil || d.Routes.Allows(ev, n.Name())
+}
+
+// Lookup returns the notifier called name, or nil.
+func (d *Dispatcher) Lookup(name string) Notifier {
+	for _, n := range d.Notifiers {
+		if n.Name() == name {
+			return n
+		}
+	}
+	return nil
+}
+
+func subscribes(n Notifier, kind event.Kind) bool {
+	if s, ok := n.(Subscriber); ok {
+		return s.Subscribes(kind)
+	}
+	return kind == event.KindIPChanged || kind == ""
+}
+
+func batching(n Notifier) bool {
+	b, ok := n.(BatchNotifier)
+	return ok && b.Batching()
+}
+
+// Deliver hands ev to n alone, retrying as its policy allows, and returns
+// the outcome.
+func (d *Dispatcher) Deliver(ctx context.Context, n Notifier, ev *event.ChangeEvent) event.Delivery {
+	return d.deliver(ctx, n, ev.Hostname, func(ctx context.Context) (string, error) {
+		if t, ok := n.(Ticketer); ok {
+			return t.RaiseTicket(ctx, ev)
+		}
+		return "", n.Notify(ctx, ev)
+	})
+}
+
+// deliver calls try until it succeeds, fails permanently or runs out of
+// attempts. subject names what is being delivered in log messages.
+func (d *Dispatcher) deliver(ctx context.Context, n Notifier, subject string, try func(ctx context.Context) (string, error)) event.Delivery {
+	policy := RetryPolicy{MaxAttempts: 1}
+	if r, ok := n.(Retrier); ok {
+		policy = r.RetryPolicy()
+	}
+
+	delivery := event.Delivery{
+		Backend: n.Name(),
+	}
+
+	var err error
+	for {
+		delivery.Attempts++
+		delivery.Ticket, err = d.attempt(ctx, n, try)
+
+		var permanent *permanentError
+		if err == nil || errors.As(err, &permanent) || delivery.Attempts >= policy.MaxAttempts {
+			break
+		}
+
+		wait := policy.Backoff(delivery.Attempts)
+		logrus.Warnf("Attempt %d of %s notification for %s failed, retrying in %s: %v",
+			delivery.Attempts, n.Name(), subject, wait, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	delivery.Time = time.Now()
+	if err != nil {
+		logrus.Errorf("Failed to deliver %s notification for %s: %v", n.Name(), subject, err)
+		delivery.Status = event.StatusFailed
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	logrus.Infof("Delivered %s notification for %s", n.Name(), subject)
+	delivery.Status = event.StatusSent
+	return delivery
+}
+
+// timeouter is implemented by notifiers that set their own bound on a
+// single attempt instead of the dispatcher's.
+type timeouter interface {
+	Timeout() time.Duration
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, n Notifier, try func(ctx context.Context) (string, error)) (string, error) {
+	timeout := d.Timeout
+	if t, ok := n.(timeouter); ok {
+		timeout = t.Timeout()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return try(ctx)
+}
+
+// Overall summarises per-backend deliveries into a single status.
+func Overall(deliveries []event.Delivery) event.Status {
+	if len(deliveries) == 0 {
+		return event.StatusPending
+	}
+
+	sent := 0
+	for _, d := range deliveries {
+		if d.Status == event.StatusSent {
+			sent++
+		}
+	}
+
+	switch sent {
+	case len(deliveries):
+		return event.StatusSent
+	case 0:
+		return event.StatusFailed
+	}
+	return event.StatusPartial
+}
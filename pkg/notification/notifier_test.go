@@ -0,0 +1,270 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNotifier struct {
+	name string
+	err  error
+	got  []*event.ChangeEvent
+}
+
+func (f *fakeNotifier) Name() string {
+	return f.name
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, ev *event.ChangeEvent) error {
+	f.got = append(f.got, ev)
+	return f.err
+}
+
+func init() {
+	Register("fake", func(nc config.NotifierConfig, env Env) (Notifier, error) {
+		return &fakeNotifier{name: nc.Name}, nil
+	})
+}
+
+func TestBuildDefaultsToEmail(t *testing.T) {
+	notifiers, err := Build(&config.Config{}, Env{})
+	require.NoError(t, err)
+	require.Len(t, notifiers, 1)
+	assert.Equal(t, "email", notifiers[0].Name())
+	assert.IsType(t, &EmailNotifier{}, notifiers[0])
+}
+
+func TestBuildConfiguredNotifiers(t *testing.T) {
+	cfg := &config.Config{
+		Notifiers: []config.NotifierConfig{
+			{Type: "email"},
+			{Type: "fake", Name: "first"},
+			{Type: "fake", Name: "second"},
+		},
+	}
+
+	notifiers, err := Build(cfg, Env{})
+	require.NoError(t, err)
+	require.Len(t, notifiers, 3)
+	assert.Equal(t, "first", notifiers[1].Name())
+	assert.Equal(t, "second", notifiers[2].Name())
+}
+
+func TestBuildErrors(t *testing.T) {
+	_, err := Build(&config.Config{Notifiers: []config.NotifierConfig{{Type: "pager"}}}, Env{})
+	assert.ErrorContains(t, err, `unknown type "pager"`)
+
+	_, err = Build(&config.Config{Notifiers: []config.NotifierConfig{{Type: "fake"}, {Type: "fake"}}}, Env{})
+	assert.ErrorContains(t, err, `duplicate name "fake"`)
+}
+
+func TestDispatch(t *testing.T) {
+	ok := &fakeNotifier{name: "ok"}
+	broken := &fakeNotifier{name: "broken", err: errors.New("relay down")}
+	ev := &event.ChangeEvent{Hostname: "example.com"}
+
+	NewDispatcher([]Notifier{ok, broken}).Dispatch(context.Background(), ev)
+
+	assert.Len(t, ok.got, 1)
+	assert.Len(t, broken.got, 1)
+	require.Len(t, ev.Deliveries, 2)
+	assert.Equal(t, "ok", ev.Deliveries[0].Backend)
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status)
+	assert.Equal(t, "broken", ev.Deliveries[1].Backend)
+	assert.Equal(t, event.StatusFailed, ev.Deliveries[1].Status)
+	assert.Equal(t, "relay down", ev.Deliveries[1].Error)
+	assert.Equal(t, event.StatusPartial, ev.NotificationStatus)
+}
+
+type subscriber struct {
+	fakeNotifier
+}
+
+func (s *subscriber) Subscribes(event.Kind) bool {
+	return true
+}
+
+func TestDispatchOnlySubscribersGetOtherKinds(t *testing.T) {
+	plain := &fakeNotifier{name: "plain"}
+	all := &subscriber{fakeNotifier{name: "all"}}
+	d := NewDispatcher([]Notifier{plain, all})
+
+	failure := &event.ChangeEvent{Hostname: "example.com", Kind: event.KindLookupFailed}
+	d.Dispatch(context.Background(), failure)
+	assert.Empty(t, plain.got)
+	assert.Len(t, all.got, 1)
+	require.Len(t, failure.Deliveries, 1)
+	assert.Equal(t, "all", failure.Deliveries[0].Backend)
+
+	change := &event.ChangeEvent{Hostname: "example.com", Kind: event.KindIPChanged}
+	d.Dispatch(context.Background(), change)
+	assert.Len(t, plain.got, 1)
+	assert.Len(t, all.got, 2)
+}
+
+type batchNotifier struct {
+	fakeNotifier
+	batches [][]*event.ChangeEvent
+}
+
+func (b *batchNotifier) Batching() bool {
+	return true
+}
+
+func (b *batchNotifier) NotifyBatch(_ context.Context, evs []*event.ChangeEvent) error {
+	b.batches = append(b.batches, evs)
+	return b.err
+}
+
+func TestDispatchBatch(t *testing.T) {
+	single := &fakeNotifier{name: "single"}
+	batch := &batchNotifier{fakeNotifier: fakeNotifier{name: "batch"}}
+	d := NewDispatcher([]Notifier{single, batch})
+	require.True(t, d.Batching())
+
+	evs := []*event.ChangeEvent{
+		{Hostname: "a.example.com", Kind: event.KindIPChanged},
+		{Hostname: "b.example.com", Kind: event.KindIPChanged},
+	}
+	for _, ev := range evs {
+		d.Dispatch(context.Background(), ev)
+	}
+	assert.Len(t, single.got, 2)
+	assert.Empty(t, batch.got)
+	assert.Empty(t, batch.batches)
+
+	d.DispatchBatch(context.Background(), evs)
+	require.Len(t, batch.batches, 1)
+	assert.Len(t, batch.batches[0], 2)
+
+	for _, ev := range evs {
+		require.Len(t, ev.Deliveries, 2)
+		assert.Equal(t, "single", ev.Deliveries[0].Backend)
+		assert.Equal(t, "batch", ev.Deliveries[1].Backend)
+		assert.Equal(t, event.StatusSent, ev.NotificationStatus)
+	}
+
+	batch.err = errors.New("relay down")
+	failed := []*event.ChangeEvent{{Hostname: "c.example.com", Kind: event.KindIPChanged}}
+	d.Dispatch(context.Background(), failed[0])
+	d.DispatchBatch(context.Background(), failed)
+	assert.Equal(t, event.StatusPartial, failed[0].NotificationStatus)
+	assert.Equal(t, "relay down", failed[0].Deliveries[1].Error)
+}
+
+// partialNotifier fails the events in fail, once each, unless the error is
+// permanent.
+type partialNotifier struct {
+	batchNotifier
+	fail map[string]error
+}
+
+func (p *partialNotifier) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+}
+
+func (p *partialNotifier) NotifyBatch(_ context.Context, evs []*event.ChangeEvent) error {
+	p.batches = append(p.batches, evs)
+	failed := make(map[*event.ChangeEvent]error)
+	for _, ev := range evs {
+		if err := p.fail[ev.Hostname]; err != nil {
+			failed[ev] = err
+			var permanent *permanentError
+			if !errors.As(err, &permanent) {
+				delete(p.fail, ev.Hostname)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed}
+	}
+	return nil
+}
+
+func TestDispatchBatchRecordsEachEvent(t *testing.T) {
+	batch := &partialNotifier{
+		batchNotifier: batchNotifier{fakeNotifier: fakeNotifier{name: "batch"}},
+		fail: map[string]error{
+			"b.example.com": errors.New("relay down"),
+			"c.example.com": Permanent(errors.New("no recipients")),
+		},
+	}
+	d := NewDispatcher([]Notifier{batch})
+
+	evs := []*event.ChangeEvent{
+		{Hostname: "a.example.com", Kind: event.KindIPChanged},
+		{Hostname: "b.example.com", Kind: event.KindIPChanged},
+		{Hostname: "c.example.com", Kind: event.KindIPChanged},
+	}
+	d.DispatchBatch(context.Background(), evs)
+
+	// Only the event that failed for now is sent again
+	require.Len(t, batch.batches, 2)
+	assert.Equal(t, evs[1:2], batch.batches[1])
+
+	assert.Equal(t, event.StatusSent, evs[0].NotificationStatus)
+	assert.Equal(t, event.StatusSent, evs[1].NotificationStatus)
+	assert.Equal(t, event.StatusFailed, evs[2].NotificationStatus)
+	assert.Equal(t, "no recipients", evs[2].Deliveries[0].Error)
+	assert.Equal(t, 2, evs[2].Deliveries[0].Attempts)
+}
+
+func TestDispatchAll(t *testing.T) {
+	all := &subscriber{fakeNotifier{name: "all"}}
+	// Combines events even though it does not batch a run's changes
+	email := &unbatchedNotifier{batchNotifier{fakeNotifier: fakeNotifier{name: "email"}}}
+	d := NewDispatcher([]Notifier{all, email})
+
+	evs := []*event.ChangeEvent{
+		{Hostname: "a.example.com", Kind: event.KindLookupFailed},
+		{Hostname: "b.example.com", Kind: event.KindIPChanged},
+		{Hostname: "c.example.com", Kind: event.KindIPChanged},
+	}
+	d.DispatchAll(context.Background(), evs)
+
+	assert.Len(t, all.got, 3)
+	require.Len(t, email.batches, 1)
+	assert.Equal(t, evs[1:], email.batches[0])
+
+	require.Len(t, evs[0].Deliveries, 1)
+	for _, ev := range evs[1:] {
+		require.Len(t, ev.Deliveries, 2)
+		assert.Equal(t, "all", ev.Deliveries[0].Backend)
+		assert.Equal(t, "email", ev.Deliveries[1].Backend)
+		assert.Equal(t, event.StatusSent, ev.NotificationStatus)
+	}
+}
+
+type unbatchedNotifier struct {
+	batchNotifier
+}
+
+func (u *unbatchedNotifier) Batching() bool {
+	return false
+}
+
+func TestBuildBatch(t *testing.T) {
+	notifiers, err := Build(&config.Config{Notifiers: []config.NotifierConfig{{Type: "email", Batch: true}}}, Env{})
+	require.NoError(t, err)
+	assert.True(t, NewDispatcher(notifiers).Batching())
+
+	_, err = Build(&config.Config{Notifiers: []config.NotifierConfig{{Type: "fake", Batch: true}}}, Env{})
+	assert.ErrorContains(t, err, "does not support batch delivery")
+}
+
+func TestOverall(t *testing.T) {
+	sent := event.Delivery{Status: event.StatusSent}
+	failed := event.Delivery{Status: event.StatusFailed}
+
+	assert.Equal(t, event.StatusPending, Overall(nil))
+	assert.Equal(t, event.StatusSent, Overall([]event.Delivery{sent, sent}))
+	assert.Equal(t, event.StatusFailed, Overall([]event.Delivery{failed}))
+	assert.Equal(t, event.StatusPartial, Overall([]event.Delivery{sent, failed}))
+}
@@ -0,0 +1,242 @@
+package notification
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+)
+
+// Recipients are the addresses an email goes to.
+type Recipients struct {
+	To  []string
+	CC  []string
+	BCC []string
+}
+
+// Key identifies the set of recipients, so emails to the same people can be
+// combined.
+func (r Recipients) Key() string {
+	return strings.Join(r.To, ",") + "|" + strings.Join(r.CC, ",") + "|" + strings.Join(r.BCC, ",")
+}
+
+// Routes applies the routes in config.yaml to events. Matching routes that
+// name recipients replace the smtp to, cc and bcc addresses, and matching
+// routes that name notifiers limit the backends an event goes to, other
+// than the syslog feed. A site's owners are always added to the
+// recipients.
+type Routes struct {
+	routes   []config.RouteConfig
+	defaults Recipients
+}
+
+func NewRoutes(cfg *config.Config) (*Routes, error) {
+	r := &Routes{routes: cfg.Routes}
+
+	var err error
+	r.defaults, err = recipients(cfg.SMTP.To, cfg.SMTP.CC, cfg.SMTP.BCC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp recipients: %w", err)
+	}
+
+	for i, rc := range cfg.Routes {
+		_, err = recipients(rc.To, rc.CC, rc.BCC)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i+1, err)
+		}
+
+		for _, s := range rc.Match.Severity {
+			switch event.Severity(strings.ToLower(s)) {
+			case event.SeverityInfo, event.SeverityWarning, event.SeverityCritical:
+			default:
+				return nil, fmt.Errorf("route %d: invalid severity %q", i+1, s)
+			}
+		}
+		for _, k := range rc.Match.Kind {
+			switch event.Kind(strings.ToLower(k)) {
+			case event.KindIPChanged, event.KindLookupFailed, event.KindUnreachable:
+			default:
+				return nil, fmt.Errorf("route %d: invalid kind %q", i+1, k)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// recipients parses the address lists, each entry of which may hold several
+// comma separated addresses.
+func recipients(to, cc, bcc []string) (Recipients, error) {
+	var r Recipients
+	var err error
+	r.To, err = addresses(to)
+	if err != nil {
+		return r, err
+	}
+	r.CC, err = addresses(cc)
+	if err != nil {
+		return r, err
+	}
+	r.BCC, err = addresses(bcc)
+	if err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+func addresses(list []string) ([]string, error) {
+	var out []string
+	for _, entry := range list {
+		parsed, err := mail.ParseAddressList(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", entry, err)
+		}
+		for _, a := range parsed {
+			if a.Name == "" {
+				out = append(out, a.Address)
+				continue
+			}
+			out = append(out, a.String())
+		}
+	}
+	return out, nil
+}
+
+// Match returns the routes that apply to ev.
+func (r *Routes) Match(ev *event.ChangeEvent) []config.RouteConfig {
+	var matched []config.RouteConfig
+	for _, rc := range r.routes {
+		m := rc.Match
+		if len(m.Entity) > 0 && !containsFold(m.Entity, ev.EntityName) {
+			continue
+		}
+		if len(m.Severity) > 0 && !containsFold(m.Severity, string(ev.Severity)) {
+			continue
+		}
+		if len(m.Kind) > 0 && !containsFold(m.Kind, string(ev.Kind)) {
+			continue
+		}
+		if len(m.Tags) > 0 && !anyFold(m.Tags, ev.Tags) {
+			continue
+		}
+		matched = append(matched, rc)
+	}
+	return matched
+}
+
+// Recipients returns who should get an email about ev.
+func (r *Routes) Recipients(ev *event.ChangeEvent) Recipients {
+	var to, cc, bcc []string
+	routed := false
+	for _, rc := range r.Match(ev) {
+		if len(rc.To)+len(rc.CC)+len(rc.BCC) == 0 {
+			continue
+		}
+		routed = true
+		to = append(to, rc.To...)
+		cc = append(cc, rc.CC...)
+		bcc = append(bcc, rc.BCC...)
+	}
+
+	rcpt := r.defaults
+	if routed {
+		// Addresses were checked by NewRoutes
+		rcpt, _ = recipients(to, cc, bcc)
+	}
+
+	owners, err := addresses(ev.Owners)
+	if err != nil {
+		logrus.Warnf("Ignoring owners of %s: %v", ev.Hostname, err)
+	}
+	rcpt.To = append(append([]string(nil), rcpt.To...), owners...)
+
+	return dedupe(rcpt)
+}
+
+// Allows reports whether ev should go to the backend called name.
+func (r *Routes) Allows(ev *event.ChangeEvent, name string) bool {
+	limited := false
+	for _, rc := range r.Match(ev) {
+		if len(rc.Notifiers) == 0 {
+			continue
+		}
+		limited = true
+		if containsFold(rc.Notifiers, name) {
+			return true
+		}
+	}
+	return !limited
+}
+
+// Notifiers lists every backend named by a route.
+func (r *Routes) Notifiers() []string {
+	var names []string
+	for _, rc := range r.routes {
+		names = append(names, rc.Notifiers...)
+	}
+	return names
+}
+
+// dedupe drops repeated addresses, keeping the first occurrence in To, CC
+// then BCC order.
+func dedupe(r Recipients) Recipients {
+	seen := map[string]bool{}
+	keep := func(list []string) []string {
+		var out []string
+		for _, a := range list {
+			key := strings.ToLower(a)
+			if parsed, err := mail.ParseAddress(a); err == nil {
+				key = strings.ToLower(parsed.Address)
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			out = append(out, a)
+		}
+		return out
+	}
+
+	return Recipients{To: keep(r.To), CC: keep(r.CC), BCC: keep(r.BCC)}
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyFold(list, values []string) bool {
+	for _, v := range values {
+		if containsFold(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupByRecipients splits evs into groups that go to the same people,
+// in order of first appearance.
+func groupByRecipients(r *Routes, evs []*event.ChangeEvent) ([]Recipients, [][]*event.ChangeEvent) {
+	index := map[string]int{}
+	var keys []Recipients
+	var groups [][]*event.ChangeEvent
+	for _, ev := range evs {
+		rcpt := r.Recipients(ev)
+		i, ok := index[rcpt.Key()]
+		if !ok {
+			i = len(keys)
+			index[rcpt.Key()] = i
+			keys = append(keys, rcpt)
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], ev)
+	}
+	return keys, groups
+}
@@ -0,0 +1,175 @@
+package notification
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func routeConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.SMTP.To = config.StringList{"noc@example.com"}
+	cfg.SMTP.BCC = config.StringList{"audit@example.com"}
+
+	payments := config.RouteConfig{
+		To: config.StringList{"payments@example.com, Payments Lead <lead@example.com>"},
+		CC: config.StringList{"noc@example.com"},
+	}
+	payments.Match.Entity = config.StringList{"Acme Payments"}
+
+	pci := config.RouteConfig{To: config.StringList{"pci@example.com"}}
+	pci.Match.Tags = config.StringList{"pci"}
+
+	critical := config.RouteConfig{Notifiers: config.StringList{"pager"}}
+	critical.Match.Severity = config.StringList{"critical"}
+	critical.Match.Kind = config.StringList{"unreachable"}
+
+	cfg.Routes = []config.RouteConfig{payments, pci, critical}
+	return cfg
+}
+
+func TestRoutesRecipients(t *testing.T) {
+	r, err := NewRoutes(routeConfig())
+	require.NoError(t, err)
+
+	// Unrouted events go to the smtp addresses
+	rcpt := r.Recipients(&event.ChangeEvent{EntityName: "Other"})
+	assert.Equal(t, []string{"noc@example.com"}, rcpt.To)
+	assert.Empty(t, rcpt.CC)
+	assert.Equal(t, []string{"audit@example.com"}, rcpt.BCC)
+
+	// Matching routes replace them, and owners are added
+	rcpt = r.Recipients(&event.ChangeEvent{
+		EntityName: "acme payments",
+		Tags:       []string{"PCI"},
+		Owners:     []string{"owner@example.com", "PCI@example.com"},
+	})
+	assert.Equal(t, []string{
+		"payments@example.com",
+		`"Payments Lead" <lead@example.com>`,
+		"pci@example.com",
+		"owner@example.com",
+	}, rcpt.To)
+	assert.Equal(t, []string{"noc@example.com"}, rcpt.CC)
+	assert.Empty(t, rcpt.BCC)
+
+	// Invalid owners are skipped
+	rcpt = r.Recipients(&event.ChangeEvent{Owners: []string{"not an address"}})
+	assert.Equal(t, []string{"noc@example.com"}, rcpt.To)
+}
+
+func TestRoutesAllows(t *testing.T) {
+	r, err := NewRoutes(routeConfig())
+	require.NoError(t, err)
+
+	down := &event.ChangeEvent{Kind: event.KindUnreachable, Severity: event.SeverityCritical}
+	assert.True(t, r.Allows(down, "pager"))
+	assert.False(t, r.Allows(down, "email"))
+
+	change := &event.ChangeEvent{Kind: event.KindIPChanged, Severity: event.SeverityCritical}
+	assert.True(t, r.Allows(change, "email"))
+	assert.Equal(t, []string{"pager"}, r.Notifiers())
+}
+
+func TestRoutesKeepSyslog(t *testing.T) {
+	pager := &fakeNotifier{name: "pager"}
+	email := &fakeNotifier{name: "email"}
+	syslog := &SyslogNotifier{}
+	d := NewDispatcher([]Notifier{email, pager, syslog})
+
+	cfg := routeConfig()
+	cfg.Routes[2].Match.Kind = nil
+	var err error
+	d.Routes, err = NewRoutes(cfg)
+	require.NoError(t, err)
+
+	// The route limits the change to the pager, but syslog still gets it
+	change := &event.ChangeEvent{Kind: event.KindIPChanged, Severity: event.SeverityCritical}
+	assert.Equal(t, []Notifier{pager, syslog}, d.For(change))
+}
+
+func TestNewRoutesErrors(t *testing.T) {
+	cfg := routeConfig()
+	cfg.SMTP.To = config.StringList{"not an address"}
+	_, err := NewRoutes(cfg)
+	assert.ErrorContains(t, err, "invalid smtp recipients")
+
+	cfg = routeConfig()
+	cfg.Routes[1].Match.Severity = config.StringList{"urgent"}
+	_, err = NewRoutes(cfg)
+	assert.ErrorContains(t, err, `route 2: invalid severity "urgent"`)
+
+	cfg = routeConfig()
+	cfg.Routes[2].Match.Kind = config.StringList{"down"}
+	_, err = NewRoutes(cfg)
+	assert.ErrorContains(t, err, `route 3: invalid kind "down"`)
+
+	_, err = Build(routeConfig(), Env{})
+	assert.ErrorContains(t, err, `route names unknown notifier "pager"`)
+}
+
+func TestDispatchRouted(t *testing.T) {
+	email := &batchNotifier{fakeNotifier: fakeNotifier{name: "email"}}
+	pager := &fakeNotifier{name: "pager"}
+	d := NewDispatcher([]Notifier{email, pager})
+
+	cfg := routeConfig()
+	cfg.Routes[2].Match.Kind = nil
+	var err error
+	d.Routes, err = NewRoutes(cfg)
+	require.NoError(t, err)
+
+	evs := []*event.ChangeEvent{
+		{Hostname: "a.example.com", Kind: event.KindIPChanged, Severity: event.SeverityCritical},
+		{Hostname: "b.example.com", Kind: event.KindIPChanged, Severity: event.SeverityInfo},
+	}
+	for _, ev := range evs {
+		d.Dispatch(context.Background(), ev)
+	}
+	d.DispatchBatch(context.Background(), evs)
+
+	// Critical events only go to the pager, the rest go everywhere
+	assert.Len(t, pager.got, 2)
+	require.Len(t, email.batches, 1)
+	assert.Equal(t, []*event.ChangeEvent{evs[1]}, email.batches[0])
+
+	require.Len(t, evs[0].Deliveries, 1)
+	assert.Equal(t, "pager", evs[0].Deliveries[0].Backend)
+	require.Len(t, evs[1].Deliveries, 2)
+	assert.Equal(t, "email", evs[1].Deliveries[1].Backend)
+}
+
+func TestEmailNotifyBatchGroupsRecipients(t *testing.T) {
+	s := newFakeSMTP(t, nil, false, false)
+
+	cfg := routeConfig()
+	cfg.SMTP = s.config()
+	cfg.SMTP.TLS.Mode = SMTPTLSNone
+	cfg.Routes = cfg.Routes[:2]
+
+	n, err := newEmailNotifier(config.NotifierConfig{Name: "email", Type: "email", Batch: true}, Env{Config: cfg})
+	require.NoError(t, err)
+
+	evs := []*event.ChangeEvent{
+		{Hostname: "a.example.com", EntityName: "Acme Payments", Kind: event.KindIPChanged},
+		{Hostname: "b.example.com", EntityName: "Other", Kind: event.KindIPChanged},
+		{Hostname: "c.example.com", EntityName: "Acme Payments", Kind: event.KindIPChanged},
+	}
+	require.NoError(t, n.(BatchNotifier).NotifyBatch(context.Background(), evs))
+
+	first, second := <-s.received, <-s.received
+	assert.Contains(t, first, "To: payments@example.com, \"Payments Lead\" <lead@example.com>")
+	assert.Contains(t, first, "Cc: noc@example.com")
+	assert.Contains(t, first, "2 changes")
+	assert.NotContains(t, first, "Bcc")
+	assert.Contains(t, second, "To: noc@example.com")
+	assert.Contains(t, second, "1 changes")
+
+	// The contact line names who the email went to
+	assert.Contains(t, first, "Contact DL: payments@example.com")
+	assert.Contains(t, second, "Contact DL: noc@example.com")
+}
@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+)
+
+func init() {
+	Register("servicenow", newServiceNowNotifier)
+}
+
+const defaultServiceNowTable = "change_request"
+
+// serviceNow talks to the ServiceNow Table API. Tickets are identified by
+// their number, e.g. CHG0030001.
+type serviceNow struct {
+	client *ticketClient
+	table  string
+}
+
+type serviceNowRecord struct {
+	SysID  string `json:"sys_id"`
+	Number string `json:"number"`
+	Active string `json:"active"`
+}
+
+func newServiceNowNotifier(nc config.NotifierConfig, env Env) (Notifier, error) {
+	if nc.Ticket == nil {
+		return nil, errors.New("ticket settings missing")
+	}
+
+	client, err := newTicketClient(nc.Ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	table := nc.Ticket.Table
+	if table == "" {
+		table = defaultServiceNowTable
+	}
+
+	return newTicketNotifier(nc, env, &serviceNow{
+		client: client,
+		table:  table,
+	})
+}
+
+func (s *serviceNow) path() string {
+	return "/api/now/table/" + url.PathEscape(s.table)
+}
+
+func (s *serviceNow) Create(ctx context.Context, t ticket) (string, error) {
+	record := map[string]interface{}{
+		"short_description": t.Summary,
+		"description":       t.Description,
+	}
+	for k, v := range t.Fields {
+		record[k] = v
+	}
+
+	var resp struct {
+		Result serviceNowRecord `json:"result"`
+	}
+	err := s.client.do(ctx, "POST", s.path(), nil, record, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Result.Number == "" {
+		return "", errors.New("servicenow did not return a record number")
+	}
+
+	return resp.Result.Number, nil
+}
+
+// find looks a record up by number. It returns nil if there is none.
+func (s *serviceNow) find(ctx context.Context, number string) (*serviceNowRecord, error) {
+	query := url.Values{
+		"sysparm_query":  {"number=" + number},
+		"sysparm_fields": {"sys_id,number,active"},
+		"sysparm_limit":  {"1"},
+	}
+
+	var resp struct {
+		Result []serviceNowRecord `json:"result"`
+	}
+	err := s.client.do(ctx, "GET", s.path(), query, nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Result) == 0 {
+		return nil, nil
+	}
+
+	return &resp.Result[0], nil
+}
+
+// IsOpen reports the record's active flag. A record that no longer exists
+// counts as closed.
+func (s *serviceNow) IsOpen(ctx context.Context, number string) (bool, error) {
+	r, err := s.find(ctx, number)
+	if err != nil {
+		return false, err
+	}
+
+	return r != nil && r.Active == "true", nil
+}
+
+func (s *serviceNow) Comment(ctx context.Context, number, text string) error {
+	r, err := s.find(ctx, number)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("%s %s not found", s.table, number)
+	}
+
+	return s.client.do(ctx, "PATCH", s.path()+"/"+url.PathEscape(r.SysID), nil, map[string]string{"work_notes": text}, nil)
+}
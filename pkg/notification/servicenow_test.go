@@ -0,0 +1,143 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServiceNow is a minimal stand-in for the ServiceNow Table API.
+type fakeServiceNow struct {
+	mu      sync.Mutex
+	records []map[string]interface{}
+}
+
+func newFakeServiceNow(t *testing.T) (*fakeServiceNow, *httptest.Server) {
+	s := &fakeServiceNow{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sn-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		path := strings.TrimPrefix(r.URL.Path, "/api/now/table/change_request")
+		switch {
+		case r.Method == http.MethodPost && path == "":
+			var record map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+			record["sys_id"] = fmt.Sprintf("sys%d", len(s.records)+1)
+			record["number"] = fmt.Sprintf("CHG%07d", len(s.records)+1)
+			record["active"] = "true"
+			s.records = append(s.records, record)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": record})
+
+		case r.Method == http.MethodGet && path == "":
+			number := strings.TrimPrefix(r.URL.Query().Get("sysparm_query"), "number=")
+			result := []map[string]interface{}{}
+			for _, record := range s.records {
+				if record["number"] == number {
+					result = append(result, record)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+
+		case r.Method == http.MethodPatch:
+			var update map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&update))
+			for _, record := range s.records {
+				if "/"+record["sys_id"].(string) == path {
+					notes, _ := record["work_notes"].([]string)
+					record["work_notes"] = append(notes, update["work_notes"].(string))
+					json.NewEncoder(w).Encode(map[string]interface{}{"result": record})
+					return
+				}
+			}
+			http.NotFound(w, r)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func TestServiceNowOpensTicketsAndComments(t *testing.T) {
+	s, server := newFakeServiceNow(t)
+
+	tickets := map[string]string{}
+	env := Env{LastTicket: func(backend, siteID string) (string, error) {
+		return tickets[backend+"/"+siteID], nil
+	}}
+
+	n, err := newServiceNowNotifier(config.NotifierConfig{
+		Type: "servicenow",
+		Name: "snow",
+		Ticket: &config.TicketConfig{
+			URL:    server.URL + "/",
+			Token:  "sn-token",
+			Fields: map[string]interface{}{"assignment_group": "Network", "cmdb_ci": "{{.Event.Hostname}}"},
+		},
+	}, env)
+	require.NoError(t, err)
+	d := NewDispatcher([]Notifier{n})
+
+	ev := &event.ChangeEvent{ID: "e1", SiteID: "sftp.vendor.com", Hostname: "sftp.vendor.com", Kind: event.KindIPChanged,
+		OldIPs: []string{"1.1.1.1"}, NewIPs: []string{"2.2.2.2"}}
+	d.Dispatch(context.Background(), ev)
+
+	require.Len(t, ev.Deliveries, 1)
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status)
+	assert.Equal(t, "CHG0000001", ev.Deliveries[0].Ticket)
+	require.Len(t, s.records, 1)
+	assert.Equal(t, "IP address change for sftp.vendor.com", s.records[0]["short_description"])
+	assert.Equal(t, "Network", s.records[0]["assignment_group"])
+	assert.Equal(t, "sftp.vendor.com", s.records[0]["cmdb_ci"])
+
+	tickets["snow/sftp.vendor.com"] = "CHG0000001"
+	repeat := &event.ChangeEvent{ID: "e2", SiteID: "sftp.vendor.com", Hostname: "sftp.vendor.com", Kind: event.KindIPChanged,
+		OldIPs: []string{"2.2.2.2"}, NewIPs: []string{"3.3.3.3"}}
+	d.Dispatch(context.Background(), repeat)
+
+	assert.Equal(t, "CHG0000001", repeat.Deliveries[0].Ticket)
+	require.Len(t, s.records, 1)
+	assert.Len(t, s.records[0]["work_notes"], 1)
+
+	s.records[0]["active"] = "false"
+	another := &event.ChangeEvent{ID: "e3", SiteID: "sftp.vendor.com", Hostname: "sftp.vendor.com", Kind: event.KindIPChanged}
+	d.Dispatch(context.Background(), another)
+
+	assert.Equal(t, "CHG0000002", another.Deliveries[0].Ticket)
+	assert.Len(t, s.records, 2)
+}
+
+func TestServiceNowMissingTicketOpensNew(t *testing.T) {
+	s, server := newFakeServiceNow(t)
+
+	n, err := newServiceNowNotifier(config.NotifierConfig{
+		Type:   "servicenow",
+		Name:   "snow",
+		Ticket: &config.TicketConfig{URL: server.URL, Token: "sn-token"},
+	}, Env{LastTicket: func(string, string) (string, error) { return "CHG9999999", nil }})
+	require.NoError(t, err)
+
+	key, err := n.(*TicketNotifier).RaiseTicket(context.Background(), &event.ChangeEvent{Hostname: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "CHG0000001", key)
+	assert.Len(t, s.records, 1)
+}
@@ -0,0 +1,183 @@
+package notification
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/digitorus/pkcs7"
+	"github.com/sirupsen/logrus"
+)
+
+// SMIMESigner turns a MIME message into a multipart/signed one carrying a
+// detached S/MIME signature over the original body.
+type SMIMESigner struct {
+	cert    *x509.Certificate
+	parents []*x509.Certificate
+	key     crypto.PrivateKey
+}
+
+// NewSMIMESigner loads the certificate and key in sc. The certificate
+// file may hold intermediates after the signing certificate, which are
+// sent along so recipients can build the chain.
+func NewSMIMESigner(sc config.SMIMEConfig, from string) (*SMIMESigner, error) {
+	if sc.CertFile == "" || sc.KeyFile == "" {
+		return nil, errors.New("smtp smime cert_file and key_file must be set together")
+	}
+
+	pair, err := tls.LoadX509KeyPair(sc.CertFile, sc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load smime certificate: %w", err)
+	}
+
+	s := &SMIMESigner{key: pair.PrivateKey}
+	for i, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse smime certificate: %w", err)
+		}
+		if i == 0 {
+			s.cert = cert
+		} else {
+			s.parents = append(s.parents, cert)
+		}
+	}
+
+	// Mail clients flag these, so warn rather than send unverifiable mail
+	// without anyone noticing
+	now := time.Now()
+	if now.Before(s.cert.NotBefore) || now.After(s.cert.NotAfter) {
+		logrus.Warnf("S/MIME certificate %s is not valid now, it runs from %s to %s",
+			s.cert.Subject, s.cert.NotBefore.Format(time.RFC3339), s.cert.NotAfter.Format(time.RFC3339))
+	}
+	if !coversAddress(s.cert, from) {
+		logrus.Warnf("S/MIME certificate %s is not issued for the smtp from address %s", s.cert.Subject, from)
+	}
+
+	return s, nil
+}
+
+func coversAddress(cert *x509.Certificate, from string) bool {
+	addr := from
+	if i := strings.LastIndex(addr, "<"); i >= 0 {
+		addr = strings.TrimSuffix(addr[i+1:], ">")
+	}
+	for _, e := range cert.EmailAddresses {
+		if strings.EqualFold(e, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns msg, a complete message with CRLF line endings, as a
+// multipart/signed message. The Content-* headers move into the signed
+// part and the other headers stay on the outside.
+func (s *SMIMESigner) Sign(msg []byte) ([]byte, error) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, errors.New("message has no body")
+	}
+
+	var outer, content bytes.Buffer
+	for _, field := range headerFields(msg[:end+2]) {
+		if strings.HasPrefix(strings.ToLower(field), "content-") {
+			content.WriteString(field)
+		} else {
+			outer.WriteString(field)
+		}
+	}
+	if content.Len() == 0 {
+		content.WriteString("Content-Type: text/plain; charset=us-ascii\r\n")
+	}
+	content.WriteString("\r\n")
+	content.Write(msg[end+4:])
+	entity := content.Bytes()
+
+	sd, err := pkcs7.NewSignedData(entity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign email: %w", err)
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	err = sd.AddSignerChain(s.cert, s.key, s.parents, pkcs7.SignerInfoConfig{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign email: %w", err)
+	}
+	sd.Detach()
+	signature, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign email: %w", err)
+	}
+
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mime boundary: %w", err)
+	}
+	boundary := "signed-" + hex.EncodeToString(b)
+
+	var out bytes.Buffer
+	out.Write(outer.Bytes())
+	fmt.Fprintf(&out, "Content-Type: multipart/signed; protocol=\"application/pkcs7-signature\"; micalg=sha-256;\r\n boundary=\"%s\"\r\n\r\n", boundary)
+	out.WriteString("This is an S/MIME signed message\r\n\r\n")
+	fmt.Fprintf(&out, "--%s\r\n", boundary)
+	out.Write(entity)
+	if !bytes.HasSuffix(entity, []byte("\r\n")) {
+		out.WriteString("\r\n")
+	}
+	fmt.Fprintf(&out, "--%s\r\n", boundary)
+	out.WriteString("Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n")
+	out.WriteString("Content-Transfer-Encoding: base64\r\n")
+	out.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(signature)
+	for len(encoded) > 76 {
+		out.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	out.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&out, "--%s--\r\n", boundary)
+
+	return out.Bytes(), nil
+}
+
+// headerFields splits a header block into fields, keeping folded lines
+// with the field they continue.
+func headerFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// signedMessage renders msg and signs it.
+func (s *SMIMESigner) signedMessage(msg io.WriterTo) (io.WriterTo, error) {
+	var buf bytes.Buffer
+	_, err := msg.WriteTo(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render email: %w", err)
+	}
+
+	signed, err := s.Sign(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(signed), nil
+}
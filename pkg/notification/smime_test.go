@@ -0,0 +1,157 @@
+package notification
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/digitorus/pkcs7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smimeCert issues a signing certificate for digger@example.com from a
+// throwaway CA. The certificate file holds the CA after the signing
+// certificate, like an intermediate.
+func smimeCert(t *testing.T, key crypto.Signer) (config.SMIMEConfig, *x509.CertPool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "digger test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: "digger"},
+		EmailAddresses: []string{"digger@example.com"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, key.Public(), caKey)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	sc := config.SMIMEConfig{CertFile: filepath.Join(dir, "smime.pem"), KeyFile: filepath.Join(dir, "smime.key")}
+	certs := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	require.NoError(t, os.WriteFile(sc.CertFile, certs, 0644))
+	require.NoError(t, os.WriteFile(sc.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return sc, roots
+}
+
+// verifySigned checks msg is a valid multipart/signed message and returns
+// the signed part.
+func verifySigned(t *testing.T, msg string, roots *x509.CertPool) string {
+	m, err := mail.ReadMessage(strings.NewReader(msg))
+	require.NoError(t, err)
+	assert.Equal(t, "Test", m.Header.Get("Subject"))
+	assert.Empty(t, m.Header.Get("Content-Transfer-Encoding"))
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/signed", mediaType)
+	assert.Equal(t, "application/pkcs7-signature", params["protocol"])
+	assert.Equal(t, "sha-256", params["micalg"])
+
+	// The signature covers the first part exactly as sent
+	body := msg[strings.Index(msg, "\r\n\r\n")+4:]
+	parts := strings.Split(body, "--"+params["boundary"])
+	require.Len(t, parts, 4)
+	signed := strings.TrimPrefix(parts[1], "\r\n")
+	sigPart := parts[2][strings.Index(parts[2], "\r\n\r\n")+4:]
+
+	der, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(sigPart, "\r\n", ""))
+	require.NoError(t, err)
+	p7, err := pkcs7.Parse(der)
+	require.NoError(t, err)
+	p7.Content = []byte(signed)
+	require.NoError(t, p7.VerifyWithOpts(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}))
+
+	// Tampering breaks it
+	p7.Content = bytes.Replace([]byte(signed), []byte("plain body"), []byte("plain bodY"), 1)
+	assert.Error(t, p7.Verify())
+
+	return signed
+}
+
+func TestSMIMESigning(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeSMTP(t, nil, false, false)
+			sc := s.config()
+			sc.TLS.Mode = SMTPTLSNone
+
+			var roots *x509.CertPool
+			sc.SMIME, roots = smimeCert(t, key)
+			require.NoError(t, send(sc))
+
+			signed := verifySigned(t, <-s.received, roots)
+			assert.Contains(t, signed, "Content-Type: multipart/alternative")
+			assert.Contains(t, signed, "plain body")
+			assert.Contains(t, signed, "<p>html body</p>")
+		})
+	}
+}
+
+func TestSMIMEConfigErrors(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	sc, _ := smimeCert(t, key)
+
+	_, err = NewSMIMESigner(config.SMIMEConfig{CertFile: sc.CertFile}, "digger@example.com")
+	assert.ErrorContains(t, err, "must be set together")
+
+	_, err = NewSMIMESigner(config.SMIMEConfig{CertFile: sc.CertFile, KeyFile: "missing.key"}, "digger@example.com")
+	assert.ErrorContains(t, err, "failed to load smime certificate")
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	// A key that does not match the certificate
+	mismatched, _ := smimeCert(t, rsaKey)
+	_, err = NewSMIMESigner(config.SMIMEConfig{CertFile: sc.CertFile, KeyFile: mismatched.KeyFile}, "digger@example.com")
+	assert.Error(t, err)
+
+	assert.True(t, coversAddress(&x509.Certificate{EmailAddresses: []string{"digger@example.com"}}, "Digger <Digger@example.com>"))
+	assert.False(t, coversAddress(&x509.Certificate{EmailAddresses: []string{"digger@example.com"}}, "other@example.com"))
+}
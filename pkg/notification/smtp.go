@@ -0,0 +1,276 @@
+package notification
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/gophish/gomail"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	SMTPTLSNone          = "none"
+	SMTPTLSOpportunistic = "opportunistic"
+	SMTPTLSStartTLS      = "starttls"
+	SMTPTLSImplicit      = "tls"
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPTransport delivers messages to the configured mail server with the
+// smtp.tls settings.
+type SMTPTransport struct {
+	addr     string
+	host     string
+	mode     string
+	tls      *tls.Config
+	timeout  time.Duration
+	username string
+	password string
+	signer   *SMIMESigner
+}
+
+// NewSMTPTransport validates the smtp settings and loads any certificates
+// they name.
+func NewSMTPTransport(sc config.SMTPConfig) (*SMTPTransport, error) {
+	t := &SMTPTransport{
+		addr:     net.JoinHostPort(sc.Host, strconv.Itoa(sc.Port)),
+		host:     sc.Host,
+		mode:     sc.TLS.Mode,
+		timeout:  sc.Timeout,
+		username: sc.Username,
+		password: sc.Password,
+	}
+
+	switch t.mode {
+	case "":
+		t.mode = SMTPTLSOpportunistic
+		if sc.Port == 465 {
+			t.mode = SMTPTLSImplicit
+		}
+	case SMTPTLSNone, SMTPTLSOpportunistic, SMTPTLSStartTLS, SMTPTLSImplicit:
+	default:
+		return nil, fmt.Errorf("invalid smtp tls mode %q, expected none, opportunistic, starttls or tls", sc.TLS.Mode)
+	}
+
+	if t.timeout <= 0 {
+		t.timeout = defaultSMTPTimeout
+	}
+
+	t.tls = &tls.Config{
+		ServerName:         sc.Host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: sc.TLS.InsecureSkipVerify,
+	}
+	if sc.TLS.ServerName != "" {
+		t.tls.ServerName = sc.TLS.ServerName
+	}
+
+	if sc.TLS.CAFile != "" {
+		pool, err := loadCertPool(sc.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		t.tls.RootCAs = pool
+	}
+
+	if sc.TLS.CertFile != "" || sc.TLS.KeyFile != "" {
+		if sc.TLS.CertFile == "" || sc.TLS.KeyFile == "" {
+			return nil, errors.New("smtp tls cert_file and key_file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(sc.TLS.CertFile, sc.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load smtp client certificate: %w", err)
+		}
+		t.tls.Certificates = []tls.Certificate{cert}
+	}
+
+	if sc.SMIME.CertFile != "" || sc.SMIME.KeyFile != "" {
+		var err error
+		t.signer, err = NewSMIMESigner(sc.SMIME, sc.From)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if sc.TLS.InsecureSkipVerify && t.mode != SMTPTLSNone {
+		logrus.Warnf("Certificate verification for SMTP server %s is disabled", sc.Host)
+	}
+
+	return t, nil
+}
+
+// loadCertPool reads the PEM certificates in path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// Send delivers m to the recipients in its To, Cc and Bcc headers, signed
+// if smtp.smime is set.
+func (t *SMTPTransport) Send(m *gomail.Message) error {
+	c, err := t.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	err = gomail.Send(gomail.SendFunc(func(from string, to []string, msg io.WriterTo) error {
+		if t.signer != nil {
+			var err error
+			msg, err = t.signer.signedMessage(msg)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := c.Mail(from)
+		if err != nil {
+			return err
+		}
+		for _, rcpt := range to {
+			err = c.Rcpt(rcpt)
+			if err != nil {
+				return err
+			}
+		}
+
+		w, err := c.Data()
+		if err != nil {
+			return err
+		}
+		_, err = msg.WriteTo(w)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}), m)
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	// The message is accepted by now, so a failed QUIT is not worth a retry
+	err = c.Quit()
+	if err != nil {
+		logrus.Debugf("Failed to close smtp session with %s: %v", t.addr, err)
+	}
+
+	return nil
+}
+
+// dial connects and authenticates to the server, securing the connection as
+// the tls mode requires.
+func (t *SMTPTransport) dial() (*smtp.Client, error) {
+	dialer := &net.Dialer{Timeout: t.timeout}
+
+	var conn net.Conn
+	var err error
+	if t.mode == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", t.addr, t.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", t.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server %s: %w", t.addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(t.timeout))
+
+	c, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start smtp session with %s: %w", t.addr, err)
+	}
+
+	if t.mode == SMTPTLSOpportunistic || t.mode == SMTPTLSStartTLS {
+		ok, _ := c.Extension("STARTTLS")
+		switch {
+		case ok:
+			err = c.StartTLS(t.tls)
+			if err != nil {
+				c.Close()
+				return nil, fmt.Errorf("failed to start tls with smtp server %s: %w", t.addr, err)
+			}
+		case t.mode == SMTPTLSStartTLS:
+			c.Close()
+			return nil, fmt.Errorf("smtp server %s does not offer STARTTLS", t.addr)
+		default:
+			logrus.Warnf("SMTP server %s does not offer STARTTLS, sending without encryption", t.addr)
+		}
+	}
+
+	if t.username != "" {
+		if ok, mechanisms := c.Extension("AUTH"); ok {
+			err = c.Auth(t.auth(mechanisms))
+			if err != nil {
+				c.Close()
+				return nil, fmt.Errorf("failed to authenticate to smtp server %s: %w", t.addr, err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// auth picks a mechanism the server offers, the same way gomail does.
+func (t *SMTPTransport) auth(mechanisms string) smtp.Auth {
+	switch {
+	case strings.Contains(mechanisms, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(t.username, t.password)
+	case strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN"):
+		return &loginAuth{username: t.username, password: t.password, host: t.host}
+	}
+	return smtp.PlainAuth("", t.username, t.password, t.host)
+}
+
+// loginAuth implements the LOGIN mechanism, which like PLAIN is only used
+// over an encrypted connection or to localhost.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch {
+	case bytes.EqualFold(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.EqualFold(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
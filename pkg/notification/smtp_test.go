@@ -0,0 +1,296 @@
+package notification
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is a minimal SMTP server that records what it receives.
+type fakeSMTP struct {
+	listener net.Listener
+	tls      *tls.Config
+	implicit bool
+	starttls bool
+
+	received chan string
+	auth     chan string
+}
+
+func newFakeSMTP(t *testing.T, tlsConfig *tls.Config, implicit, starttls bool) *fakeSMTP {
+	s := &fakeSMTP{
+		tls:      tlsConfig,
+		implicit: implicit,
+		starttls: starttls,
+		received: make(chan string, 4),
+		auth:     make(chan string, 1),
+	}
+
+	var err error
+	if implicit {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { s.listener.Close() })
+
+	go s.serve()
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	secure := s.implicit
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	reply := func(line string) {
+		w.WriteString(line + "\r\n")
+		w.Flush()
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+
+		switch cmd {
+		case "EHLO":
+			reply("250-fake")
+			if s.starttls && !secure {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 go ahead")
+			tc := tls.Server(conn, s.tls)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, secure = tc, true
+			r, w = bufio.NewReader(conn), bufio.NewWriter(conn)
+		case "AUTH":
+			s.auth <- strings.TrimSpace(line)
+			reply("235 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.received <- msg.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) config() config.SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return config.SMTPConfig{
+		Host:    host,
+		Port:    p,
+		From:    "digger@example.com",
+		To:      config.StringList{"noc@example.com"},
+		Timeout: 5 * time.Second,
+	}
+}
+
+// serverTLS borrows httptest's certificate, which is valid for 127.0.0.1,
+// and writes it to a CA file.
+func serverTLS(t *testing.T) (*tls.Config, string) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+
+	return &tls.Config{Certificates: ts.TLS.Certificates}, caFile
+}
+
+// clientCert writes a self-signed client certificate and its key, and
+// returns a pool that trusts it.
+func clientCert(t *testing.T) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "digger"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func send(sc config.SMTPConfig) error {
+	transport, err := NewSMTPTransport(sc)
+	if err != nil {
+		return err
+	}
+	return transport.Send(NewMessage(sc, Recipients{To: sc.To}, "Test", "plain body", "<p>html body</p>"))
+}
+
+func TestSMTPPlain(t *testing.T) {
+	s := newFakeSMTP(t, nil, false, false)
+
+	sc := s.config()
+	sc.TLS.Mode = SMTPTLSNone
+	require.NoError(t, send(sc))
+
+	msg := <-s.received
+	assert.Contains(t, msg, "Subject: Test")
+	assert.Contains(t, msg, "Content-Type: multipart/alternative")
+	assert.Contains(t, msg, "plain body")
+	assert.Contains(t, msg, "<p>html body</p>")
+
+	// Opportunistic STARTTLS falls back to plain text
+	sc.TLS.Mode = ""
+	require.NoError(t, send(sc))
+	<-s.received
+
+	sc.TLS.Mode = SMTPTLSStartTLS
+	assert.ErrorContains(t, send(sc), "does not offer STARTTLS")
+}
+
+func TestSMTPStartTLS(t *testing.T) {
+	tlsConfig, caFile := serverTLS(t)
+	s := newFakeSMTP(t, tlsConfig, false, true)
+
+	sc := s.config()
+	sc.TLS.Mode = SMTPTLSStartTLS
+	sc.Username = "digger"
+	sc.Password = "secret"
+
+	// The test certificate is not trusted by default
+	assert.ErrorContains(t, send(sc), "failed to start tls")
+
+	sc.TLS.CAFile = caFile
+	require.NoError(t, send(sc))
+	assert.Contains(t, <-s.received, "plain body")
+	assert.Equal(t, "AUTH PLAIN AGRpZ2dlcgBzZWNyZXQ=", <-s.auth)
+
+	// The certificate must match the server name
+	sc.TLS.ServerName = "mail.example.org"
+	assert.Error(t, send(sc))
+
+	sc.TLS.CAFile = ""
+	sc.TLS.InsecureSkipVerify = true
+	require.NoError(t, send(sc))
+	<-s.received
+}
+
+func TestSMTPImplicitTLSWithClientCert(t *testing.T) {
+	tlsConfig, caFile := serverTLS(t)
+	certFile, keyFile, clients := clientCert(t)
+	tlsConfig.ClientCAs = clients
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	s := newFakeSMTP(t, tlsConfig, true, false)
+
+	sc := s.config()
+	sc.TLS.Mode = SMTPTLSImplicit
+	sc.TLS.CAFile = caFile
+	assert.Error(t, send(sc))
+
+	sc.TLS.CertFile = certFile
+	sc.TLS.KeyFile = keyFile
+	require.NoError(t, send(sc))
+	assert.Contains(t, <-s.received, "plain body")
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	// A server that accepts connections but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			time.Sleep(time.Second)
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	sc := config.SMTPConfig{Host: "127.0.0.1", Port: p, Timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	assert.Error(t, send(sc))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestSMTPConfigErrors(t *testing.T) {
+	for _, tc := range []config.SMTPTLSConfig{
+		{Mode: "ssl"},
+		{CAFile: "missing.pem"},
+		{CertFile: "client.pem"},
+		{CertFile: "missing.pem", KeyFile: "missing.key"},
+	} {
+		_, err := NewSMTPTransport(config.SMTPConfig{Host: "127.0.0.1", Port: 25, TLS: tc})
+		assert.Error(t, err, "%+v", tc)
+	}
+}
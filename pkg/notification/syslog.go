@@ -0,0 +1,372 @@
+package notification
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+)
+
+// SyslogSDID is the RFC 5424 structured data ID digger events are sent
+// under. 32473 is the private enterprise number reserved for examples.
+const SyslogSDID = "digger@32473"
+
+const (
+	SyslogFormatRFC5424 = "rfc5424"
+	SyslogFormatCEF     = "cef"
+	SyslogFormatLEEF    = "leef"
+)
+
+const (
+	siemVendor  = "bytetwiddler"
+	siemProduct = "digger"
+	siemVersion = "1.0"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogNotifier sends change, lookup failure and reachability events to a
+// syslog receiver as RFC 5424 messages. TCP and TLS use octet counted
+// framing (RFC 6587).
+type SyslogNotifier struct {
+	cfg      config.SyslogConfig
+	facility int
+	hostname string
+	tls      *tls.Config
+	retry    RetryPolicy
+}
+
+// NewSyslogNotifier validates the log.syslog settings.
+func NewSyslogNotifier(sc config.SyslogConfig) (*SyslogNotifier, error) {
+	n := &SyslogNotifier{
+		cfg:   sc,
+		retry: NewRetryPolicy(sc.Retry),
+	}
+
+	if _, _, err := net.SplitHostPort(sc.Address); err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", sc.Address, err)
+	}
+
+	switch n.cfg.Network {
+	case "":
+		n.cfg.Network = "udp"
+	case "udp", "tcp":
+	case "tls":
+		n.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+		if sc.CAFile != "" {
+			pool, err := loadCertPool(sc.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid syslog tls settings: %w", err)
+			}
+			n.tls.RootCAs = pool
+		}
+	default:
+		return nil, fmt.Errorf("invalid syslog network %q, expected udp, tcp or tls", sc.Network)
+	}
+
+	switch n.cfg.Format {
+	case "":
+		n.cfg.Format = SyslogFormatRFC5424
+	case SyslogFormatRFC5424, SyslogFormatCEF, SyslogFormatLEEF:
+	default:
+		return nil, fmt.Errorf("invalid syslog format %q, expected rfc5424, cef or leef", sc.Format)
+	}
+
+	facility := sc.Facility
+	if facility == "" {
+		facility = "local0"
+	}
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %q", sc.Facility)
+	}
+	n.facility = f
+
+	if n.cfg.AppName == "" {
+		n.cfg.AppName = "digger"
+	}
+	if n.cfg.Timeout <= 0 {
+		n.cfg.Timeout = 10 * time.Second
+	}
+
+	n.hostname = sc.Hostname
+	if n.hostname == "" {
+		n.hostname, _ = os.Hostname()
+	}
+	if n.hostname == "" {
+		n.hostname = "-"
+	}
+
+	return n, nil
+}
+
+func (n *SyslogNotifier) Name() string {
+	return "syslog"
+}
+
+func (n *SyslogNotifier) RetryPolicy() RetryPolicy {
+	return n.retry
+}
+
+// Subscribes takes every kind of event.
+func (n *SyslogNotifier) Subscribes(event.Kind) bool {
+	return true
+}
+
+func (n *SyslogNotifier) Notify(ctx context.Context, ev *event.ChangeEvent) error {
+	msg := n.Format(ev)
+
+	dialer := &net.Dialer{Timeout: n.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if n.tls != nil {
+		td := &tls.Dialer{NetDialer: dialer, Config: n.tls}
+		conn, err = td.DialContext(ctx, "tcp", n.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, n.cfg.Network, n.cfg.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog %s: %w", n.cfg.Address, err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(n.cfg.Timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if n.cfg.Network != "udp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	_, err = conn.Write([]byte(msg))
+	if err != nil {
+		return fmt.Errorf("failed to write to syslog %s: %w", n.cfg.Address, err)
+	}
+
+	return nil
+}
+
+func (n *SyslogNotifier) Preview(ev *event.ChangeEvent) (Preview, error) {
+	return Preview{Subject: Title(ev), Target: n.cfg.Network + "://" + n.cfg.Address, Body: n.Format(ev)}, nil
+}
+
+// Format renders ev as an RFC 5424 message. The MSG part is plain text, or
+// a CEF or LEEF record depending on the configured format.
+func (n *SyslogNotifier) Format(ev *event.ChangeEvent) string {
+	t := ev.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	var msg string
+	switch n.cfg.Format {
+	case SyslogFormatCEF:
+		msg = CEF(ev)
+	case SyslogFormatLEEF:
+		msg = LEEF(ev)
+	default:
+		msg = Title(ev)
+		if ev.Kind == event.KindIPChanged {
+			msg += fmt.Sprintf(": %s -> %s", strings.Join(ev.OldIPs, ","), strings.Join(ev.NewIPs, ","))
+		}
+		if ev.Error != "" {
+			msg += ": " + ev.Error
+		}
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		n.facility*8+syslogSeverity(ev.Severity),
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(n.hostname, 255),
+		headerField(n.cfg.AppName, 48),
+		os.Getpid(),
+		headerField(string(ev.Kind), 32),
+		structuredData(ev),
+		msg)
+}
+
+func syslogSeverity(s event.Severity) int {
+	switch s {
+	case event.SeverityCritical:
+		return 2
+	case event.SeverityWarning:
+		return 4
+	}
+	return 6
+}
+
+// headerField makes s a valid RFC 5424 header field: printable ASCII
+// without spaces, at most max characters, "-" when empty.
+func headerField(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > 32 && s[i] < 127 {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+func structuredData(ev *event.ChangeEvent) string {
+	params := []struct{ name, value string }{
+		{"event_id", ev.ID},
+		{"kind", string(ev.Kind)},
+		{"hostname", ev.Hostname},
+		{"port", strconv.Itoa(ev.Port)},
+		{"entity", ev.EntityName},
+		{"old_ips", strings.Join(ev.OldIPs, ",")},
+		{"new_ips", strings.Join(ev.NewIPs, ",")},
+		{"resolver", ev.Resolver},
+		{"run_id", ev.RunID},
+		{"severity", string(ev.Severity)},
+		{"error", ev.Error},
+	}
+
+	var b strings.Builder
+	b.WriteString("[" + SyslogSDID)
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		b.WriteString(" " + p.name + `="` + sdEscaper.Replace(p.value) + `"`)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// CEF renders ev as an ArcSight Common Event Format record.
+func CEF(ev *event.ChangeEvent) string {
+	ext := []struct{ key, value string }{
+		{"rt", strconv.FormatInt(eventTime(ev).UnixMilli(), 10)},
+		{"dhost", ev.Hostname},
+		{"dpt", portString(ev.Port)},
+		{"dst", first(ev.NewIPs)},
+		{"externalId", ev.ID},
+		{"cs1Label", "oldIPs"},
+		{"cs1", strings.Join(ev.OldIPs, ",")},
+		{"cs2Label", "newIPs"},
+		{"cs2", strings.Join(ev.NewIPs, ",")},
+		{"cs3Label", "entity"},
+		{"cs3", ev.EntityName},
+		{"cs4Label", "runId"},
+		{"cs4", ev.RunID},
+		{"msg", ev.Error},
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(siemVendor),
+		cefHeaderEscaper.Replace(siemProduct),
+		cefHeaderEscaper.Replace(siemVersion),
+		cefHeaderEscaper.Replace(string(ev.Kind)),
+		cefHeaderEscaper.Replace(Title(ev)),
+		cefSeverity(ev.Severity))
+
+	sep := ""
+	for _, e := range ext {
+		if e.value == "" {
+			continue
+		}
+		b.WriteString(sep + e.key + "=" + cefValueEscaper.Replace(e.value))
+		sep = " "
+	}
+
+	return b.String()
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefSeverity(s event.Severity) int {
+	switch s {
+	case event.SeverityCritical:
+		return 9
+	case event.SeverityWarning:
+		return 6
+	}
+	return 3
+}
+
+// LEEF renders ev as an IBM QRadar Log Event Extended Format 1.0 record
+// with tab separated attributes.
+func LEEF(ev *event.ChangeEvent) string {
+	attrs := []struct{ key, value string }{
+		{"devTime", strconv.FormatInt(eventTime(ev).UnixMilli(), 10)},
+		{"cat", string(ev.Kind)},
+		{"sev", strconv.Itoa(cefSeverity(ev.Severity))},
+		{"dstHost", ev.Hostname},
+		{"dstPort", portString(ev.Port)},
+		{"dst", first(ev.NewIPs)},
+		{"oldIPs", strings.Join(ev.OldIPs, ",")},
+		{"newIPs", strings.Join(ev.NewIPs, ",")},
+		{"entity", ev.EntityName},
+		{"eventId", ev.ID},
+		{"runId", ev.RunID},
+		{"reason", ev.Error},
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "LEEF:1.0|%s|%s|%s|%s|",
+		leefHeaderEscaper.Replace(siemVendor),
+		leefHeaderEscaper.Replace(siemProduct),
+		leefHeaderEscaper.Replace(siemVersion),
+		leefHeaderEscaper.Replace(string(ev.Kind)))
+
+	sep := ""
+	for _, a := range attrs {
+		if a.value == "" {
+			continue
+		}
+		b.WriteString(sep + a.key + "=" + leefValueEscaper.Replace(a.value))
+		sep = "\t"
+	}
+
+	return b.String()
+}
+
+var (
+	leefHeaderEscaper = strings.NewReplacer(`|`, " ", "\t", " ", "\n", " ", "\r", " ")
+	leefValueEscaper  = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+)
+
+func eventTime(ev *event.ChangeEvent) time.Time {
+	if ev.Time.IsZero() {
+		return time.Now()
+	}
+	return ev.Time
+}
+
+func portString(port int) string {
+	if port == 0 {
+		return ""
+	}
+	return strconv.Itoa(port)
+}
+
+func first(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
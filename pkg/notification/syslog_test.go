@@ -0,0 +1,184 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func syslogEvent() *event.ChangeEvent {
+	return &event.ChangeEvent{
+		ID:         "0001",
+		Hostname:   "sftp.vendor.com",
+		Port:       22,
+		EntityName: `Vendor "A" [EU]`,
+		Kind:       event.KindIPChanged,
+		OldIPs:     []string{"1.1.1.1"},
+		NewIPs:     []string{"2.2.2.2"},
+		RunID:      "run1",
+		Severity:   event.SeverityCritical,
+		Time:       time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestSyslogRFC5424(t *testing.T) {
+	n, err := NewSyslogNotifier(config.SyslogConfig{Address: "127.0.0.1:514", Hostname: "monitor01"})
+	require.NoError(t, err)
+
+	msg := n.Format(syslogEvent())
+	assert.True(t, strings.HasPrefix(msg, "<130>1 2024-03-01T10:00:00.000000Z monitor01 digger "), msg)
+	assert.Contains(t, msg, " ip_changed [digger@32473 event_id=\"0001\" kind=\"ip_changed\" hostname=\"sftp.vendor.com\" port=\"22\"")
+	assert.Contains(t, msg, `entity="Vendor \"A\" [EU\]"`)
+	assert.True(t, strings.HasSuffix(msg, "] IP address change for sftp.vendor.com: 1.1.1.1 -> 2.2.2.2"), msg)
+}
+
+func TestSyslogCEFAndLEEF(t *testing.T) {
+	ev := syslogEvent()
+	ev.EntityName = "a=b|c"
+
+	cef := CEF(ev)
+	assert.True(t, strings.HasPrefix(cef, "CEF:0|bytetwiddler|digger|1.0|ip_changed|IP address change for sftp.vendor.com|9|rt=1709287200000 "), cef)
+	assert.Contains(t, cef, "dhost=sftp.vendor.com dpt=22 dst=2.2.2.2")
+	assert.Contains(t, cef, `cs3=a\=b|c`)
+
+	leef := LEEF(ev)
+	assert.True(t, strings.HasPrefix(leef, "LEEF:1.0|bytetwiddler|digger|1.0|ip_changed|devTime=1709287200000\t"), leef)
+	assert.Contains(t, leef, "\tdstHost=sftp.vendor.com\tdstPort=22\t")
+	assert.Contains(t, leef, "\tentity=a=b|c\t")
+
+	n, err := NewSyslogNotifier(config.SyslogConfig{Address: "127.0.0.1:514", Format: "cef", Facility: "auth"})
+	require.NoError(t, err)
+	msg := n.Format(ev)
+	assert.True(t, strings.HasPrefix(msg, "<34>1 "), msg)
+	assert.True(t, strings.HasSuffix(msg, "] "+cef), msg)
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	n, err := NewSyslogNotifier(config.SyslogConfig{Address: pc.LocalAddr().String(), Format: "leef"})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "example.com", Kind: event.KindLookupFailed, Error: "no such host", Severity: event.SeverityWarning}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+	require.Equal(t, event.StatusSent, ev.NotificationStatus)
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	size, _, err := pc.ReadFrom(buf)
+	require.NoError(t, err)
+
+	msg := string(buf[:size])
+	assert.True(t, strings.HasPrefix(msg, "<132>1 "), msg)
+	assert.Contains(t, msg, "LEEF:1.0|bytetwiddler|digger|1.0|lookup_failed|")
+	assert.Contains(t, msg, "reason=no such host")
+}
+
+// readFramed reads one octet counted message from conn.
+func readFramed(t *testing.T, conn net.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	prefix, err := r.ReadString(' ')
+	require.NoError(t, err)
+	size, err := strconv.Atoi(strings.TrimSpace(prefix))
+	require.NoError(t, err)
+
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received <- readFramed(t, conn)
+	}()
+
+	n, err := NewSyslogNotifier(config.SyslogConfig{Address: l.Addr().String(), Network: "tcp"})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), syslogEvent()))
+
+	msg := <-received
+	assert.True(t, strings.HasPrefix(msg, "<130>1 "), msg)
+	assert.Contains(t, msg, "IP address change for sftp.vendor.com")
+}
+
+func TestSyslogTLS(t *testing.T) {
+	// Borrow httptest's certificate, which is valid for 127.0.0.1.
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	ts.Close()
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	require.NoError(t, err)
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		received <- readFramed(t, conn)
+	}()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+
+	n, err := NewSyslogNotifier(config.SyslogConfig{Address: l.Addr().String(), Network: "tls", CAFile: caFile})
+	require.NoError(t, err)
+	require.NoError(t, n.Notify(context.Background(), syslogEvent()))
+
+	assert.Contains(t, <-received, "[digger@32473 ")
+}
+
+func TestSyslogConfigErrors(t *testing.T) {
+	for _, sc := range []config.SyslogConfig{
+		{Address: "no-port"},
+		{Address: "127.0.0.1:514", Network: "sctp"},
+		{Address: "127.0.0.1:514", Format: "json"},
+		{Address: "127.0.0.1:514", Facility: "local9"},
+		{Address: "127.0.0.1:514", Network: "tls", CAFile: "missing.pem"},
+	} {
+		_, err := NewSyslogNotifier(sc)
+		assert.Error(t, err, "%+v", sc)
+	}
+}
+
+func TestBuildAddsSyslog(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Log.Syslog.Address = "127.0.0.1:514"
+
+	notifiers, err := Build(cfg, Env{})
+	require.NoError(t, err)
+	require.Len(t, notifiers, 2)
+	assert.Equal(t, "syslog", notifiers[1].Name())
+}
@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/templates"
+)
+
+// templateFuncs are available to every notification template.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+	"formatTime": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+	"title":         Title,
+	"severityColor": severityColor,
+	"teamsColor":    teamsColor,
+}
+
+// Title is the one line summary used as a subject or heading for ev.
+func Title(ev *event.ChangeEvent) string {
+	switch ev.Kind {
+	case event.KindIPChanged:
+		return fmt.Sprintf("IP address change for %s", ev.Hostname)
+	case event.KindLookupFailed:
+		return fmt.Sprintf("DNS lookup failed for %s", ev.Hostname)
+	case event.KindUnreachable:
+		return fmt.Sprintf("%s is unreachable on port %d", ev.Hostname, ev.Port)
+	}
+	return fmt.Sprintf("%s for %s", strings.ReplaceAll(string(ev.Kind), "_", " "), ev.Hostname)
+}
+
+// loadTemplate parses the template at path. Without a path it uses name
+// from the templates directory, falling back to the embedded copy.
+func loadTemplate(path, name string, funcs template.FuncMap) (*template.Template, error) {
+	var content []byte
+	var err error
+	switch {
+	case path != "":
+		content, err = os.ReadFile(path)
+	default:
+		content, err = os.ReadFile(filepath.Join("templates", name))
+		if errors.Is(err, os.ErrNotExist) {
+			content, err = templates.FS.ReadFile(name)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", name, err)
+	}
+
+	t, err := template.New(name).Funcs(funcs).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+
+	return t, nil
+}
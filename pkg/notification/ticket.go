@@ -0,0 +1,369 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTicketSummary     = `{{title .Event}}`
+	defaultTicketDescription = `The IP address of {{.Event.Hostname}} port {{.Event.Port}} ({{.Event.EntityName}}) changed.
+
+Old IPs: {{join .Event.OldIPs ", "}}
+New IPs: {{join .Event.NewIPs ", "}}
+Detected: {{formatTime .Event.Time}}
+Severity: {{.Event.Severity}}
+Event: {{.Event.ID}}
+
+Please update the firewall rules for this site.`
+	defaultTicketComment = `The IP address of {{.Event.Hostname}} changed again at {{formatTime .Event.Time}}: {{join .Event.OldIPs ", "}} -> {{join .Event.NewIPs ", "}} (event {{.Event.ID}}).`
+)
+
+// tracker is the part of a ticketing system a TicketNotifier talks to.
+type tracker interface {
+	// Create opens a ticket and returns its key.
+	Create(ctx context.Context, t ticket) (string, error)
+	// IsOpen reports whether the ticket with key is still being worked on.
+	IsOpen(ctx context.Context, key string) (bool, error)
+	// Comment adds text to an existing ticket.
+	Comment(ctx context.Context, key, text string) error
+}
+
+// ticket is a rendered ticket ready to be sent to a tracker.
+type ticket struct {
+	Summary     string
+	Description string
+	Fields      map[string]interface{}
+}
+
+// TicketNotifier raises a ticket for each change event. A change for a site
+// whose previous ticket is still open is added to that ticket as a comment.
+type TicketNotifier struct {
+	name        string
+	tracker     tracker
+	lastTicket  func(backend, siteID string) (string, error)
+	summary     *template.Template
+	description *template.Template
+	comment     *template.Template
+	fields      map[string]interface{}
+	retry       RetryPolicy
+}
+
+func newTicketNotifier(nc config.NotifierConfig, env Env, tr tracker) (*TicketNotifier, error) {
+	tc := nc.Ticket
+
+	n := &TicketNotifier{
+		name:       nc.Name,
+		tracker:    tr,
+		lastTicket: env.LastTicket,
+		fields:     normalizeFields(tc.Fields).(map[string]interface{}),
+		retry:      NewRetryPolicy(tc.Retry),
+	}
+
+	var err error
+	n.summary, err = parseTicketTemplate("summary", tc.Summary, defaultTicketSummary)
+	if err != nil {
+		return nil, err
+	}
+	n.description, err = parseTicketTemplate("description", tc.Description, defaultTicketDescription)
+	if err != nil {
+		return nil, err
+	}
+	n.comment, err = parseTicketTemplate("comment", tc.Comment, defaultTicketComment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse field templates up front so mistakes show up at startup.
+	_, err = renderFields(n.fields, &event.ChangeEvent{})
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (n *TicketNotifier) Name() string {
+	return n.name
+}
+
+func (n *TicketNotifier) RetryPolicy() RetryPolicy {
+	return n.retry
+}
+
+func (n *TicketNotifier) Notify(ctx context.Context, ev *event.ChangeEvent) error {
+	_, err := n.RaiseTicket(ctx, ev)
+	return err
+}
+
+// RaiseTicket comments on the site's open ticket if there is one and opens
+// a new ticket otherwise. It returns the key of the ticket used.
+func (n *TicketNotifier) RaiseTicket(ctx context.Context, ev *event.ChangeEvent) (string, error) {
+	if n.lastTicket != nil {
+		key, err := n.lastTicket(n.name, ev.SiteID)
+		if err != nil {
+			logrus.Warnf("Failed to look up previous %s ticket for %s: %v", n.name, ev.Hostname, err)
+		}
+
+		if key != "" {
+			open, err := n.tracker.IsOpen(ctx, key)
+			if err != nil {
+				return "", fmt.Errorf("failed to check ticket %s: %w", key, err)
+			}
+
+			if open {
+				text, err := execute(n.comment, ev)
+				if err != nil {
+					return "", Permanent(err)
+				}
+
+				err = n.tracker.Comment(ctx, key, text)
+				if err != nil {
+					return "", fmt.Errorf("failed to comment on ticket %s: %w", key, err)
+				}
+
+				logrus.Infof("Added change for %s to open ticket %s", ev.Hostname, key)
+				return key, nil
+			}
+		}
+	}
+
+	t, err := n.render(ev)
+	if err != nil {
+		return "", Permanent(err)
+	}
+
+	key, err := n.tracker.Create(ctx, t)
+	if err != nil {
+		return "", fmt.Errorf("failed to create ticket: %w", err)
+	}
+
+	logrus.Infof("Opened ticket %s for %s", key, ev.Hostname)
+	return key, nil
+}
+
+// Preview shows the ticket that would be opened. Whether the change would
+// instead be added to an open ticket is not checked, as that needs the
+// tracker.
+func (n *TicketNotifier) Preview(ev *event.ChangeEvent) (Preview, error) {
+	t, err := n.render(ev)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	body := t.Description
+	if len(t.Fields) > 0 {
+		fields, err := json.MarshalIndent(t.Fields, "", "  ")
+		if err != nil {
+			return Preview{}, fmt.Errorf("failed to marshal ticket fields: %w", err)
+		}
+		body += "\n\nFields: " + string(fields)
+	}
+
+	return Preview{Subject: t.Summary, Body: body}, nil
+}
+
+func (n *TicketNotifier) render(ev *event.ChangeEvent) (ticket, error) {
+	var t ticket
+	var err error
+
+	t.Summary, err = execute(n.summary, ev)
+	if err != nil {
+		return t, err
+	}
+	t.Description, err = execute(n.description, ev)
+	if err != nil {
+		return t, err
+	}
+	fields, err := renderFields(n.fields, ev)
+	if err != nil {
+		return t, err
+	}
+	t.Fields = fields.(map[string]interface{})
+
+	return t, nil
+}
+
+func parseTicketTemplate(name, text, fallback string) (*template.Template, error) {
+	if text == "" {
+		text = fallback
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s template: %w", name, err)
+	}
+
+	return t, nil
+}
+
+func execute(t *template.Template, ev *event.ChangeEvent) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, ChatData{Event: ev})
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", t.Name(), err)
+	}
+
+	return buf.String(), nil
+}
+
+// normalizeFields converts the map[interface{}]interface{} values the YAML
+// decoder produces into something encoding/json can marshal.
+func normalizeFields(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = normalizeFields(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = normalizeFields(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			s[i] = normalizeFields(val)
+		}
+		return s
+	}
+	return v
+}
+
+// renderFields returns a copy of v with every string rendered as a template.
+func renderFields(v interface{}, ev *event.ChangeEvent) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			r, err := renderFields(val, ev)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", k, err)
+			}
+			m[k] = r
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, val := range v {
+			r, err := renderFields(val, ev)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = r
+		}
+		return s, nil
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		t, err := template.New("field").Funcs(templateFuncs).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+		return execute(t, ev)
+	}
+	return v, nil
+}
+
+// ticketClient makes authenticated JSON requests against a ticketing API.
+// With a username it uses basic auth, otherwise the token is sent as a
+// bearer token.
+type ticketClient struct {
+	base     *url.URL
+	username string
+	token    string
+	client   *http.Client
+}
+
+func newTicketClient(tc *config.TicketConfig) (*ticketClient, error) {
+	u, err := url.Parse(tc.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", tc.URL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	timeout := tc.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &ticketClient{
+		base:     u,
+		username: tc.Username,
+		token:    tc.Token,
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// do sends in as JSON to path and decodes the response into out when it is
+// not nil.
+func (c *ticketClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return Permanent(fmt.Errorf("failed to marshal request: %w", err))
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "digger")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	switch {
+	case c.username != "":
+		req.SetBasicAuth(c.username, c.token)
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", method, req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	err = checkResponse(req, resp)
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("failed to decode response from %s: %w", req.URL.Redacted(), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+)
+
+func init() {
+	Register("webhook", newWebhookNotifier)
+}
+
+// WebhookPayloadVersion is bumped whenever the payload layout changes in a
+// way receivers need to know about.
+const WebhookPayloadVersion = 1
+
+// DefaultSignatureHeader carries "sha256=<hex HMAC-SHA256 of the body>"
+// when a webhook secret is configured.
+const DefaultSignatureHeader = "X-Digger-Signature"
+
+// WebhookPayload is the JSON document POSTed for each change event:
+//
+//	{
+//	  "version": 1,
+//	  "type": "ip_changed",
+//	  "sent_at": "2025-01-02T03:04:05Z",
+//	  "event": { "id": "...", "hostname": "...", "old_ips": [...], "new_ips": [...], ... }
+//	}
+type WebhookPayload struct {
+	Version int                `json:"version"`
+	Type    event.Kind         `json:"type"`
+	SentAt  time.Time          `json:"sent_at"`
+	Event   *event.ChangeEvent `json:"event"`
+}
+
+// WebhookNotifier POSTs change events as JSON to a URL.
+type WebhookNotifier struct {
+	name   string
+	cfg    config.WebhookConfig
+	client *http.Client
+	retry  RetryPolicy
+}
+
+func newWebhookNotifier(nc config.NotifierConfig, env Env) (Notifier, error) {
+	if nc.Webhook == nil {
+		return nil, errors.New("webhook settings missing")
+	}
+
+	u, err := url.Parse(nc.Webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q", nc.Webhook.URL)
+	}
+
+	timeout := nc.Webhook.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &WebhookNotifier{
+		name:   nc.Name,
+		cfg:    *nc.Webhook,
+		client: &http.Client{Timeout: timeout},
+		retry:  NewRetryPolicy(nc.Webhook.Retry),
+	}, nil
+}
+
+func (n *WebhookNotifier) Name() string {
+	return n.name
+}
+
+func (n *WebhookNotifier) RetryPolicy() RetryPolicy {
+	return n.retry
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, ev *event.ChangeEvent) error {
+	body, err := json.Marshal(WebhookPayload{
+		Version: WebhookPayloadVersion,
+		Type:    ev.Kind,
+		SentAt:  time.Now().UTC(),
+		Event:   ev,
+	})
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to marshal webhook payload: %w", err))
+	}
+
+	return postJSON(ctx, n.client, n.cfg.URL, n.cfg.Headers, n.cfg.Secret, n.signatureHeader(), body)
+}
+
+func (n *WebhookNotifier) Preview(ev *event.ChangeEvent) (Preview, error) {
+	body, err := previewPayload(ev)
+	if err != nil {
+		return Preview{}, err
+	}
+	return Preview{Subject: Title(ev), Target: "POST " + endpoint(n.cfg.URL), Body: body}, nil
+}
+
+func (n *WebhookNotifier) signatureHeader() string {
+	if n.cfg.SignatureHeader != "" {
+		return n.cfg.SignatureHeader
+	}
+	return DefaultSignatureHeader
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postJSON POSTs body and treats any 2xx response as success.
+func postJSON(ctx context.Context, client *http.Client, target string, headers map[string]string, secret, signatureHeader string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "digger")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if secret != "" {
+		req.Header.Set(signatureHeader, Sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %w", req.URL.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	err = checkResponse(req, resp)
+	if err != nil {
+		return err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// checkResponse turns a non-2xx response into an error. Client errors other
+// than 408 and 429 are permanent.
+func checkResponse(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("%s returned %s: %s", req.URL.Redacted(), resp.Status, bytes.TrimSpace(snippet))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+
+	return err
+}
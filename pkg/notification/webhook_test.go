@@ -0,0 +1,146 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookConfig(url string) config.NotifierConfig {
+	return config.NotifierConfig{
+		Type: "webhook",
+		Name: "automation",
+		Webhook: &config.WebhookConfig{
+			URL:     url,
+			Headers: map[string]string{"X-Team": "network"},
+			Secret:  "s3cret",
+			Retry: config.RetryConfig{
+				MaxAttempts:    3,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     5 * time.Millisecond,
+			},
+		},
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var payload WebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "network", r.Header.Get("X-Team"))
+		assert.Equal(t, Sign("s3cret", body), r.Header.Get(DefaultSignatureHeader))
+
+		require.NoError(t, json.Unmarshal(body, &payload))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	n, err := newWebhookNotifier(webhookConfig(server.URL), Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{ID: "abc", Hostname: "example.com", Kind: event.KindIPChanged, NewIPs: []string{"2.2.2.2"}}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	require.Len(t, ev.Deliveries, 1)
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status)
+	assert.Equal(t, 1, ev.Deliveries[0].Attempts)
+
+	assert.Equal(t, WebhookPayloadVersion, payload.Version)
+	assert.Equal(t, event.KindIPChanged, payload.Type)
+	assert.Equal(t, "abc", payload.Event.ID)
+	assert.Equal(t, []string{"2.2.2.2"}, payload.Event.NewIPs)
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	n, err := newWebhookNotifier(webhookConfig(server.URL), Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "example.com"}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, event.StatusSent, ev.Deliveries[0].Status)
+	assert.Equal(t, 3, ev.Deliveries[0].Attempts)
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	n, err := newWebhookNotifier(webhookConfig(server.URL), Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "example.com"}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, event.StatusFailed, ev.Deliveries[0].Status)
+	assert.Equal(t, 3, ev.Deliveries[0].Attempts)
+	assert.Contains(t, ev.Deliveries[0].Error, "503")
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "bad payload", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	n, err := newWebhookNotifier(webhookConfig(server.URL), Env{})
+	require.NoError(t, err)
+
+	ev := &event.ChangeEvent{Hostname: "example.com"}
+	NewDispatcher([]Notifier{n}).Dispatch(context.Background(), ev)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, 1, ev.Deliveries[0].Attempts)
+	assert.Contains(t, ev.Deliveries[0].Error, "bad payload")
+}
+
+func TestWebhookConfigErrors(t *testing.T) {
+	_, err := newWebhookNotifier(config.NotifierConfig{Type: "webhook"}, Env{})
+	assert.Error(t, err)
+
+	_, err = newWebhookNotifier(webhookConfig("ftp://example.com"), Env{})
+	assert.Error(t, err)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := NewRetryPolicy(config.RetryConfig{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	assert.Equal(t, time.Second, p.Backoff(1))
+	assert.Equal(t, 2*time.Second, p.Backoff(2))
+	assert.Equal(t, 4*time.Second, p.Backoff(3))
+	assert.Equal(t, 5*time.Second, p.Backoff(4))
+
+	assert.Equal(t, 1, NewRetryPolicy(config.RetryConfig{}).MaxAttempts)
+}
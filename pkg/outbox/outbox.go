@@ -0,0 +1,254 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// Policy controls how long and how often undelivered notifications are
+// retried.
+type Policy struct {
+	MaxAge  time.Duration
+	Backoff notification.RetryPolicy
+}
+
+var DefaultPolicy = Policy{
+	MaxAge: 72 * time.Hour,
+	Backoff: notification.RetryPolicy{
+		InitialBackoff: 5 * time.Minute,
+		MaxBackoff:     6 * time.Hour,
+	},
+}
+
+// NewPolicy fills in defaults for unset outbox settings.
+func NewPolicy(cfg *config.Config) Policy {
+	p := DefaultPolicy
+	if cfg.Outbox.MaxAge > 0 {
+		p.MaxAge = cfg.Outbox.MaxAge
+	}
+	if cfg.Outbox.InitialBackoff > 0 {
+		p.Backoff.InitialBackoff = cfg.Outbox.InitialBackoff
+	}
+	if cfg.Outbox.MaxBackoff > 0 {
+		p.Backoff.MaxBackoff = cfg.Outbox.MaxBackoff
+	}
+	return p
+}
+
+// Enqueue adds an entry per backend for ev. Call it in the transaction that
+// stores ev so a crash or failed delivery cannot lose the notification.
+func Enqueue(tx *bbolt.Tx, ev *event.ChangeEvent, backends []notification.Notifier, now time.Time) error {
+	for _, n := range backends {
+		err := store.PutOutbox(tx, store.OutboxEntry{
+			EventID:     ev.ID,
+			Backend:     n.Name(),
+			Hostname:    ev.Hostname,
+			Created:     now,
+			NextAttempt: now,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to queue %s notification: %w", n.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// Settle records the outcome of delivering an event. Backends that accepted
+// it leave the outbox, the rest are scheduled for another attempt.
+func Settle(tx *bbolt.Tx, eventID string, deliveries []event.Delivery, p Policy, now time.Time) error {
+	for _, d := range deliveries {
+		key := store.OutboxEntry{EventID: eventID, Backend: d.Backend}.Key()
+
+		if d.Status == event.StatusSent {
+			err := store.DeleteOutbox(tx, key)
+			if err != nil {
+				return fmt.Errorf("failed to remove %s outbox entry: %w", d.Backend, err)
+			}
+			continue
+		}
+
+		e, err := store.GetOutbox(tx, key)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			continue
+		}
+
+		e.Attempts += d.Attempts
+		e.LastError = d.Error
+		e.NextAttempt = now.Add(p.Backoff.Backoff(e.Attempts))
+		if now.Sub(e.Created) >= p.MaxAge {
+			e.Dead = true
+			logrus.Errorf("Giving up on %s notification for %s after %d attempts: %s", e.Backend, e.Hostname, e.Attempts, e.LastError)
+		}
+
+		err = store.PutOutbox(tx, *e)
+		if err != nil {
+			return fmt.Errorf("failed to reschedule %s notification: %w", d.Backend, err)
+		}
+	}
+
+	return nil
+}
+
+// Deliver retries every outbox entry that is due and returns how many were
+// delivered and how many are still outstanding.
+func Deliver(ctx context.Context, db *bbolt.DB, d *notification.Dispatcher, p Policy, now time.Time) (int, int, error) {
+	entries, err := store.ListOutbox(db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	delivered, pending := 0, 0
+	for _, e := range entries {
+		if e.Dead {
+			continue
+		}
+		if e.NextAttempt.After(now) {
+			pending++
+			continue
+		}
+
+		n := d.Lookup(e.Backend)
+		if n == nil {
+			logrus.Warnf("Outbox entry %s is for backend %s, which is no longer configured", e.Key(), e.Backend)
+			pending++
+			continue
+		}
+
+		var ev *event.ChangeEvent
+		err = db.View(func(tx *bbolt.Tx) error {
+			ev, err = store.GetEvent(tx, e.EventID)
+			return err
+		})
+		if err != nil {
+			logrus.Errorf("Failed to load change event for outbox entry %s: %v", e.Key(), err)
+			pending++
+			continue
+		}
+
+		delivery := d.Deliver(ctx, n, ev)
+		merge(ev, delivery)
+
+		err = db.Update(func(tx *bbolt.Tx) error {
+			err := store.UpdateEvent(tx, ev)
+			if err != nil {
+				return err
+			}
+			return Settle(tx, ev.ID, []event.Delivery{delivery}, p, now)
+		})
+		if err != nil {
+			return delivered, pending, fmt.Errorf("failed to record outbox delivery: %w", err)
+		}
+
+		if delivery.Status == event.StatusSent {
+			delivered++
+		} else {
+			pending++
+		}
+	}
+
+	return delivered, pending, nil
+}
+
+// NextAttempt returns when the earliest entry that is still being retried
+// is due, or the zero time when none are.
+func NextAttempt(db *bbolt.DB) (time.Time, error) {
+	entries, err := store.ListOutbox(db)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var next time.Time
+	for _, e := range entries {
+		if e.Dead {
+			continue
+		}
+		if next.IsZero() || e.NextAttempt.Before(next) {
+			next = e.NextAttempt
+		}
+	}
+
+	return next, nil
+}
+
+// merge replaces the event's delivery record for the backend with the
+// latest outcome, carrying over earlier attempts.
+func merge(ev *event.ChangeEvent, d event.Delivery) {
+	for i, old := range ev.Deliveries {
+		if old.Backend == d.Backend {
+			d.Attempts += old.Attempts
+			ev.Deliveries[i] = d
+			ev.NotificationStatus = notification.Overall(ev.Deliveries)
+			return
+		}
+	}
+
+	ev.Deliveries = append(ev.Deliveries, d)
+	ev.NotificationStatus = notification.Overall(ev.Deliveries)
+}
+
+// Retry makes matching entries due now and revives dead ones, restarting
+// their max age. An empty id matches every entry.
+func Retry(db *bbolt.DB, id string, now time.Time) (int, error) {
+	return update(db, id, func(tx *bbolt.Tx, e store.OutboxEntry) error {
+		e.Dead = false
+		e.Created = now
+		e.NextAttempt = now
+		return store.PutOutbox(tx, e)
+	})
+}
+
+// Purge drops matching entries. Only dead entries are dropped unless all is
+// set. An empty id matches every entry.
+func Purge(db *bbolt.DB, id string, all bool) (int, error) {
+	return update(db, id, func(tx *bbolt.Tx, e store.OutboxEntry) error {
+		if !e.Dead && !all {
+			return errSkip
+		}
+		return store.DeleteOutbox(tx, e.Key())
+	})
+}
+
+var errSkip = errors.New("skip")
+
+// update applies fn to every entry whose event ID or key matches id and
+// returns how many it changed.
+func update(db *bbolt.DB, id string, fn func(tx *bbolt.Tx, e store.OutboxEntry) error) (int, error) {
+	entries, err := store.ListOutbox(db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, e := range entries {
+			if id != "" && id != e.EventID && id != string(e.Key()) {
+				continue
+			}
+
+			err := fn(tx, e)
+			if err == errSkip {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
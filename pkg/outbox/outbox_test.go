@@ -0,0 +1,200 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+type flakyNotifier struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (f *flakyNotifier) Name() string {
+	return f.name
+}
+
+func (f *flakyNotifier) Notify(context.Context, *event.ChangeEvent) error {
+	f.calls++
+	return f.err
+}
+
+var testPolicy = Policy{
+	MaxAge:  time.Hour,
+	Backoff: notification.RetryPolicy{InitialBackoff: time.Minute, MaxBackoff: 10 * time.Minute},
+}
+
+// record stores ev with outbox entries for every notifier, dispatches it
+// and settles the result, as UpdateIPs does.
+func record(t *testing.T, db *bbolt.DB, d *notification.Dispatcher, ev *event.ChangeEvent, now time.Time) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		err := store.PutEvent(tx, ev)
+		if err != nil {
+			return err
+		}
+		return Enqueue(tx, ev, d.Subscribed(ev.Kind), now)
+	})
+	require.NoError(t, err)
+
+	d.Dispatch(context.Background(), ev)
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		err := store.UpdateEvent(tx, ev)
+		if err != nil {
+			return err
+		}
+		return Settle(tx, ev.ID, ev.Deliveries, testPolicy, now)
+	})
+	require.NoError(t, err)
+}
+
+func openDB(t *testing.T) *bbolt.DB {
+	db, err := store.Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestFailedDeliveryIsRetried(t *testing.T) {
+	db := openDB(t)
+	email := &flakyNotifier{name: "email", err: errors.New("relay down")}
+	chat := &flakyNotifier{name: "chat"}
+	d := notification.NewDispatcher([]notification.Notifier{email, chat})
+
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	ev := &event.ChangeEvent{SiteID: "example.com", Hostname: "example.com", Kind: event.KindIPChanged, Time: now}
+	record(t, db, d, ev, now)
+
+	entries, err := store.ListOutbox(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "email", entries[0].Backend)
+	assert.Equal(t, 1, entries[0].Attempts)
+	assert.Equal(t, "relay down", entries[0].LastError)
+	assert.Equal(t, now.Add(time.Minute), entries[0].NextAttempt)
+	next, err := NextAttempt(db)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Minute), next)
+
+	// Not due yet
+	delivered, pending, err := Deliver(context.Background(), db, d, testPolicy, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 1, email.calls)
+
+	// Still failing: backoff doubles
+	delivered, pending, err = Deliver(context.Background(), db, d, testPolicy, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 1, pending)
+	entries, _ = store.ListOutbox(db)
+	assert.Equal(t, 2, entries[0].Attempts)
+	assert.Equal(t, now.Add(3*time.Minute), entries[0].NextAttempt)
+
+	// The relay is back
+	email.err = nil
+	delivered, pending, err = Deliver(context.Background(), db, d, testPolicy, now.Add(3*time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 0, pending)
+
+	entries, _ = store.ListOutbox(db)
+	assert.Empty(t, entries)
+	next, err = NextAttempt(db)
+	require.NoError(t, err)
+	assert.True(t, next.IsZero())
+
+	db.View(func(tx *bbolt.Tx) error {
+		got, err := store.GetEvent(tx, ev.ID)
+		require.NoError(t, err)
+		assert.Equal(t, event.StatusSent, got.NotificationStatus)
+		require.Len(t, got.Deliveries, 2)
+		assert.Equal(t, "email", got.Deliveries[0].Backend)
+		assert.Equal(t, 3, got.Deliveries[0].Attempts)
+		return nil
+	})
+}
+
+func TestEntriesDieAfterMaxAge(t *testing.T) {
+	db := openDB(t)
+	email := &flakyNotifier{name: "email", err: errors.New("relay down")}
+	d := notification.NewDispatcher([]notification.Notifier{email})
+
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	ev := &event.ChangeEvent{SiteID: "example.com", Hostname: "example.com", Kind: event.KindIPChanged, Time: now}
+	record(t, db, d, ev, now)
+
+	_, _, err := Deliver(context.Background(), db, d, testPolicy, now.Add(2*time.Hour))
+	require.NoError(t, err)
+
+	entries, _ := store.ListOutbox(db)
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].Dead)
+	next, err := NextAttempt(db)
+	require.NoError(t, err)
+	assert.True(t, next.IsZero(), "dead entries are not retried")
+
+	// Dead entries are left alone
+	calls := email.calls
+	_, _, err = Deliver(context.Background(), db, d, testPolicy, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, calls, email.calls)
+
+	// Retry revives them
+	n, err := Retry(db, ev.ID, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	email.err = nil
+	delivered, _, err := Deliver(context.Background(), db, d, testPolicy, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+}
+
+func TestPurge(t *testing.T) {
+	db := openDB(t)
+	now := time.Now()
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		store.PutOutbox(tx, store.OutboxEntry{EventID: "a", Backend: "email", Dead: true})
+		store.PutOutbox(tx, store.OutboxEntry{EventID: "b", Backend: "email", NextAttempt: now})
+		return store.PutOutbox(tx, store.OutboxEntry{EventID: "c", Backend: "email", NextAttempt: now})
+	})
+	require.NoError(t, err)
+
+	n, err := Purge(db, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = Purge(db, "b/email", true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	entries, err := store.ListOutbox(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "c", entries[0].EventID)
+}
+
+func TestNewPolicy(t *testing.T) {
+	assert.Equal(t, DefaultPolicy, NewPolicy(&config.Config{}))
+
+	cfg := &config.Config{}
+	cfg.Outbox.MaxAge = 24 * time.Hour
+	p := NewPolicy(cfg)
+	assert.Equal(t, 24*time.Hour, p.MaxAge)
+	assert.Equal(t, DefaultPolicy.Backoff, p.Backoff)
+}
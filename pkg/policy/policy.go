@@ -0,0 +1,272 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// Actions a decision can take.
+const (
+	ActionSend     = "send"
+	ActionSuppress = "suppress"
+	ActionHold     = "hold"
+	ActionRelease  = "release"
+)
+
+// Retention is how long decisions are kept once they no longer matter for
+// rate limits or duplicate suppression.
+const Retention = 30 * 24 * time.Hour
+
+// Engine applies the notification policy from config.yaml. Its decisions
+// are based on earlier decisions stored in the database.
+type Engine struct {
+	cfg        config.PolicyConfig
+	quietStart time.Duration
+	quietEnd   time.Duration
+	quiet      bool
+}
+
+func New(cfg config.PolicyConfig) (*Engine, error) {
+	e := &Engine{cfg: cfg}
+
+	if cfg.QuietHours.Start != "" || cfg.QuietHours.End != "" {
+		var err error
+		e.quietStart, err = parseClock(cfg.QuietHours.Start)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet_hours.start: %w", err)
+		}
+		e.quietEnd, err = parseClock(cfg.QuietHours.End)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet_hours.end: %w", err)
+		}
+		e.quiet = e.quietStart != e.quietEnd
+	}
+
+	if (cfg.RateLimit.PerSite > 0 || cfg.RateLimit.Global > 0) && cfg.RateLimit.Window <= 0 {
+		return nil, fmt.Errorf("rate_limit.window must be set with a rate limit")
+	}
+
+	return e, nil
+}
+
+// parseClock parses "HH:MM" into an offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// InQuietHours reports whether now falls in the quiet hours, which may span
+// midnight.
+func (e *Engine) InQuietHours(now time.Time) bool {
+	if !e.quiet {
+		return false
+	}
+
+	y, m, d := now.Date()
+	offset := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	if e.quietStart < e.quietEnd {
+		return offset >= e.quietStart && offset < e.quietEnd
+	}
+	return offset >= e.quietStart || offset < e.quietEnd
+}
+
+// Fingerprint identifies identical events: the same kind of event for the
+// same site with the same addresses.
+func Fingerprint(ev *event.ChangeEvent) string {
+	old := append([]string(nil), ev.OldIPs...)
+	sort.Strings(old)
+	new := append([]string(nil), ev.NewIPs...)
+	sort.Strings(new)
+
+	return fmt.Sprintf("%s|%s|%d|%s|%s", ev.Kind, ev.Hostname, ev.Port, strings.Join(old, ","), strings.Join(new, ","))
+}
+
+// Decide works out what to do with ev. The decision is not stored; pass it
+// to Record or store it in the transaction that stores the event.
+func (e *Engine) Decide(tx *bbolt.Tx, ev *event.ChangeEvent, now time.Time) (*store.Decision, error) {
+	d := &store.Decision{
+		EventID:     ev.ID,
+		Hostname:    ev.Hostname,
+		Kind:        ev.Kind,
+		Action:      ActionSend,
+		Fingerprint: Fingerprint(ev),
+		Time:        now,
+	}
+
+	lookback := e.cfg.DedupWindow
+	if e.cfg.RateLimit.Window > lookback {
+		lookback = e.cfg.RateLimit.Window
+	}
+	if lookback == 0 && !e.quiet {
+		return d, nil
+	}
+
+	earlier, err := store.ListDecisions(tx, now.Add(-lookback))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.cfg.DedupWindow > 0 {
+		for i := len(earlier) - 1; i >= 0; i-- {
+			p := earlier[i]
+			if p.Fingerprint != d.Fingerprint || now.Sub(p.Time) >= e.cfg.DedupWindow {
+				continue
+			}
+			if sent(p) || (p.Action == ActionHold && !p.Released) {
+				d.Action = ActionSuppress
+				d.Reason = fmt.Sprintf("duplicate of the event %s at %s", past(p.Action), p.Time.Format(time.RFC3339))
+				return d, nil
+			}
+		}
+	}
+
+	if e.InQuietHours(now) && ev.Severity != event.SeverityCritical {
+		d.Action = ActionHold
+		d.Reason = fmt.Sprintf("quiet hours until %s", clock(e.quietEnd))
+		d.Event = ev
+		return d, nil
+	}
+
+	if e.cfg.RateLimit.Window > 0 {
+		site, all := 0, 0
+		for _, p := range earlier {
+			if !sent(p) || now.Sub(p.Time) >= e.cfg.RateLimit.Window {
+				continue
+			}
+			all++
+			if p.Hostname == ev.Hostname {
+				site++
+			}
+		}
+
+		if e.cfg.RateLimit.PerSite > 0 && site >= e.cfg.RateLimit.PerSite {
+			d.Action = ActionSuppress
+			d.Reason = fmt.Sprintf("site rate limit of %d per %s reached", e.cfg.RateLimit.PerSite, e.cfg.RateLimit.Window)
+			return d, nil
+		}
+		if e.cfg.RateLimit.Global > 0 && all >= e.cfg.RateLimit.Global {
+			d.Action = ActionSuppress
+			d.Reason = fmt.Sprintf("global rate limit of %d per %s reached", e.cfg.RateLimit.Global, e.cfg.RateLimit.Window)
+			return d, nil
+		}
+	}
+
+	return d, nil
+}
+
+func sent(d store.Decision) bool {
+	return d.Action == ActionSend || d.Action == ActionRelease
+}
+
+// past describes what happened to an earlier event.
+func past(action string) string {
+	switch action {
+	case ActionHold:
+		return "held"
+	case ActionRelease:
+		return "released"
+	}
+	return "sent"
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// Record stores d in tx, sets the matching status on ev and logs the
+// decision.
+func Record(tx *bbolt.Tx, d *store.Decision, ev *event.ChangeEvent) error {
+	switch d.Action {
+	case ActionSuppress:
+		ev.NotificationStatus = event.StatusSuppressed
+		logrus.Infof("Suppressed %s notification for %s: %s", ev.Kind, ev.Hostname, d.Reason)
+	case ActionHold:
+		ev.NotificationStatus = event.StatusHeld
+		logrus.Infof("Holding %s notification for %s: %s", ev.Kind, ev.Hostname, d.Reason)
+	default:
+		logrus.Debugf("Sending %s notification for %s", ev.Kind, ev.Hostname)
+	}
+
+	d.EventID = ev.ID
+	return store.PutDecision(tx, d)
+}
+
+// Release returns the events held during quiet hours once they are over,
+// recording a release decision for each. Nothing is released while quiet
+// hours last.
+func (e *Engine) Release(db *bbolt.DB, now time.Time) ([]*event.ChangeEvent, error) {
+	if e.InQuietHours(now) {
+		return nil, nil
+	}
+
+	var released []*event.ChangeEvent
+	err := db.Update(func(tx *bbolt.Tx) error {
+		held, err := store.ListDecisions(tx, time.Time{})
+		if err != nil {
+			return err
+		}
+
+		for _, h := range held {
+			if h.Action != ActionHold || h.Released || h.Event == nil {
+				continue
+			}
+
+			h.Released = true
+			err = store.UpdateDecision(tx, &h)
+			if err != nil {
+				return err
+			}
+
+			err = store.PutDecision(tx, &store.Decision{
+				EventID:     h.EventID,
+				Hostname:    h.Hostname,
+				Kind:        h.Kind,
+				Action:      ActionRelease,
+				Reason:      fmt.Sprintf("quiet hours over, held since %s", h.Time.Format(time.RFC3339)),
+				Fingerprint: h.Fingerprint,
+				Time:        now,
+			})
+			if err != nil {
+				return err
+			}
+
+			released = append(released, h.Event)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to release held notifications: %w", err)
+	}
+
+	if len(released) > 0 {
+		logrus.Infof("Quiet hours over, releasing %d held notifications", len(released))
+	}
+
+	return released, nil
+}
+
+// Prune drops decisions too old to matter.
+func (e *Engine) Prune(db *bbolt.DB, now time.Time) error {
+	keep := Retention
+	if e.cfg.DedupWindow > keep {
+		keep = e.cfg.DedupWindow
+	}
+	if e.cfg.RateLimit.Window > keep {
+		keep = e.cfg.RateLimit.Window
+	}
+
+	_, err := store.PruneDecisions(db, now.Add(-keep))
+	return err
+}
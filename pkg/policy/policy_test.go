@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func openDB(t *testing.T) *bbolt.DB {
+	db, err := store.Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func change(host, oldIP, newIP string) *event.ChangeEvent {
+	return &event.ChangeEvent{
+		Hostname: host,
+		Kind:     event.KindIPChanged,
+		OldIPs:   []string{oldIP},
+		NewIPs:   []string{newIP},
+		Severity: event.SeverityCritical,
+	}
+}
+
+// decide runs ev through the engine and records the decision.
+func decide(t *testing.T, db *bbolt.DB, e *Engine, ev *event.ChangeEvent, now time.Time) *store.Decision {
+	var d *store.Decision
+	err := db.Update(func(tx *bbolt.Tx) error {
+		var err error
+		d, err = e.Decide(tx, ev, now)
+		if err != nil {
+			return err
+		}
+		return Record(tx, d, ev)
+	})
+	require.NoError(t, err)
+	return d
+}
+
+func TestNew(t *testing.T) {
+	var cfg config.PolicyConfig
+	cfg.QuietHours.Start = "22:00"
+	cfg.QuietHours.End = "7am"
+	_, err := New(cfg)
+	assert.ErrorContains(t, err, "quiet_hours.end")
+
+	cfg = config.PolicyConfig{}
+	cfg.RateLimit.PerSite = 3
+	_, err = New(cfg)
+	assert.ErrorContains(t, err, "rate_limit.window")
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", "2025-03-04 "+clock, time.Local)
+		require.NoError(t, err)
+		return tm
+	}
+
+	var cfg config.PolicyConfig
+	cfg.QuietHours.Start = "22:00"
+	cfg.QuietHours.End = "07:00"
+	e, err := New(cfg)
+	require.NoError(t, err)
+
+	assert.True(t, e.InQuietHours(at("23:30")))
+	assert.True(t, e.InQuietHours(at("02:00")))
+	assert.False(t, e.InQuietHours(at("07:00")))
+	assert.False(t, e.InQuietHours(at("12:00")))
+
+	cfg.QuietHours.Start = "12:00"
+	cfg.QuietHours.End = "13:00"
+	e, err = New(cfg)
+	require.NoError(t, err)
+
+	assert.True(t, e.InQuietHours(at("12:30")))
+	assert.False(t, e.InQuietHours(at("23:30")))
+
+	e, err = New(config.PolicyConfig{})
+	require.NoError(t, err)
+	assert.False(t, e.InQuietHours(at("02:00")))
+}
+
+func TestDuplicatesAreSuppressed(t *testing.T) {
+	db := openDB(t)
+
+	var cfg config.PolicyConfig
+	cfg.DedupWindow = time.Hour
+	e, err := New(cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	assert.Equal(t, ActionSend, decide(t, db, e, change("example.com", "1.1.1.1", "2.2.2.2"), now).Action)
+
+	// A flap back is a different event
+	assert.Equal(t, ActionSend, decide(t, db, e, change("example.com", "2.2.2.2", "1.1.1.1"), now.Add(time.Minute)).Action)
+
+	ev := change("example.com", "1.1.1.1", "2.2.2.2")
+	d := decide(t, db, e, ev, now.Add(2*time.Minute))
+	assert.Equal(t, ActionSuppress, d.Action)
+	assert.Contains(t, d.Reason, "duplicate")
+	assert.Equal(t, event.StatusSuppressed, ev.NotificationStatus)
+
+	// Outside the window it goes out again
+	assert.Equal(t, ActionSend, decide(t, db, e, change("example.com", "1.1.1.1", "2.2.2.2"), now.Add(2*time.Hour)).Action)
+}
+
+func TestRateLimits(t *testing.T) {
+	db := openDB(t)
+
+	var cfg config.PolicyConfig
+	cfg.RateLimit.Window = time.Hour
+	cfg.RateLimit.PerSite = 2
+	cfg.RateLimit.Global = 3
+	e, err := New(cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	assert.Equal(t, ActionSend, decide(t, db, e, change("a.com", "1.1.1.1", "2.2.2.2"), now).Action)
+	assert.Equal(t, ActionSend, decide(t, db, e, change("a.com", "2.2.2.2", "3.3.3.3"), now).Action)
+
+	d := decide(t, db, e, change("a.com", "3.3.3.3", "4.4.4.4"), now)
+	assert.Equal(t, ActionSuppress, d.Action)
+	assert.Contains(t, d.Reason, "site rate limit")
+
+	assert.Equal(t, ActionSend, decide(t, db, e, change("b.com", "1.1.1.1", "2.2.2.2"), now).Action)
+
+	d = decide(t, db, e, change("c.com", "1.1.1.1", "2.2.2.2"), now)
+	assert.Equal(t, ActionSuppress, d.Action)
+	assert.Contains(t, d.Reason, "global rate limit")
+
+	// Suppressed events do not count against the limit
+	assert.Equal(t, ActionSend, decide(t, db, e, change("c.com", "1.1.1.1", "2.2.2.2"), now.Add(61*time.Minute)).Action)
+}
+
+func TestQuietHoursHoldAndRelease(t *testing.T) {
+	db := openDB(t)
+
+	var cfg config.PolicyConfig
+	cfg.DedupWindow = 24 * time.Hour
+	cfg.QuietHours.Start = "22:00"
+	cfg.QuietHours.End = "07:00"
+	e, err := New(cfg)
+	require.NoError(t, err)
+
+	night := time.Date(2025, 3, 4, 23, 0, 0, 0, time.Local)
+	failed := &event.ChangeEvent{
+		Hostname: "example.com",
+		Kind:     event.KindLookupFailed,
+		Error:    "no such host",
+		Severity: event.SeverityWarning,
+	}
+
+	d := decide(t, db, e, failed, night)
+	assert.Equal(t, ActionHold, d.Action)
+	assert.Equal(t, event.StatusHeld, failed.NotificationStatus)
+
+	// The same failure an hour later is a duplicate of the held one
+	again := *failed
+	assert.Equal(t, ActionSuppress, decide(t, db, e, &again, night.Add(time.Hour)).Action)
+
+	// Critical events are not held
+	assert.Equal(t, ActionSend, decide(t, db, e, change("example.com", "1.1.1.1", "2.2.2.2"), night).Action)
+
+	released, err := e.Release(db, night.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, released)
+
+	morning := night.Add(9 * time.Hour)
+	released, err = e.Release(db, morning)
+	require.NoError(t, err)
+	require.Len(t, released, 1)
+	assert.Equal(t, event.KindLookupFailed, released[0].Kind)
+	assert.Equal(t, "no such host", released[0].Error)
+
+	released, err = e.Release(db, morning)
+	require.NoError(t, err)
+	assert.Empty(t, released)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		decisions, err := store.ListDecisions(tx, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, decisions, 4)
+		assert.True(t, decisions[0].Released)
+		assert.Equal(t, ActionRelease, decisions[3].Action)
+		return nil
+	})
+	require.NoError(t, err)
+}
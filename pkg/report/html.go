@@ -0,0 +1,173 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/stats"
+)
+
+// Site is the current state of a monitored site as shown in a report.
+type Site struct {
+	Hostname   string
+	Port       int
+	EntityName string
+	IPs        []string
+}
+
+type SiteSummary struct {
+	Site
+	Changes    int
+	LastChange time.Time
+}
+
+type Timeline struct {
+	Hostname   string
+	EntityName string
+	Events     []event.ChangeEvent
+}
+
+type EntityCount struct {
+	EntityName string
+	Sites      int
+	Changes    int
+}
+
+// HTMLData is everything the HTML report template renders.
+type HTMLData struct {
+	Generated    time.Time
+	Filter       Filter
+	TotalChanges int
+	Sites        []SiteSummary
+	Timelines    []Timeline
+	Entities     []EntityCount
+	Churn        []SiteSummary
+	Stats        stats.Summary
+}
+
+// MaxChurn is the number of sites listed in the most churn section.
+const MaxChurn = 10
+
+// BuildHTMLData assembles report data from the current sites and the events
+// selected for the report.
+func BuildHTMLData(sites []Site, events []event.ChangeEvent, filter Filter, now time.Time) HTMLData {
+	data := HTMLData{
+		Generated:    now,
+		Filter:       filter,
+		TotalChanges: len(events),
+		Stats:        stats.Compute(events, now),
+	}
+
+	summaries := make(map[string]*SiteSummary)
+	for _, s := range sites {
+		summaries[s.Hostname] = &SiteSummary{Site: s}
+	}
+
+	timelines := make(map[string]*Timeline)
+	for _, ev := range events {
+		sum, ok := summaries[ev.Hostname]
+		if !ok {
+			// Site has since been removed from the inventory
+			sum = &SiteSummary{Site: Site{Hostname: ev.Hostname, Port: ev.Port, EntityName: ev.EntityName}}
+			summaries[ev.Hostname] = sum
+		}
+		sum.Changes++
+		if ev.Time.After(sum.LastChange) {
+			sum.LastChange = ev.Time
+		}
+
+		tl, ok := timelines[ev.Hostname]
+		if !ok {
+			tl = &Timeline{Hostname: ev.Hostname, EntityName: ev.EntityName}
+			timelines[ev.Hostname] = tl
+		}
+		tl.Events = append(tl.Events, ev)
+	}
+
+	entities := make(map[string]*EntityCount)
+	for _, sum := range summaries {
+		data.Sites = append(data.Sites, *sum)
+
+		ec, ok := entities[sum.EntityName]
+		if !ok {
+			ec = &EntityCount{EntityName: sum.EntityName}
+			entities[sum.EntityName] = ec
+		}
+		ec.Sites++
+		ec.Changes += sum.Changes
+	}
+
+	sort.Slice(data.Sites, func(i, j int) bool {
+		return data.Sites[i].Hostname < data.Sites[j].Hostname
+	})
+
+	for _, tl := range timelines {
+		data.Timelines = append(data.Timelines, *tl)
+	}
+	sort.Slice(data.Timelines, func(i, j int) bool {
+		return data.Timelines[i].Hostname < data.Timelines[j].Hostname
+	})
+
+	for _, ec := range entities {
+		data.Entities = append(data.Entities, *ec)
+	}
+	sort.Slice(data.Entities, func(i, j int) bool {
+		if data.Entities[i].Changes != data.Entities[j].Changes {
+			return data.Entities[i].Changes > data.Entities[j].Changes
+		}
+		return data.Entities[i].EntityName < data.Entities[j].EntityName
+	})
+
+	for _, sum := range data.Sites {
+		if sum.Changes > 0 {
+			data.Churn = append(data.Churn, sum)
+		}
+	}
+	sort.SliceStable(data.Churn, func(i, j int) bool {
+		return data.Churn[i].Changes > data.Churn[j].Changes
+	})
+	if len(data.Churn) > MaxChurn {
+		data.Churn = data.Churn[:MaxChurn]
+	}
+
+	return data
+}
+
+// TemplateFuncs are the functions available to the report and digest
+// templates.
+var TemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"formatTime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	},
+}
+
+// WriteHTML renders data with the HTML template at templatePath.
+func WriteHTML(w io.Writer, templatePath string, data HTMLData) error {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to read report template file: %w", err)
+	}
+
+	t, err := template.New(filepath.Base(templatePath)).Funcs(TemplateFuncs).Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse report template: %w", err)
+	}
+
+	err = t.Execute(w, data)
+	if err != nil {
+		return fmt.Errorf("failed to render report template: %w", err)
+	}
+
+	return nil
+}
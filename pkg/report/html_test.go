@@ -0,0 +1,62 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildHTMLData(t *testing.T) {
+	sites := []Site{
+		{Hostname: "a.example.com", Port: 22, EntityName: "Alpha", IPs: []string{"1.1.1.4"}},
+		{Hostname: "b.example.com", Port: 443, EntityName: "Beta", IPs: []string{"3.3.3.3"}},
+		{Hostname: "c.example.com", Port: 443, EntityName: "Alpha", IPs: []string{"9.9.9.9"}},
+	}
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	data := BuildHTMLData(sites, Select(testEvents(), Filter{}), Filter{}, now)
+
+	assert.Equal(t, 3, data.TotalChanges)
+	require.Len(t, data.Sites, 3)
+	assert.Equal(t, 2, data.Sites[0].Changes)
+	assert.Equal(t, 0, data.Sites[2].Changes)
+
+	require.Len(t, data.Timelines, 2)
+	assert.Equal(t, "a.example.com", data.Timelines[0].Hostname)
+	assert.Len(t, data.Timelines[0].Events, 2)
+
+	require.Len(t, data.Entities, 2)
+	assert.Equal(t, EntityCount{EntityName: "Alpha", Sites: 2, Changes: 2}, data.Entities[0])
+	assert.Equal(t, EntityCount{EntityName: "Beta", Sites: 1, Changes: 1}, data.Entities[1])
+
+	require.Len(t, data.Churn, 2)
+	assert.Equal(t, "a.example.com", data.Churn[0].Hostname)
+
+	require.Len(t, data.Stats.Entities, 2)
+	assert.Equal(t, "Alpha", data.Stats.Entities[0].Name)
+}
+
+func TestWriteHTML(t *testing.T) {
+	sites := []Site{{Hostname: "a.example.com", Port: 22, EntityName: "Alpha <Corp>", IPs: []string{"1.1.1.4"}}}
+	data := BuildHTMLData(sites, Select(testEvents(), Filter{}), Filter{}, time.Now())
+
+	var buf bytes.Buffer
+	err := WriteHTML(&buf, "../../templates/report.html", data)
+	require.NoError(t, err)
+
+	rendered := buf.String()
+	assert.Contains(t, rendered, "<style>")
+	assert.Contains(t, rendered, "a.example.com")
+	assert.Contains(t, rendered, "b.example.com")
+	assert.Contains(t, rendered, "1.1.1.2, 1.1.1.3")
+	assert.Contains(t, rendered, "Alpha &lt;Corp&gt;")
+	assert.Contains(t, rendered, "Churn statistics per entity")
+	assert.NotContains(t, rendered, "<link")
+	assert.NotContains(t, rendered, "<script src")
+
+	err = WriteHTML(&buf, "missing.html", data)
+	assert.Error(t, err)
+}
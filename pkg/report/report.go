@@ -0,0 +1,154 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+)
+
+const (
+	FormatTable  = "table"
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// Formats lists every supported output format.
+var Formats = []string{FormatTable, FormatCSV, FormatJSON, FormatNDJSON}
+
+// Filter selects which change events appear in a report. Zero fields match
+// everything.
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	Site   string
+	Entity string
+	Kind   event.Kind
+}
+
+func (f Filter) Match(ev event.ChangeEvent) bool {
+	if !f.Since.IsZero() && ev.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !ev.Time.Before(f.Until) {
+		return false
+	}
+	if f.Site != "" && !strings.EqualFold(f.Site, ev.Hostname) {
+		return false
+	}
+	if f.Entity != "" && !strings.EqualFold(f.Entity, ev.EntityName) {
+		return false
+	}
+	if f.Kind != "" && f.Kind != ev.Kind {
+		return false
+	}
+	return true
+}
+
+// Select returns the events matching f sorted by time.
+func Select(events []event.ChangeEvent, f Filter) []event.ChangeEvent {
+	selected := make([]event.ChangeEvent, 0, len(events))
+	for _, ev := range events {
+		if f.Match(ev) {
+			selected = append(selected, ev)
+		}
+	}
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		return selected[i].Time.Before(selected[j].Time)
+	})
+
+	return selected
+}
+
+// ParseTime accepts an RFC3339 timestamp, a date (2006-01-02) or a duration
+// such as 24h that is taken as relative to now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339, YYYY-MM-DD or a duration", s)
+}
+
+var columns = []string{"Time", "Site", "Port", "Entity", "Kind", "Old IPs", "New IPs", "Severity", "Notification"}
+
+func row(ev event.ChangeEvent) []string {
+	return []string{
+		ev.Time.Format(time.RFC3339),
+		ev.Hostname,
+		strconv.Itoa(ev.Port),
+		ev.EntityName,
+		string(ev.Kind),
+		strings.Join(ev.OldIPs, ";"),
+		strings.Join(ev.NewIPs, ";"),
+		string(ev.Severity),
+		string(ev.NotificationStatus),
+	}
+}
+
+// Write renders events to w in the given format.
+func Write(w io.Writer, events []event.ChangeEvent, format string) error {
+	switch format {
+	case FormatTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, ev := range events {
+			fmt.Fprintln(tw, strings.Join(row(ev), "\t"))
+		}
+		return tw.Flush()
+
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(columns)
+		if err != nil {
+			return fmt.Errorf("failed to write csv header row: %w", err)
+		}
+		for _, ev := range events {
+			err = cw.Write(row(ev))
+			if err != nil {
+				return fmt.Errorf("failed to write csv row: %w", err)
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case FormatJSON:
+		if events == nil {
+			events = []event.ChangeEvent{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(events)
+
+	case FormatNDJSON:
+		enc := json.NewEncoder(w)
+		for _, ev := range events {
+			err := enc.Encode(ev)
+			if err != nil {
+				return fmt.Errorf("failed to write json line: %w", err)
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown report format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
@@ -0,0 +1,112 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []event.ChangeEvent {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	return []event.ChangeEvent{
+		{Hostname: "b.example.com", EntityName: "Beta", Kind: event.KindIPChanged, Time: base.Add(48 * time.Hour), OldIPs: []string{"2.2.2.2"}, NewIPs: []string{"3.3.3.3"}},
+		{Hostname: "a.example.com", EntityName: "Alpha", Kind: event.KindIPChanged, Time: base, OldIPs: []string{"1.1.1.1"}, NewIPs: []string{"1.1.1.2", "1.1.1.3"}},
+		{Hostname: "a.example.com", EntityName: "Alpha", Kind: event.KindIPChanged, Time: base.Add(24 * time.Hour), OldIPs: []string{"1.1.1.2"}, NewIPs: []string{"1.1.1.4"}},
+	}
+}
+
+func TestSelect(t *testing.T) {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"no filter sorts by time", Filter{}, []string{"a.example.com", "a.example.com", "b.example.com"}},
+		{"since", Filter{Since: base.Add(time.Hour)}, []string{"a.example.com", "b.example.com"}},
+		{"until is exclusive", Filter{Until: base.Add(24 * time.Hour)}, []string{"a.example.com"}},
+		{"site", Filter{Site: "B.example.com"}, []string{"b.example.com"}},
+		{"entity", Filter{Entity: "alpha"}, []string{"a.example.com", "a.example.com"}},
+		{"kind", Filter{Kind: "lookup_failed"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, ev := range Select(testEvents(), tt.filter) {
+				got = append(got, ev.Hostname)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	got, err := ParseTime("2024-03-01T10:00:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), got)
+
+	got, err = ParseTime("24h", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-24*time.Hour), got)
+
+	got, err = ParseTime("2024-03-01", now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, got.Day())
+
+	got, err = ParseTime("", now)
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+
+	_, err = ParseTime("last tuesday", now)
+	assert.Error(t, err)
+}
+
+func TestWriteFormats(t *testing.T) {
+	events := Select(testEvents(), Filter{})
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, events, FormatTable))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[0], "TIME"))
+	assert.Contains(t, lines[1], "1.1.1.2;1.1.1.3")
+
+	buf.Reset()
+	require.NoError(t, Write(&buf, events, FormatCSV))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	assert.Len(t, records, 4)
+	assert.Equal(t, "Site", records[0][1])
+	assert.Equal(t, "b.example.com", records[3][1])
+
+	buf.Reset()
+	require.NoError(t, Write(&buf, events, FormatJSON))
+	var decoded []event.ChangeEvent
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Len(t, decoded, 3)
+
+	buf.Reset()
+	require.NoError(t, Write(&buf, nil, FormatJSON))
+	assert.Equal(t, "[]\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Write(&buf, events, FormatNDJSON))
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	var first event.ChangeEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "a.example.com", first.Hostname)
+
+	assert.Error(t, Write(&buf, events, "xml"))
+}
@@ -0,0 +1,227 @@
+// Package resolver looks up hostnames with the system resolver or, when DNS
+// servers are configured, by asking them directly, so the TTL of the answer
+// and the server that gave it can be recorded.
+package resolver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// System names the operating system resolver, used when no DNS server is
+// configured or the hostname is not fully qualified. It honours the hosts
+// file and the system's search domains.
+const System = "system"
+
+const defaultTimeout = 5 * time.Second
+
+// ErrNotFound is returned for names that do not exist or have no
+// addresses.
+var ErrNotFound = errors.New("no such host")
+
+// Result is the outcome of one lookup. TTL is the lowest TTL in the answer
+// and is zero when the system resolver answered. Server is the one that
+// answered, or every server tried when none did.
+type Result struct {
+	IPs    []net.IP
+	TTL    time.Duration
+	Server string
+}
+
+// Resolver queries Servers in order until one answers. Without servers it
+// uses the system resolver.
+type Resolver struct {
+	Servers []string
+	Timeout time.Duration
+}
+
+// New returns a resolver for servers, given as host or host:port.
+func New(servers []string, timeout time.Duration) *Resolver {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	r := &Resolver{Timeout: timeout}
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		r.Servers = append(r.Servers, s)
+	}
+	return r
+}
+
+// Lookup returns the IPv4 and IPv6 addresses of host.
+func (r *Resolver) Lookup(ctx context.Context, host string) (Result, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return Result{IPs: []net.IP{ip}, Server: System}, nil
+	}
+
+	// Short names depend on the system's search domains
+	if len(r.Servers) == 0 || !strings.Contains(strings.TrimSuffix(host, "."), ".") {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		return Result{IPs: ips, Server: System}, err
+	}
+
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return Result{}, fmt.Errorf("invalid hostname %q: %w", host, err)
+	}
+
+	var errs []error
+	for _, server := range r.Servers {
+		res, err := r.lookup(ctx, server, name)
+		if err == nil || errors.Is(err, ErrNotFound) {
+			res.Server = server
+			return res, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+	}
+	return Result{Server: strings.Join(r.Servers, ",")}, fmt.Errorf("lookup %s failed: %w", host, errors.Join(errs...))
+}
+
+func (r *Resolver) lookup(ctx context.Context, server string, name dnsmessage.Name) (Result, error) {
+	var res Result
+	var ttl uint32
+	found := false
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := r.exchange(ctx, server, name, qtype)
+		if err != nil {
+			return Result{}, err
+		}
+
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+		case dnsmessage.RCodeNameError:
+			return Result{}, fmt.Errorf("lookup %s: %w", strings.TrimSuffix(name.String(), "."), ErrNotFound)
+		default:
+			return Result{}, fmt.Errorf("server returned %s", msg.RCode)
+		}
+
+		for _, a := range msg.Answers {
+			switch body := a.Body.(type) {
+			case *dnsmessage.AResource:
+				res.IPs = append(res.IPs, net.IP(body.A[:]))
+			case *dnsmessage.AAAAResource:
+				res.IPs = append(res.IPs, net.IP(body.AAAA[:]))
+			case *dnsmessage.CNAMEResource:
+			default:
+				continue
+			}
+			// The answer is only good for as long as every record in the
+			// chain, CNAMEs included
+			if !found || a.Header.TTL < ttl {
+				ttl = a.Header.TTL
+				found = true
+			}
+		}
+	}
+
+	if len(res.IPs) == 0 {
+		return Result{}, fmt.Errorf("lookup %s: %w", strings.TrimSuffix(name.String(), "."), ErrNotFound)
+	}
+	res.TTL = time.Duration(ttl) * time.Second
+	return res, nil
+}
+
+// exchange sends one query over UDP, and again over TCP when the answer
+// was truncated.
+func (r *Resolver) exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	// An unpredictable ID makes forged answers harder to slip in
+	var b [2]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick query ID: %w", err)
+	}
+	id := binary.BigEndian.Uint16(b[:])
+	question := dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	msg, err := r.roundTrip(ctx, "udp", server, packed)
+	if err == nil && msg.Truncated {
+		msg, err = r.roundTrip(ctx, "tcp", server, packed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if msg.ID != id || !msg.Response || !answers(msg, question) {
+		return nil, errors.New("mismatched response")
+	}
+	return msg, nil
+}
+
+// answers reports whether msg is a response to question.
+func answers(msg *dnsmessage.Message, question dnsmessage.Question) bool {
+	if len(msg.Questions) != 1 {
+		return false
+	}
+	q := msg.Questions[0]
+	return q.Type == question.Type && q.Class == question.Class && strings.EqualFold(q.Name.String(), question.Name.String())
+}
+
+func (r *Resolver) roundTrip(ctx context.Context, network, server string, query []byte) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		// DNS over TCP prefixes each message with its length
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		_, err = conn.Write(append(framed, query...))
+		if err != nil {
+			return nil, err
+		}
+		var size [2]byte
+		_, err = io.ReadFull(conn, size[:])
+		if err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(size[:]))
+		_, err = io.ReadFull(conn, buf)
+	} else {
+		_, err = conn.Write(query)
+		if err != nil {
+			return nil, err
+		}
+		buf = make([]byte, 1232)
+		var n int
+		n, err = conn.Read(buf)
+		buf = buf[:n]
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msg dnsmessage.Message
+	err = msg.Unpack(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &msg, nil
+}
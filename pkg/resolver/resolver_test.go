@@ -0,0 +1,162 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeServer answers DNS queries over UDP with answer.
+func fakeServer(t *testing.T, answer func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource)) string {
+	return serve(t, func(query dnsmessage.Message) dnsmessage.Message {
+		rcode, answers := answer(query.Questions[0])
+		return dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, RCode: rcode},
+			Questions: query.Questions,
+			Answers:   answers,
+		}
+	})
+}
+
+// serve answers DNS queries over UDP with whatever respond makes of them.
+func serve(t *testing.T, respond func(query dnsmessage.Message) dnsmessage.Message) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil {
+				continue
+			}
+			resp := respond(query)
+			packed, err := resp.Pack()
+			if err == nil {
+				conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func record(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	h := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: ttl}
+	switch body.(type) {
+	case *dnsmessage.AResource:
+		h.Type = dnsmessage.TypeA
+	case *dnsmessage.AAAAResource:
+		h.Type = dnsmessage.TypeAAAA
+	case *dnsmessage.CNAMEResource:
+		h.Type = dnsmessage.TypeCNAME
+	}
+	return dnsmessage.Resource{Header: h, Body: body}
+}
+
+func TestLookup(t *testing.T) {
+	server := fakeServer(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		if q.Name.String() == "gone.example.com." {
+			return dnsmessage.RCodeNameError, nil
+		}
+		if q.Type == dnsmessage.TypeAAAA {
+			return dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+				record("sftp.example.com.", 600, &dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}),
+			}
+		}
+		return dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			record("sftp.example.com.", 300, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("edge.example.net.")}),
+			record("edge.example.net.", 60, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}}),
+			record("edge.example.net.", 60, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 11}}),
+		}
+	})
+
+	r := New([]string{server}, time.Second)
+	res, err := r.Lookup(context.Background(), "sftp.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, server, res.Server)
+	assert.Equal(t, time.Minute, res.TTL)
+	require.Len(t, res.IPs, 3)
+	assert.Equal(t, "192.0.2.10", res.IPs[0].String())
+	assert.Equal(t, "192.0.2.11", res.IPs[1].String())
+	assert.Equal(t, "2001:db8::1", res.IPs[2].String())
+
+	res, err = r.Lookup(context.Background(), "gone.example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, server, res.Server)
+}
+
+func TestLookupFallsBackToNextServer(t *testing.T) {
+	failing := fakeServer(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		return dnsmessage.RCodeServerFailure, nil
+	})
+	working := fakeServer(t, func(q dnsmessage.Question) (dnsmessage.RCode, []dnsmessage.Resource) {
+		if q.Type != dnsmessage.TypeA {
+			return dnsmessage.RCodeSuccess, nil
+		}
+		return dnsmessage.RCodeSuccess, []dnsmessage.Resource{
+			record("sftp.example.com.", 30, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}),
+		}
+	})
+
+	r := New([]string{failing, working}, time.Second)
+	res, err := r.Lookup(context.Background(), "sftp.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, working, res.Server)
+	assert.Equal(t, 30*time.Second, res.TTL)
+
+	r = New([]string{failing}, time.Second)
+	_, err = r.Lookup(context.Background(), "sftp.example.com")
+	assert.ErrorContains(t, err, "server returned RCodeServerFailure")
+}
+
+func TestLookupLiteralsAndDefaults(t *testing.T) {
+	r := New([]string{"192.0.2.53"}, 0)
+	assert.Equal(t, []string{"192.0.2.53:53"}, r.Servers)
+	assert.Equal(t, defaultTimeout, r.Timeout)
+
+	res, err := r.Lookup(context.Background(), "192.0.2.7")
+	require.NoError(t, err)
+	assert.Equal(t, System, res.Server)
+	assert.Equal(t, "192.0.2.7", res.IPs[0].String())
+}
+
+func TestLookupRejectsMismatchedQuestion(t *testing.T) {
+	// Answers carry the query ID but name another host
+	server := serve(t, func(query dnsmessage.Message) dnsmessage.Message {
+		q := query.Questions[0]
+		q.Name = dnsmessage.MustNewName("evil.example.net.")
+		return dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true},
+			Questions: []dnsmessage.Question{q},
+			Answers: []dnsmessage.Resource{
+				record("evil.example.net.", 60, &dnsmessage.AResource{A: [4]byte{203, 0, 113, 1}}),
+			},
+		}
+	})
+
+	r := New([]string{server}, 200*time.Millisecond)
+	_, err := r.Lookup(context.Background(), "sftp.example.com")
+	assert.ErrorContains(t, err, "mismatched response")
+}
+
+func TestLookupUsesSystemResolverByDefault(t *testing.T) {
+	r := New(nil, 0)
+	assert.Empty(t, r.Servers)
+
+	res, err := r.Lookup(context.Background(), "localhost")
+	require.NoError(t, err)
+	assert.Equal(t, System, res.Server)
+	assert.Zero(t, res.TTL)
+	assert.NotEmpty(t, res.IPs)
+}
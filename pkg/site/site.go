@@ -1,29 +1,43 @@
 package site
 
 import (
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
 	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/outbox"
+	"github.com/bytetwiddler/digger/pkg/policy"
+	"github.com/bytetwiddler/digger/pkg/report"
+	"github.com/bytetwiddler/digger/pkg/resolver"
+	"github.com/bytetwiddler/digger/pkg/store"
 	"github.com/sirupsen/logrus"
 	"go.etcd.io/bbolt"
 	"golang.org/x/sys/windows/svc/eventlog"
 )
 
+const defaultReachabilityTimeout = 5 * time.Second
+
 type Site struct {
 	Hostname   string
 	Port       int
 	EntityName string
 	IP         string   // Stores semicolon-separated IPs as a single string
 	IPs        []string // Stores the split IPs for easier processing
+	Clients    []string // Internal hosts that connect to the site
+	Tags       []string // Labels that notification routes match on
+	Owners     []string // Addresses that get this site's notifications
 	OldIP      string
 	NewIP      string
 	Changed    bool
@@ -44,6 +58,22 @@ func (s *Sites) ReadFromCSV(filePath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read from csv file: %w", err)
 	}
+	if len(records) == 0 {
+		return fmt.Errorf("csv file %s is empty", filePath)
+	}
+
+	// The optional Clients, Tags and Owners columns are found by their
+	// headers
+	optional := map[string]int{}
+	for i, name := range records[0] {
+		optional[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(record []string, name string) []string {
+		if i, ok := optional[name]; ok {
+			return splitList(record[i])
+		}
+		return nil
+	}
 
 	// Skip the header row
 	for _, record := range records[1:] {
@@ -73,12 +103,65 @@ func (s *Sites) ReadFromCSV(filePath string) error {
 			IP:         record[3], // Keep original string
 			IPs:        validIPs,  // Store split IPs
 		}
+		site.Clients = column(record, "clients")
+		site.Tags = column(record, "tags")
+		site.Owners = column(record, "owners")
 		*s = append(*s, site)
 	}
 
 	return nil
 }
 
+// splitList splits a semicolon separated field, dropping empty entries.
+func splitList(field string) []string {
+	var list []string
+	for _, v := range strings.Split(field, ";") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Snapshot reads the inventory at path and, when it reads cleanly, saves a
+// copy at snapshot, replacing the old copy in one step. The copy is read
+// rather than path itself, so a write that lands in between cannot slip
+// through unchecked.
+func Snapshot(path, snapshot string) (Sites, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv file: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(snapshot), filepath.Base(snapshot)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sites snapshot: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to write sites snapshot: %w", err)
+	}
+
+	var sites Sites
+	err = sites.ReadFromCSV(tmp.Name())
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	err = os.Rename(tmp.Name(), snapshot)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("failed to save sites snapshot: %w", err)
+	}
+	return sites, nil
+}
+
 func (s *Sites) WriteToCSV(filePath string) error {
 	file, err := os.Create(filePath)
 	if err != nil {
@@ -90,7 +173,7 @@ func (s *Sites) WriteToCSV(filePath string) error {
 	defer writer.Flush()
 
 	// Write the header row
-	err = writer.Write([]string{"Hostname", "Port", "EntityName", "IP", "OldIP", "NewIP", "ChangeTime"})
+	err = writer.Write([]string{"Hostname", "Port", "EntityName", "IP", "OldIP", "NewIP", "ChangeTime", "Clients", "Tags", "Owners"})
 	if err != nil {
 		return fmt.Errorf("failed to write csv header row: %w", err)
 	}
@@ -110,6 +193,9 @@ func (s *Sites) WriteToCSV(filePath string) error {
 			site.OldIP,
 			site.NewIP,
 			changeTime,
+			strings.Join(site.Clients, ";"),
+			strings.Join(site.Tags, ";"),
+			strings.Join(site.Owners, ";"),
 		})
 		if err != nil {
 			return fmt.Errorf("failed to write csv site records: %w", err)
@@ -119,7 +205,7 @@ func (s *Sites) WriteToCSV(filePath string) error {
 	return nil
 }
 
-func (s *Sites) UpdateIPs(cfg *config.Config, db *bbolt.DB, updateFlag bool) error {
+func (s *Sites) UpdateIPs(cfg *config.Config, db *bbolt.DB, notifier *notification.Dispatcher, updateFlag bool) error {
 	// Initialize Windows event log
 	elog, err := eventlog.Open("Digger")
 	if err != nil {
@@ -129,14 +215,62 @@ func (s *Sites) UpdateIPs(cfg *config.Config, db *bbolt.DB, updateFlag bool) err
 		defer elog.Close()
 	}
 
+	rules, err := policy.New(cfg.Policy)
+	if err != nil {
+		return fmt.Errorf("failed to load notification policy: %w", err)
+	}
+
+	runID := event.NewRunID()
+	retry := outbox.NewPolicy(cfg)
+
+	// Events held during quiet hours go out before this run's own
+	releaseHeld(db, rules, notifier, retry)
+	var changes []*event.ChangeEvent
+	observations := make([]store.Observation, 0, len(*s))
+	dns := resolver.New(cfg.Resolver.Servers, cfg.Resolver.Timeout)
+
 	for i, site := range *s {
 		// Proceed with IP lookup
-		dnsIPs, err := net.LookupIP(site.Hostname)
+		start := time.Now()
+		answer, err := dns.Lookup(context.Background(), site.Hostname)
+		dnsIPs := answer.IPs
+		obs := store.Observation{
+			Hostname: site.Hostname,
+			Time:     start,
+			TTL:      answer.TTL,
+			Resolver: answer.Server,
+			Latency:  time.Since(start),
+		}
 		if err != nil {
+			obs.Error = err.Error()
+			observations = append(observations, obs)
 			logrus.Errorf("Failed to lookup IP for %s: %v", site.Hostname, err)
+
+			notify(db, rules, notifier, retry, &event.ChangeEvent{
+				SiteID:             site.Hostname,
+				Hostname:           site.Hostname,
+				Port:               site.Port,
+				EntityName:         site.EntityName,
+				Clients:            site.Clients,
+				Tags:               site.Tags,
+				Owners:             site.Owners,
+				Kind:               event.KindLookupFailed,
+				OldIPs:             site.CurrentIPs(),
+				Error:              err.Error(),
+				Resolver:           answer.Server,
+				RunID:              runID,
+				Severity:           event.SeverityWarning,
+				Time:               start,
+				NotificationStatus: event.StatusPending,
+			})
 			continue
 		}
 
+		for _, ip := range dnsIPs {
+			obs.Answers = append(obs.Answers, ip.String())
+		}
+		observations = append(observations, obs)
+
 		// Convert DNS IPs to strings and filter for IPv4 only
 		dnsIPStrings := make([]string, 0, len(dnsIPs))
 		for _, ip := range dnsIPs {
@@ -150,6 +284,13 @@ func (s *Sites) UpdateIPs(cfg *config.Config, db *bbolt.DB, updateFlag bool) err
 			continue
 		}
 
+		if cfg.Reachability.Enabled {
+			ev := checkReachable(cfg.Reachability.Timeout, site, dnsIPStrings[0], answer.Server, runID)
+			if ev != nil {
+				notify(db, rules, notifier, retry, ev)
+			}
+		}
+
 		// If update flag is set and we have multiple IPs in the CSV
 		if updateFlag && len(site.IPs) > 1 {
 			msg := fmt.Sprintf("Multiple IPs in sites.csv for IP field on record %v, manual intervention required, list of IPs %v and resolved IP is %v. The -update flag is set, so the IPs will be updated in the database.",
@@ -196,6 +337,7 @@ func (s *Sites) UpdateIPs(cfg *config.Config, db *bbolt.DB, updateFlag bool) err
 			(*s)[i].OldIP = site.IP
 			(*s)[i].NewIP = dnsIPStrings[0] // Use first IP if multiple are returned
 			(*s)[i].IP = (*s)[i].NewIP
+			(*s)[i].IPs = []string{(*s)[i].NewIP}
 			(*s)[i].Changed = true
 			(*s)[i].ChangeTime = time.Now()
 
@@ -203,28 +345,206 @@ func (s *Sites) UpdateIPs(cfg *config.Config, db *bbolt.DB, updateFlag bool) err
 				site.Hostname, (*s)[i].OldIP, (*s)[i].NewIP)
 			logrus.Info(msg)
 
-			// Send an email notification
-			logrus.Infof("Sending email notification to %s", cfg.SMTP.To)
-			err = notification.SendIPChangeNotification(cfg, site.Hostname, site.Port,
-				site.EntityName, (*s)[i].OldIP, (*s)[i].NewIP)
-			if err != nil {
-				logrus.Errorf("Failed to send email notification: %v", err)
+			ev := &event.ChangeEvent{
+				SiteID:             site.Hostname,
+				Hostname:           site.Hostname,
+				Port:               site.Port,
+				EntityName:         site.EntityName,
+				Clients:            site.Clients,
+				Tags:               site.Tags,
+				Owners:             site.Owners,
+				Kind:               event.KindIPChanged,
+				OldIPs:             site.CurrentIPs(),
+				NewIPs:             dnsIPStrings,
+				Resolver:           answer.Server,
+				RunID:              runID,
+				Severity:           event.SeverityCritical,
+				Time:               (*s)[i].ChangeTime,
+				NotificationStatus: event.StatusPending,
 			}
 
-			// Persist the change in the database
-			err = s.persistSiteChange(db, &(*s)[i])
+			// Persist the change in the database with the policy decision,
+			// queueing its notifications if they are to be sent
+			decision, err := s.persistSiteChange(db, &(*s)[i], ev, rules, notifier.For(ev))
 			if err != nil {
 				logrus.Errorf("Failed to persist change for %s: %v", site.Hostname, err)
 			}
+			if decision != nil && decision.Action != policy.ActionSend {
+				continue
+			}
+
+			// Notify every configured backend
+			notifier.Dispatch(context.Background(), ev)
+
+			// Record the notification outcome on the stored event, leaving
+			// failed deliveries in the outbox for a later run
+			recordDeliveries(db, ev, ev.Deliveries, retry)
+			changes = append(changes, ev)
+		}
+	}
+
+	// Backends that batch get every change from this run in one go
+	if notifier.Batching() && len(changes) > 0 {
+		done := make([]int, len(changes))
+		for i, ev := range changes {
+			done[i] = len(ev.Deliveries)
+		}
+
+		notifier.DispatchBatch(context.Background(), changes)
+
+		for i, ev := range changes {
+			recordDeliveries(db, ev, ev.Deliveries[done[i]:], retry)
+		}
+	}
+
+	err = rules.Prune(db, time.Now())
+	if err != nil {
+		logrus.Errorf("Failed to prune notification decisions: %v", err)
+	}
+
+	// Keep every lookup result for later review
+	err = store.PutObservations(db, observations)
+	if err != nil {
+		logrus.Errorf("Failed to persist lookup observations: %v", err)
+	}
+
+	return nil
+}
+
+// notify stores an event that does not change the site together with its
+// policy decision, queueing and dispatching its notifications if the
+// policy allows.
+func notify(db *bbolt.DB, rules *policy.Engine, notifier *notification.Dispatcher, retry outbox.Policy, ev *event.ChangeEvent) {
+	var decision *store.Decision
+	err := db.Update(func(tx *bbolt.Tx) error {
+		err := store.PutEvent(tx, ev)
+		if err != nil {
+			return fmt.Errorf("failed to store event in db: %w", err)
+		}
+
+		decision, err = rules.Decide(tx, ev, time.Now())
+		if err != nil {
+			return err
+		}
+		err = policy.Record(tx, decision, ev)
+		if err != nil {
+			return err
 		}
+
+		if decision.Action != policy.ActionSend {
+			return store.UpdateEvent(tx, ev)
+		}
+		return outbox.Enqueue(tx, ev, notifier.For(ev), time.Now())
+	})
+	if err != nil {
+		logrus.Errorf("Failed to persist %s event for %s: %v", ev.Kind, ev.Hostname, err)
+	} else if decision.Action != policy.ActionSend {
+		return
+	}
+
+	notifier.Dispatch(context.Background(), ev)
+	recordDeliveries(db, ev, ev.Deliveries, retry)
+}
+
+// releaseHeld delivers the events held back during quiet hours once they
+// are over, all together.
+func releaseHeld(db *bbolt.DB, rules *policy.Engine, notifier *notification.Dispatcher, retry outbox.Policy) {
+	held, err := rules.Release(db, time.Now())
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	if len(held) == 0 {
+		return
+	}
+
+	for _, ev := range held {
+		if ev.ID == "" {
+			continue
+		}
+		err = db.Update(func(tx *bbolt.Tx) error {
+			return outbox.Enqueue(tx, ev, notifier.For(ev), time.Now())
+		})
+		if err != nil {
+			logrus.Errorf("Failed to queue released notification for %s: %v", ev.Hostname, err)
+		}
+	}
+
+	notifier.DispatchAll(context.Background(), held)
+	for _, ev := range held {
+		recordDeliveries(db, ev, ev.Deliveries, retry)
+	}
+}
+
+// recordDeliveries saves the event with its notification outcome and
+// settles deliveries against the outbox.
+func recordDeliveries(db *bbolt.DB, ev *event.ChangeEvent, deliveries []event.Delivery, retry outbox.Policy) {
+	if ev.ID == "" {
+		return
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		err := store.UpdateEvent(tx, ev)
+		if err != nil {
+			return err
+		}
+		return outbox.Settle(tx, ev.ID, deliveries, retry, time.Now())
+	})
+	if err != nil {
+		logrus.Errorf("Failed to record notification status for %s: %v", ev.Hostname, err)
+	}
+}
+
+// checkReachable returns an unreachable event when the site's port does not
+// accept TCP connections on ip, and nil otherwise.
+func checkReachable(timeout time.Duration, site Site, ip, server, runID string) *event.ChangeEvent {
+	if site.Port == 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = defaultReachabilityTimeout
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(site.Port)), timeout)
+	if err == nil {
+		conn.Close()
+		return nil
 	}
 
+	logrus.Warnf("%s is unreachable on %s port %d: %v", site.Hostname, ip, site.Port, err)
+	return &event.ChangeEvent{
+		SiteID:             site.Hostname,
+		Hostname:           site.Hostname,
+		Port:               site.Port,
+		EntityName:         site.EntityName,
+		Clients:            site.Clients,
+		Tags:               site.Tags,
+		Owners:             site.Owners,
+		Kind:               event.KindUnreachable,
+		NewIPs:             []string{ip},
+		Error:              err.Error(),
+		Resolver:           server,
+		RunID:              runID,
+		Severity:           event.SeverityWarning,
+		Time:               time.Now(),
+		NotificationStatus: event.StatusPending,
+	}
+}
+
+// CurrentIPs returns the addresses the site is currently known by.
+func (site Site) CurrentIPs() []string {
+	if len(site.IPs) > 0 {
+		return site.IPs
+	}
+	if site.IP != "" {
+		return []string{site.IP}
+	}
 	return nil
 }
 
 func (s *Sites) ReadFromDB(db *bbolt.DB) error {
 	return db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("sites"))
+		b := tx.Bucket(store.SitesBucket)
 		if b == nil {
 			return errors.New("bucket not found")
 		}
@@ -245,7 +565,7 @@ func (s *Sites) ReadFromDB(db *bbolt.DB) error {
 
 func (s *Sites) WriteToDB(db *bbolt.DB) error {
 	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("sites"))
+		b := tx.Bucket(store.SitesBucket)
 		if b == nil {
 			return errors.New("bucket not found")
 		}
@@ -266,9 +586,13 @@ func (s *Sites) WriteToDB(db *bbolt.DB) error {
 	})
 }
 
-func (s *Sites) persistSiteChange(db *bbolt.DB, site *Site) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("sites"))
+// persistSiteChange stores the site and its change event together with the
+// policy decision for the event, queueing notifications only when the
+// decision is to send them.
+func (s *Sites) persistSiteChange(db *bbolt.DB, site *Site, ev *event.ChangeEvent, rules *policy.Engine, backends []notification.Notifier) (*store.Decision, error) {
+	var decision *store.Decision
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(store.SitesBucket)
 		if b == nil {
 			return errors.New("bucket not found")
 		}
@@ -284,45 +608,45 @@ func (s *Sites) persistSiteChange(db *bbolt.DB, site *Site) error {
 		}
 
 		// Store the change in the changes bucket
-		cb := tx.Bucket([]byte("changes"))
-		if cb == nil {
-			cb, err = tx.CreateBucket([]byte("changes"))
-			if err != nil {
-				return fmt.Errorf("failed to store changes in db: %w", err)
-			}
+		err = store.PutEvent(tx, ev)
+		if err != nil {
+			return fmt.Errorf("failed to store changes in db: %w", err)
 		}
 
-		changeKey := fmt.Sprintf("%s-%s", site.Hostname, time.Now().Format(time.RFC3339))
-		return cb.Put([]byte(changeKey), data)
-	})
-}
+		decision, err = rules.Decide(tx, ev, time.Now())
+		if err != nil {
+			return err
+		}
+		err = policy.Record(tx, decision, ev)
+		if err != nil {
+			return err
+		}
 
-func (s *Sites) ReportChanges(db *bbolt.DB) error {
-	return db.View(func(tx *bbolt.Tx) error {
-		cb := tx.Bucket([]byte("changes"))
-		if cb == nil {
-			return errors.New("changes bucket not found")
+		if decision.Action != policy.ActionSend {
+			return store.UpdateEvent(tx, ev)
 		}
+		return outbox.Enqueue(tx, ev, backends, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		return cb.ForEach(func(k, v []byte) error {
-			var site Site
-			err := json.Unmarshal(v, &site)
-			if err != nil {
-				logrus.Errorf("Failed to unmarshal site data for key %s: %v", k, err)
-				return nil // Skip invalid entries
-			}
+	return decision, nil
+}
 
-			fmt.Printf("Site: %s, Old IP: %s, New IP: %s, Timestamp: %s\n",
-				site.Hostname, site.OldIP, site.NewIP, k)
-			return nil
-		})
-	})
+func (s *Sites) ReportChanges(db *bbolt.DB, w io.Writer, filter report.Filter, format string) error {
+	events, err := store.ListEvents(db)
+	if err != nil {
+		return err
+	}
+
+	return report.Write(w, report.Select(events, filter), format)
 }
 
 func (s *Sites) CountRecords(db *bbolt.DB) (int, error) {
 	count := 0
 	err := db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("changes"))
+		b := tx.Bucket(store.ChangesBucket)
 		if b == nil {
 			return errors.New("bucket not found")
 		}
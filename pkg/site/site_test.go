@@ -1,10 +1,19 @@
 package site
 
 import (
+	"context"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/bytetwiddler/digger/pkg/config"
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/bytetwiddler/digger/pkg/notification"
+	"github.com/bytetwiddler/digger/pkg/outbox"
+	"github.com/bytetwiddler/digger/pkg/policy"
+	"github.com/bytetwiddler/digger/pkg/store"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -95,3 +104,116 @@ func TestReadFromInvalidFile(t *testing.T) {
 	err := sites.ReadFromCSV("nonexistent.csv")
 	assert.Error(t, err)
 }
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sites.csv")
+	snapshot := filepath.Join(dir, "sites.applied.csv")
+	content := "Hostname,Port,EntityName,IP\nexample.com,22,ExampleEntity,192.168.1.1\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	sites, err := Snapshot(path, snapshot)
+	require.NoError(t, err)
+	assert.Len(t, sites, 1)
+
+	// A file that does not read leaves the snapshot alone
+	require.NoError(t, os.WriteFile(path, []byte("Hostname,Port,EntityName,IP\nexample.com,ssh,ExampleEntity,\n"), 0644))
+	_, err = Snapshot(path, snapshot)
+	assert.ErrorContains(t, err, "invalid port value")
+
+	data, err := os.ReadFile(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestClientsColumn(t *testing.T) {
+	content := `Hostname,Port,EntityName,IP,Clients
+example.com,22,ExampleEntity,192.168.1.1,LCAPP172; LCAPP173
+testsite.com,443,TestEntity,192.168.1.2,`
+
+	path := filepath.Join(t.TempDir(), "sites.csv")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	var sites Sites
+	require.NoError(t, sites.ReadFromCSV(path))
+	require.Len(t, sites, 2)
+	assert.Equal(t, []string{"LCAPP172", "LCAPP173"}, sites[0].Clients)
+	assert.Nil(t, sites[1].Clients)
+
+	// Written files keep the column after the change details
+	require.NoError(t, sites.WriteToCSV(path))
+
+	var readSites Sites
+	require.NoError(t, readSites.ReadFromCSV(path))
+	assert.Equal(t, sites[0].Clients, readSites[0].Clients)
+}
+
+func TestTagsAndOwnersColumns(t *testing.T) {
+	content := `Hostname,Port,EntityName,IP,Owners,Tags
+example.com,22,ExampleEntity,192.168.1.1,alice@example.com; bob@example.com,pci;payments
+testsite.com,443,TestEntity,192.168.1.2,,`
+
+	path := filepath.Join(t.TempDir(), "sites.csv")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	var sites Sites
+	require.NoError(t, sites.ReadFromCSV(path))
+	require.Len(t, sites, 2)
+	assert.Equal(t, []string{"pci", "payments"}, sites[0].Tags)
+	assert.Equal(t, []string{"alice@example.com", "bob@example.com"}, sites[0].Owners)
+	assert.Nil(t, sites[1].Tags)
+	assert.Nil(t, sites[1].Owners)
+
+	require.NoError(t, sites.WriteToCSV(path))
+
+	var readSites Sites
+	require.NoError(t, readSites.ReadFromCSV(path))
+	assert.Equal(t, sites[0].Tags, readSites[0].Tags)
+	assert.Equal(t, sites[0].Owners, readSites[0].Owners)
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Name() string { return "hook" }
+
+func (failingNotifier) Subscribes(event.Kind) bool { return true }
+
+func (failingNotifier) Notify(context.Context, *event.ChangeEvent) error {
+	return notification.Permanent(errors.New("exit status 1"))
+}
+
+func TestNotifyStoresAndQueuesEvent(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "digger.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	rules, err := policy.New(config.PolicyConfig{})
+	require.NoError(t, err)
+	notifier := notification.NewDispatcher([]notification.Notifier{failingNotifier{}})
+
+	notify(db, rules, notifier, outbox.DefaultPolicy, &event.ChangeEvent{
+		SiteID:             "example.com",
+		Hostname:           "example.com",
+		Kind:               event.KindLookupFailed,
+		Error:              "no such host",
+		Time:               time.Now(),
+		NotificationStatus: event.StatusPending,
+	})
+
+	events, err := store.ListEvents(db)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, event.KindLookupFailed, events[0].Kind)
+	require.Len(t, events[0].Deliveries, 1)
+	assert.Equal(t, "hook", events[0].Deliveries[0].Backend)
+	assert.Contains(t, events[0].Deliveries[0].Error, "exit status 1")
+
+	entries, err := store.ListOutbox(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, events[0].ID, entries[0].EventID)
+}
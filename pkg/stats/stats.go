@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+)
+
+// Duration prints rounded for people and marshals to JSON as seconds.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	if d == 0 {
+		return "-"
+	}
+	return time.Duration(d).Round(time.Minute).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Seconds())
+}
+
+// Churn describes how often a site or entity changes address.
+type Churn struct {
+	Name            string    `json:"name"`
+	Changes         int       `json:"changes"`
+	MeanInterval    Duration  `json:"mean_interval_seconds"`
+	MedianInterval  Duration  `json:"median_interval_seconds"`
+	DistinctIPs     int       `json:"distinct_ips"`
+	LastChange      time.Time `json:"last_change"`
+	SinceLastChange Duration  `json:"since_last_change_seconds"`
+}
+
+type Summary struct {
+	Sites    []Churn `json:"sites"`
+	Entities []Churn `json:"entities"`
+}
+
+// Compute calculates churn per site and per entity from change events.
+func Compute(events []event.ChangeEvent, now time.Time) Summary {
+	bySite := make(map[string][]event.ChangeEvent)
+	byEntity := make(map[string][]event.ChangeEvent)
+	for _, ev := range events {
+		bySite[ev.Hostname] = append(bySite[ev.Hostname], ev)
+		byEntity[ev.EntityName] = append(byEntity[ev.EntityName], ev)
+	}
+
+	return Summary{
+		Sites:    computeGroups(bySite, now),
+		Entities: computeGroups(byEntity, now),
+	}
+}
+
+func computeGroups(groups map[string][]event.ChangeEvent, now time.Time) []Churn {
+	out := make([]Churn, 0, len(groups))
+	for name, events := range groups {
+		out = append(out, compute(name, events, now))
+	}
+
+	// Busiest first, then by name
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Changes != out[j].Changes {
+			return out[i].Changes > out[j].Changes
+		}
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+func compute(name string, events []event.ChangeEvent, now time.Time) Churn {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	c := Churn{
+		Name:    name,
+		Changes: len(events),
+	}
+
+	ips := make(map[string]bool)
+	for _, ev := range events {
+		for _, ip := range ev.OldIPs {
+			ips[ip] = true
+		}
+		for _, ip := range ev.NewIPs {
+			ips[ip] = true
+		}
+	}
+	c.DistinctIPs = len(ips)
+
+	if len(events) > 0 {
+		c.LastChange = events[len(events)-1].Time
+		c.SinceLastChange = Duration(now.Sub(c.LastChange))
+	}
+
+	if len(events) < 2 {
+		return c
+	}
+
+	intervals := make([]time.Duration, 0, len(events)-1)
+	var total time.Duration
+	for i := 1; i < len(events); i++ {
+		d := events[i].Time.Sub(events[i-1].Time)
+		intervals = append(intervals, d)
+		total += d
+	}
+
+	c.MeanInterval = Duration(total / time.Duration(len(intervals)))
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	mid := len(intervals) / 2
+	if len(intervals)%2 == 0 {
+		c.MedianInterval = Duration((intervals[mid-1] + intervals[mid]) / 2)
+	} else {
+		c.MedianInterval = Duration(intervals[mid])
+	}
+
+	return c
+}
+
+// Write renders the summary as "table" or "json".
+func Write(w io.Writer, s Summary, format string) error {
+	switch format {
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		writeTable(tw, "SITE", s.Sites)
+		fmt.Fprintln(tw)
+		writeTable(tw, "ENTITY", s.Entities)
+		return tw.Flush()
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+
+	return fmt.Errorf("unknown stats format %q, expected table or json", format)
+}
+
+func writeTable(w io.Writer, heading string, rows []Churn) {
+	fmt.Fprintln(w, strings.Join([]string{heading, "CHANGES", "MEAN INTERVAL", "MEDIAN INTERVAL", "DISTINCT IPS", "SINCE LAST CHANGE"}, "\t"))
+	for _, c := range rows {
+		fmt.Fprintln(w, strings.Join([]string{
+			c.Name,
+			strconv.Itoa(c.Changes),
+			c.MeanInterval.String(),
+			c.MedianInterval.String(),
+			strconv.Itoa(c.DistinctIPs),
+			c.SinceLastChange.String(),
+		}, "\t"))
+	}
+}
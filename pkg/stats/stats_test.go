@@ -0,0 +1,76 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompute(t *testing.T) {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	now := base.Add(10 * 24 * time.Hour)
+
+	events := []event.ChangeEvent{
+		{Hostname: "a.example.com", EntityName: "Alpha", Time: base.Add(7 * time.Hour), OldIPs: []string{"1.1.1.3"}, NewIPs: []string{"1.1.1.1"}},
+		{Hostname: "a.example.com", EntityName: "Alpha", Time: base, OldIPs: []string{"1.1.1.1"}, NewIPs: []string{"1.1.1.2"}},
+		{Hostname: "a.example.com", EntityName: "Alpha", Time: base.Add(time.Hour), OldIPs: []string{"1.1.1.2"}, NewIPs: []string{"1.1.1.3"}},
+		{Hostname: "b.example.com", EntityName: "Alpha", Time: base.Add(2 * time.Hour), OldIPs: []string{"2.2.2.2"}, NewIPs: []string{"2.2.2.3"}},
+		{Hostname: "c.example.com", EntityName: "Gamma", Time: base.Add(24 * time.Hour), OldIPs: []string{"3.3.3.3"}, NewIPs: []string{"3.3.3.4"}},
+	}
+
+	s := Compute(events, now)
+
+	require.Len(t, s.Sites, 3)
+	a := s.Sites[0]
+	assert.Equal(t, "a.example.com", a.Name)
+	assert.Equal(t, 3, a.Changes)
+	// Intervals of 1h and 6h
+	assert.Equal(t, Duration(210*time.Minute), a.MeanInterval)
+	assert.Equal(t, Duration(210*time.Minute), a.MedianInterval)
+	assert.Equal(t, 3, a.DistinctIPs)
+	assert.Equal(t, base.Add(7*time.Hour), a.LastChange)
+	assert.Equal(t, Duration(now.Sub(base.Add(7*time.Hour))), a.SinceLastChange)
+
+	c := s.Sites[2]
+	assert.Equal(t, "c.example.com", c.Name)
+	assert.Equal(t, Duration(0), c.MeanInterval)
+
+	require.Len(t, s.Entities, 2)
+	alpha := s.Entities[0]
+	assert.Equal(t, "Alpha", alpha.Name)
+	assert.Equal(t, 4, alpha.Changes)
+	// Intervals of 1h, 1h and 5h
+	assert.Equal(t, Duration(time.Hour), alpha.MedianInterval)
+	assert.Equal(t, Duration(140*time.Minute), alpha.MeanInterval)
+	assert.Equal(t, 5, alpha.DistinctIPs)
+}
+
+func TestWrite(t *testing.T) {
+	base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	s := Compute([]event.ChangeEvent{
+		{Hostname: "a.example.com", EntityName: "Alpha", Time: base},
+		{Hostname: "a.example.com", EntityName: "Alpha", Time: base.Add(90 * time.Minute)},
+	}, base.Add(2*time.Hour))
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, s, "table"))
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "SITE"))
+	assert.Contains(t, out, "ENTITY")
+	assert.Contains(t, out, "1h30m0s")
+
+	buf.Reset()
+	require.NoError(t, Write(&buf, s, "json"))
+	var decoded map[string][]map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, float64(5400), decoded["sites"][0]["mean_interval_seconds"])
+	assert.Equal(t, float64(1800), decoded["entities"][0]["since_last_change_seconds"])
+
+	assert.Error(t, Write(&buf, s, "csv"))
+}
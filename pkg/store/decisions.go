@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var DecisionsBucket = []byte("decisions")
+
+// Decision records whether the notification policy let an event through
+// and why. Held decisions keep a copy of the event so it can be released
+// later.
+type Decision struct {
+	ID          string             `json:"id"`
+	EventID     string             `json:"event_id,omitempty"`
+	Hostname    string             `json:"hostname"`
+	Kind        event.Kind         `json:"kind"`
+	Action      string             `json:"action"`
+	Reason      string             `json:"reason"`
+	Fingerprint string             `json:"fingerprint"`
+	Time        time.Time          `json:"time"`
+	Event       *event.ChangeEvent `json:"event,omitempty"`
+	Released    bool               `json:"released,omitempty"`
+}
+
+// PutDecision stores d under a new time-ordered key and sets its ID.
+func PutDecision(tx *bbolt.Tx, d *Decision) error {
+	b := tx.Bucket(DecisionsBucket)
+	if b == nil {
+		return errors.New("decisions bucket not found")
+	}
+
+	seq, err := b.NextSequence()
+	if err != nil {
+		return fmt.Errorf("failed to allocate decision sequence: %w", err)
+	}
+
+	key := ChangeKey(d.Time, seq)
+	d.ID = hex.EncodeToString(key)
+
+	return putDecision(b, key, d)
+}
+
+// UpdateDecision overwrites a previously stored decision.
+func UpdateDecision(tx *bbolt.Tx, d *Decision) error {
+	b := tx.Bucket(DecisionsBucket)
+	if b == nil {
+		return errors.New("decisions bucket not found")
+	}
+
+	key, err := hex.DecodeString(d.ID)
+	if err != nil || len(key) != changeKeyLen {
+		return fmt.Errorf("invalid decision id %q", d.ID)
+	}
+
+	return putDecision(b, key, d)
+}
+
+func putDecision(b *bbolt.Bucket, key []byte, d *Decision) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to marshal decision: %w", err)
+	}
+
+	return b.Put(key, data)
+}
+
+// ListDecisions returns the decisions made at or after since, oldest first.
+// A zero since returns every decision.
+func ListDecisions(tx *bbolt.Tx, since time.Time) ([]Decision, error) {
+	b := tx.Bucket(DecisionsBucket)
+	if b == nil {
+		return nil, errors.New("decisions bucket not found")
+	}
+
+	var decisions []Decision
+	c := b.Cursor()
+	k, v := c.First()
+	if !since.IsZero() {
+		k, v = c.Seek(ChangeKey(since, 0))
+	}
+	for ; k != nil; k, v = c.Next() {
+		var d Decision
+		err := json.Unmarshal(v, &d)
+		if err != nil {
+			logrus.Errorf("Failed to unmarshal decision for key %x: %v", k, err)
+			continue // Skip invalid entries
+		}
+
+		decisions = append(decisions, d)
+	}
+
+	return decisions, nil
+}
+
+// PruneDecisions deletes decisions made before cutoff, except holds that
+// have not been released yet. It returns how many were deleted.
+func PruneDecisions(db *bbolt.DB, cutoff time.Time) (int, error) {
+	removed := 0
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(DecisionsBucket)
+		if b == nil {
+			return errors.New("decisions bucket not found")
+		}
+
+		end := ChangeKey(cutoff, 0)
+		var stale [][]byte
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			var d Decision
+			if json.Unmarshal(v, &d) == nil && d.Event != nil && !d.Released {
+				continue
+			}
+			stale = append(stale, append([]byte(nil), k...))
+		}
+
+		for _, k := range stale {
+			err := b.Delete(k)
+			if err != nil {
+				return fmt.Errorf("failed to delete decision: %w", err)
+			}
+			removed++
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestDecisions(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	old := &Decision{Hostname: "example.com", Action: "send", Time: now.Add(-48 * time.Hour)}
+	held := &Decision{Hostname: "example.com", Action: "hold", Time: now.Add(-47 * time.Hour), Event: &event.ChangeEvent{Hostname: "example.com"}}
+	recent := &Decision{Hostname: "example.com", Action: "suppress", Time: now}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, d := range []*Decision{old, held, recent} {
+			require.NoError(t, PutDecision(tx, d))
+			assert.NotEmpty(t, d.ID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		all, err := ListDecisions(tx, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, all, 3)
+		assert.Equal(t, old.ID, all[0].ID)
+
+		since, err := ListDecisions(tx, now.Add(-time.Hour))
+		require.NoError(t, err)
+		require.Len(t, since, 1)
+		assert.Equal(t, "suppress", since[0].Action)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Unreleased holds survive pruning
+	removed, err := PruneDecisions(db, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	held.Released = true
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		return UpdateDecision(tx, held)
+	}))
+
+	removed, err = PruneDecisions(db, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		all, err := ListDecisions(tx, time.Time{})
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, recent.ID, all[0].ID)
+		return nil
+	})
+	require.NoError(t, err)
+}
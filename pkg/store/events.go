@@ -0,0 +1,154 @@
+package store
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// PutEvent stores ev in the changes bucket. The event ID is the hex encoded
+// change key, so it is assigned here if the event does not have one yet.
+func PutEvent(tx *bbolt.Tx, ev *event.ChangeEvent) error {
+	cb := tx.Bucket(ChangesBucket)
+	if cb == nil {
+		return errors.New("changes bucket not found")
+	}
+
+	seq, err := cb.NextSequence()
+	if err != nil {
+		return fmt.Errorf("failed to allocate change sequence: %w", err)
+	}
+
+	key := ChangeKey(ev.Time, seq)
+	ev.ID = hex.EncodeToString(key)
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal change event: %w", err)
+	}
+
+	err = cb.Put(key, data)
+	if err != nil {
+		return fmt.Errorf("failed to put change event: %w", err)
+	}
+
+	return indexChange(tx, ev.SiteID, key)
+}
+
+// UpdateEvent overwrites a previously stored event.
+func UpdateEvent(tx *bbolt.Tx, ev *event.ChangeEvent) error {
+	cb := tx.Bucket(ChangesBucket)
+	if cb == nil {
+		return errors.New("changes bucket not found")
+	}
+
+	key, err := hex.DecodeString(ev.ID)
+	if err != nil || len(key) != changeKeyLen {
+		return fmt.Errorf("invalid change event id %q", ev.ID)
+	}
+
+	if cb.Get(key) == nil {
+		return fmt.Errorf("change event %s not found", ev.ID)
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("failed to marshal change event: %w", err)
+	}
+
+	return cb.Put(key, data)
+}
+
+// GetEvent reads back a single event by ID.
+func GetEvent(tx *bbolt.Tx, id string) (*event.ChangeEvent, error) {
+	cb := tx.Bucket(ChangesBucket)
+	if cb == nil {
+		return nil, errors.New("changes bucket not found")
+	}
+
+	key, err := hex.DecodeString(id)
+	if err != nil || len(key) != changeKeyLen {
+		return nil, fmt.Errorf("invalid change event id %q", id)
+	}
+
+	data := cb.Get(key)
+	if data == nil {
+		return nil, fmt.Errorf("change event %s not found", id)
+	}
+
+	var ev event.ChangeEvent
+	err = json.Unmarshal(data, &ev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal change event: %w", err)
+	}
+
+	return &ev, nil
+}
+
+// ListEvents returns every stored change event in time order.
+func ListEvents(db *bbolt.DB) ([]event.ChangeEvent, error) {
+	var events []event.ChangeEvent
+	err := db.View(func(tx *bbolt.Tx) error {
+		cb := tx.Bucket(ChangesBucket)
+		if cb == nil {
+			return errors.New("changes bucket not found")
+		}
+
+		return cb.ForEach(func(k, v []byte) error {
+			var ev event.ChangeEvent
+			err := json.Unmarshal(v, &ev)
+			if err != nil {
+				logrus.Errorf("Failed to unmarshal change event for key %x: %v", k, err)
+				return nil // Skip invalid entries
+			}
+
+			events = append(events, ev)
+			return nil
+		})
+	})
+
+	return events, err
+}
+
+// LastTicket returns the most recent ticket key the named backend recorded
+// for a site, or "" if it never raised one.
+func LastTicket(db *bbolt.DB, backend, siteID string) (string, error) {
+	var ticket string
+	err := db.View(func(tx *bbolt.Tx) error {
+		cb := tx.Bucket(ChangesBucket)
+		if cb == nil {
+			return errors.New("changes bucket not found")
+		}
+
+		keys := SiteChangeKeys(tx, siteID)
+		for i := len(keys) - 1; i >= 0; i-- {
+			data := cb.Get(keys[i])
+			if data == nil {
+				continue
+			}
+
+			var ev event.ChangeEvent
+			err := json.Unmarshal(data, &ev)
+			if err != nil {
+				logrus.Errorf("Failed to unmarshal change event for key %x: %v", keys[i], err)
+				continue
+			}
+
+			for _, d := range ev.Deliveries {
+				if d.Backend == backend && d.Ticket != "" {
+					ticket = d.Ticket
+					return nil
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return ticket, err
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestPutAndUpdateEvent(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	ev := &event.ChangeEvent{
+		SiteID:             "example.com",
+		Hostname:           "example.com",
+		Port:               443,
+		Kind:               event.KindIPChanged,
+		OldIPs:             []string{"1.1.1.1"},
+		NewIPs:             []string{"2.2.2.2"},
+		Severity:           event.SeverityCritical,
+		Time:               time.Now(),
+		NotificationStatus: event.StatusPending,
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return PutEvent(tx, ev)
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, ev.ID)
+
+	ev.NotificationStatus = event.StatusSent
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return UpdateEvent(tx, ev)
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		got, err := GetEvent(tx, ev.ID)
+		require.NoError(t, err)
+		assert.Equal(t, event.StatusSent, got.NotificationStatus)
+		assert.Equal(t, []string{"2.2.2.2"}, got.NewIPs)
+		assert.Len(t, SiteChangeKeys(tx, "example.com"), 1)
+		return nil
+	})
+	require.NoError(t, err)
+
+	events, err := ListEvents(db)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestUpdateUnknownEvent(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return UpdateEvent(tx, &event.ChangeEvent{ID: "00000000000000000000000000000001"})
+	})
+	assert.Error(t, err)
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return UpdateEvent(tx, &event.ChangeEvent{ID: "not-hex"})
+	})
+	assert.Error(t, err)
+}
+
+func TestLastTicket(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	events := []*event.ChangeEvent{
+		{SiteID: "example.com", Time: now.Add(-2 * time.Hour), Deliveries: []event.Delivery{{Backend: "jira", Ticket: "NET-1"}}},
+		{SiteID: "example.com", Time: now.Add(-time.Hour), Deliveries: []event.Delivery{{Backend: "jira", Ticket: "NET-2"}, {Backend: "email"}}},
+		{SiteID: "example.com", Time: now, Deliveries: []event.Delivery{{Backend: "jira", Status: event.StatusFailed}}},
+		{SiteID: "other.com", Time: now, Deliveries: []event.Delivery{{Backend: "jira", Ticket: "NET-3"}}},
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, ev := range events {
+			if err := PutEvent(tx, ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	ticket, err := LastTicket(db, "jira", "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "NET-2", ticket)
+
+	ticket, err = LastTicket(db, "servicenow", "example.com")
+	require.NoError(t, err)
+	assert.Empty(t, ticket)
+
+	ticket, err = LastTicket(db, "jira", "unknown.com")
+	require.NoError(t, err)
+	assert.Empty(t, ticket)
+}
+
+func TestMigrateLegacySiteRecordsToEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+
+	legacy, err := bbolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	err = legacy.Update(func(tx *bbolt.Tx) error {
+		cb, err := tx.CreateBucket(ChangesBucket)
+		require.NoError(t, err)
+		return cb.Put([]byte("example.com-2024-03-01T10:00:00Z"),
+			[]byte(`{"Hostname":"example.com","Port":22,"EntityName":"Vendor","OldIP":"1.1.1.1;1.1.1.2","NewIP":"2.2.2.2"}`))
+	})
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	events, err := ListEvents(db)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	ev := events[0]
+	assert.NotEmpty(t, ev.ID)
+	assert.Equal(t, "example.com", ev.SiteID)
+	assert.Equal(t, 22, ev.Port)
+	assert.Equal(t, "Vendor", ev.EntityName)
+	assert.Equal(t, event.KindIPChanged, ev.Kind)
+	assert.Equal(t, []string{"1.1.1.1", "1.1.1.2"}, ev.OldIPs)
+	assert.Equal(t, []string{"2.2.2.2"}, ev.NewIPs)
+	assert.Equal(t, event.StatusUnknown, ev.NotificationStatus)
+	assert.Equal(t, 2024, ev.Time.Year())
+}
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bytetwiddler/digger/pkg/event"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+// Migration is one schema change. Apply runs in the transaction that sets
+// the schema version to Version, so a failed migration leaves the database
+// as it was.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(tx *bbolt.Tx) error
+}
+
+// Migrations lists every schema change in order. Each migration runs in its
+// own transaction together with the schema version bump.
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "create sites and changes buckets",
+		Apply:       createBaseBuckets,
+	},
+	{
+		Version:     2,
+		Description: "time-ordered change keys with per-site index",
+		Apply:       rekeyChanges,
+	},
+	{
+		Version:     3,
+		Description: "store changes as change events",
+		Apply:       convertChangesToEvents,
+	},
+	{
+		Version:     4,
+		Description: "create observations bucket",
+		Apply:       createObservationsBucket,
+	},
+	{
+		Version:     5,
+		Description: "create notification outbox bucket",
+		Apply:       createOutboxBucket,
+	},
+	{
+		Version:     6,
+		Description: "create notification policy decisions bucket",
+		Apply:       createDecisionsBucket,
+	},
+}
+
+// LatestVersion is the schema version a fully migrated database has.
+func LatestVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Migrate applies every pending migration to db.
+func Migrate(db *bbolt.DB) error {
+	var current int
+	var existing bool
+	err := db.View(func(tx *bbolt.Tx) error {
+		current = SchemaVersion(tx)
+		return tx.ForEach(func(_ []byte, _ *bbolt.Bucket) error {
+			existing = true
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if current > LatestVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", current, LatestVersion())
+	}
+
+	if current == LatestVersion() {
+		return nil
+	}
+
+	if existing {
+		path, err := backup(db, current)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Backed up database to %s before migrating from schema version %d", path, current)
+	}
+
+	for _, m := range Migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		err = db.Update(func(tx *bbolt.Tx) error {
+			err := m.Apply(tx)
+			if err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.Version)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		logrus.Infof("Applied database migration %d: %s", m.Version, m.Description)
+	}
+
+	return nil
+}
+
+func createBaseBuckets(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(SitesBucket)
+	if err != nil {
+		return fmt.Errorf("failed creating db sites: %w", err)
+	}
+
+	_, err = tx.CreateBucketIfNotExists(ChangesBucket)
+	if err != nil {
+		return fmt.Errorf("failed creating db changes: %w", err)
+	}
+
+	return nil
+}
+
+func createObservationsBucket(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(ObservationsBucket)
+	if err != nil {
+		return fmt.Errorf("failed creating db observations: %w", err)
+	}
+
+	return nil
+}
+
+func createOutboxBucket(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(OutboxBucket)
+	if err != nil {
+		return fmt.Errorf("failed creating db outbox: %w", err)
+	}
+
+	return nil
+}
+
+func createDecisionsBucket(tx *bbolt.Tx) error {
+	_, err := tx.CreateBucketIfNotExists(DecisionsBucket)
+	if err != nil {
+		return fmt.Errorf("failed creating db decisions: %w", err)
+	}
+
+	return nil
+}
+
+// rekeyChanges moves changes stored under "hostname-RFC3339" keys to
+// time-ordered keys and builds the per-site change index. A change whose
+// key does not parse keeps the hostname and time recorded in it, and the
+// migration fails when it has neither, rather than drop the change.
+func rekeyChanges(tx *bbolt.Tx) error {
+	type legacyChange struct {
+		hostname string
+		time     time.Time
+		data     []byte
+	}
+
+	var legacy []legacyChange
+	err := tx.Bucket(ChangesBucket).ForEach(func(k, v []byte) error {
+		hostname, t, keyErr := parseLegacyChangeKey(string(k))
+
+		// Prefer the values recorded in the record itself over the key
+		var site struct {
+			Hostname   string
+			ChangeTime time.Time
+		}
+		if json.Unmarshal(v, &site) == nil {
+			if site.Hostname != "" {
+				hostname = site.Hostname
+			}
+			if !site.ChangeTime.IsZero() {
+				t = site.ChangeTime
+			}
+		}
+		if keyErr != nil && (hostname == "" || t.IsZero()) {
+			return fmt.Errorf("unrecognised change key %q: %w", k, keyErr)
+		}
+
+		legacy = append(legacy, legacyChange{
+			hostname: hostname,
+			time:     t,
+			data:     append([]byte(nil), v...),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.DeleteBucket(ChangesBucket)
+	if err != nil {
+		return fmt.Errorf("failed to drop legacy changes bucket: %w", err)
+	}
+
+	_, err = tx.CreateBucket(ChangesBucket)
+	if err != nil {
+		return fmt.Errorf("failed creating db changes: %w", err)
+	}
+
+	for _, c := range legacy {
+		_, err = PutChange(tx, c.hostname, c.time, c.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseLegacyChangeKey splits a "hostname-RFC3339" key. Both hostnames and
+// timestamps contain '-', so the first split whose suffix parses as a
+// timestamp wins.
+func parseLegacyChangeKey(key string) (string, time.Time, error) {
+	for i := strings.Index(key, "-"); i >= 0; {
+		t, err := time.Parse(time.RFC3339, key[i+1:])
+		if err == nil {
+			return key[:i], t, nil
+		}
+
+		next := strings.Index(key[i+1:], "-")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return "", time.Time{}, fmt.Errorf("no timestamp in key")
+}
+
+// convertChangesToEvents rewrites changes that were stored as whole Site
+// records into change events, keeping their keys.
+func convertChangesToEvents(tx *bbolt.Tx) error {
+	cb := tx.Bucket(ChangesBucket)
+
+	converted := make(map[string][]byte)
+	err := cb.ForEach(func(k, v []byte) error {
+		var legacy struct {
+			ID         string `json:"id"`
+			Hostname   string
+			Port       int
+			EntityName string
+			OldIP      string
+			NewIP      string
+		}
+		err := json.Unmarshal(v, &legacy)
+		if err != nil {
+			return fmt.Errorf("unreadable change for key %x: %w", k, err)
+		}
+		if legacy.ID != "" {
+			return nil // Already an event
+		}
+
+		t, err := ChangeKeyTime(k)
+		if err != nil {
+			return err
+		}
+
+		ev := event.ChangeEvent{
+			ID:                 hex.EncodeToString(k),
+			SiteID:             legacy.Hostname,
+			Hostname:           legacy.Hostname,
+			Port:               legacy.Port,
+			EntityName:         legacy.EntityName,
+			Kind:               event.KindIPChanged,
+			OldIPs:             splitIPs(legacy.OldIP),
+			NewIPs:             splitIPs(legacy.NewIP),
+			Severity:           event.SeverityCritical,
+			Time:               t,
+			NotificationStatus: event.StatusUnknown,
+		}
+
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("failed to marshal change event: %w", err)
+		}
+
+		converted[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, data := range converted {
+		err = cb.Put([]byte(k), data)
+		if err != nil {
+			return fmt.Errorf("failed to put change event: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func splitIPs(s string) []string {
+	var ips []string
+	for _, ip := range strings.Split(s, ";") {
+		ip = strings.TrimSpace(ip)
+		if ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var ObservationsBucket = []byte("observations")
+
+// Observation is the outcome of a single lookup of a site.
+type Observation struct {
+	Hostname string        `json:"hostname"`
+	Time     time.Time     `json:"time"`
+	Answers  []string      `json:"answers,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"` // Zero when the system resolver answered
+	Resolver string        `json:"resolver"`
+	Latency  time.Duration `json:"latency"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// RetentionPolicy controls how old observations are thinned. Observations
+// younger than Raw are all kept, up to Hourly one per hour is kept, up to
+// Daily one per day is kept, and anything older is removed. A zero Daily
+// keeps daily observations forever.
+type RetentionPolicy struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+var DefaultRetention = RetentionPolicy{
+	Raw:    7 * 24 * time.Hour,
+	Hourly: 30 * 24 * time.Hour,
+	Daily:  365 * 24 * time.Hour,
+}
+
+// PutObservations stores lookup results in the per-site observation buckets.
+func PutObservations(db *bbolt.DB, observations []Observation) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		ob := tx.Bucket(ObservationsBucket)
+		if ob == nil {
+			return errors.New("observations bucket not found")
+		}
+
+		for _, o := range observations {
+			sb, err := ob.CreateBucketIfNotExists([]byte(o.Hostname))
+			if err != nil {
+				return fmt.Errorf("failed to create observation bucket for %s: %w", o.Hostname, err)
+			}
+
+			seq, err := sb.NextSequence()
+			if err != nil {
+				return fmt.Errorf("failed to allocate observation sequence: %w", err)
+			}
+
+			data, err := json.Marshal(o)
+			if err != nil {
+				return fmt.Errorf("failed to marshal observation: %w", err)
+			}
+
+			err = sb.Put(ChangeKey(o.Time, seq), data)
+			if err != nil {
+				return fmt.Errorf("failed to put observation: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ObservationAt returns the most recent observation of hostname made at or
+// before t, or nil if there is none.
+func ObservationAt(tx *bbolt.Tx, hostname string, t time.Time) (*Observation, error) {
+	ob := tx.Bucket(ObservationsBucket)
+	if ob == nil {
+		return nil, errors.New("observations bucket not found")
+	}
+
+	sb := ob.Bucket([]byte(hostname))
+	if sb == nil {
+		return nil, nil
+	}
+
+	// Seek to the first key after t and step back one
+	c := sb.Cursor()
+	k, v := c.Seek(ChangeKey(t.Add(time.Nanosecond), 0))
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil {
+		return nil, nil
+	}
+
+	var o Observation
+	err := json.Unmarshal(v, &o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal observation: %w", err)
+	}
+
+	return &o, nil
+}
+
+// ApplyRetention thins observations according to policy. Within a thinned
+// period the first observation of each hour or day is kept, as is every
+// observation whose answer differs from the one kept before it, so that no
+// change in resolution is lost. It returns the number of observations removed.
+func ApplyRetention(db *bbolt.DB, policy RetentionPolicy, now time.Time) (int, error) {
+	removed := 0
+	err := db.Update(func(tx *bbolt.Tx) error {
+		ob := tx.Bucket(ObservationsBucket)
+		if ob == nil {
+			return errors.New("observations bucket not found")
+		}
+
+		return ob.ForEach(func(hostname, _ []byte) error {
+			sb := ob.Bucket(hostname)
+			if sb == nil {
+				return nil
+			}
+
+			var stale [][]byte
+			var lastSlot time.Time
+			var lastAnswer string
+			err := sb.ForEach(func(k, v []byte) error {
+				t, err := ChangeKeyTime(k)
+				if err != nil {
+					return nil
+				}
+
+				var o Observation
+				if json.Unmarshal(v, &o) != nil {
+					logrus.Errorf("Removing unreadable observation %x for %s", k, hostname)
+					stale = append(stale, append([]byte(nil), k...))
+					return nil
+				}
+				answer := o.signature()
+
+				age := now.Sub(t)
+				var slot time.Time
+				switch {
+				case age <= policy.Raw:
+					lastAnswer = answer
+					return nil
+				case age <= policy.Hourly:
+					slot = t.Truncate(time.Hour)
+				case policy.Daily == 0 || age <= policy.Daily:
+					slot = t.Truncate(24 * time.Hour)
+				default:
+					stale = append(stale, append([]byte(nil), k...))
+					return nil
+				}
+
+				if slot.Equal(lastSlot) && answer == lastAnswer {
+					stale = append(stale, append([]byte(nil), k...))
+					return nil
+				}
+
+				lastSlot = slot
+				lastAnswer = answer
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range stale {
+				err = sb.Delete(k)
+				if err != nil {
+					return fmt.Errorf("failed to delete observation: %w", err)
+				}
+			}
+
+			removed += len(stale)
+			return nil
+		})
+	})
+
+	return removed, err
+}
+
+func (o Observation) signature() string {
+	if o.Error != "" {
+		return "error"
+	}
+	return strings.Join(o.Answers, ";")
+}
+
+// Compact rewrites the database at path into a fresh file to reclaim the
+// space freed by deleted records. The database must not be open elsewhere.
+// It returns the file size before and after.
+func Compact(path string) (int64, int64, error) {
+	before, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	src, err := bbolt.Open(path, 0o600, &bbolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	tmpPath := path + ".compact"
+	dst, err := bbolt.Open(tmpPath, 0o600, nil)
+	if err != nil {
+		src.Close()
+		return 0, 0, fmt.Errorf("failed to create compacted database: %w", err)
+	}
+
+	err = bbolt.Compact(dst, src, 64*1024)
+	src.Close()
+	dst.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, 0, fmt.Errorf("failed to compact database: %w", err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, 0, fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to stat database: %w", err)
+	}
+
+	return before.Size(), after.Size(), nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestObservationAt(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	base := time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC)
+	err = PutObservations(db, []Observation{
+		{Hostname: "example.com", Time: base, Answers: []string{"1.1.1.1"}, Resolver: "system"},
+		{Hostname: "example.com", Time: base.Add(3 * time.Hour), Answers: []string{"2.2.2.2"}, Resolver: "system"},
+		{Hostname: "example.com", Time: base.Add(6 * time.Hour), Error: "no such host", Resolver: "system"},
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		o, err := ObservationAt(tx, "example.com", base.Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Nil(t, o)
+
+		o, err = ObservationAt(tx, "example.com", base.Add(3*time.Hour))
+		require.NoError(t, err)
+		require.NotNil(t, o)
+		assert.Equal(t, []string{"2.2.2.2"}, o.Answers)
+
+		o, err = ObservationAt(tx, "example.com", base.Add(4*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2.2.2.2"}, o.Answers)
+
+		o, err = ObservationAt(tx, "example.com", base.Add(48*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, "no such host", o.Error)
+
+		o, err = ObservationAt(tx, "unknown.com", base)
+		require.NoError(t, err)
+		assert.Nil(t, o)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestApplyRetention(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var observations []Observation
+	add := func(age time.Duration, answer string) {
+		observations = append(observations, Observation{
+			Hostname: "example.com",
+			Time:     now.Add(-age),
+			Answers:  []string{answer},
+		})
+	}
+
+	// Two years old: removed
+	add(2*365*24*time.Hour, "1.1.1.1")
+	// Sixty days old, same day: one kept
+	add(60*24*time.Hour+2*time.Hour, "1.1.1.1")
+	add(60*24*time.Hour+time.Hour, "1.1.1.1")
+	// Ten days old, same hour, answer changes: both kept
+	add(10*24*time.Hour+30*time.Minute, "1.1.1.1")
+	add(10*24*time.Hour+20*time.Minute, "2.2.2.2")
+	add(10*24*time.Hour+10*time.Minute, "2.2.2.2")
+	// Recent: all kept
+	add(2*time.Hour, "2.2.2.2")
+	add(time.Hour, "2.2.2.2")
+
+	require.NoError(t, PutObservations(db, observations))
+
+	removed, err := ApplyRetention(db, DefaultRetention, now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		count := 0
+		tx.Bucket(ObservationsBucket).Bucket([]byte("example.com")).ForEach(func(_, _ []byte) error {
+			count++
+			return nil
+		})
+		assert.Equal(t, 5, count)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	var observations []Observation
+	for i := 0; i < 2000; i++ {
+		observations = append(observations, Observation{
+			Hostname: "example.com",
+			Time:     time.Now().Add(-time.Duration(i) * time.Minute),
+			Answers:  []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"},
+		})
+	}
+	require.NoError(t, PutObservations(db, observations))
+	_, err = ApplyRetention(db, RetentionPolicy{Raw: time.Hour, Hourly: 2 * time.Hour, Daily: 3 * time.Hour}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	before, after, err := Compact(path)
+	require.NoError(t, err)
+	assert.Less(t, after, before)
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	err = db.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, LatestVersion(), SchemaVersion(tx))
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/bbolt"
+)
+
+var OutboxBucket = []byte("outbox")
+
+// OutboxEntry is a notification still owed to one backend for one change
+// event. It is removed once the backend accepts the event.
+type OutboxEntry struct {
+	EventID     string    `json:"event_id"`
+	Backend     string    `json:"backend"`
+	Hostname    string    `json:"hostname"`
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Dead is set once the entry is older than the outbox max age. Dead
+	// entries are kept for inspection but not retried.
+	Dead bool `json:"dead,omitempty"`
+}
+
+// Key identifies the entry in the outbox bucket. Event IDs sort by time, so
+// entries do too.
+func (e OutboxEntry) Key() []byte {
+	return []byte(e.EventID + "/" + e.Backend)
+}
+
+func PutOutbox(tx *bbolt.Tx, e OutboxEntry) error {
+	ob := tx.Bucket(OutboxBucket)
+	if ob == nil {
+		return errors.New("outbox bucket not found")
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+
+	return ob.Put(e.Key(), data)
+}
+
+// GetOutbox returns the entry stored under key, or nil if there is none.
+func GetOutbox(tx *bbolt.Tx, key []byte) (*OutboxEntry, error) {
+	ob := tx.Bucket(OutboxBucket)
+	if ob == nil {
+		return nil, errors.New("outbox bucket not found")
+	}
+
+	data := ob.Get(key)
+	if data == nil {
+		return nil, nil
+	}
+
+	var e OutboxEntry
+	err := json.Unmarshal(data, &e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal outbox entry: %w", err)
+	}
+
+	return &e, nil
+}
+
+func DeleteOutbox(tx *bbolt.Tx, key []byte) error {
+	ob := tx.Bucket(OutboxBucket)
+	if ob == nil {
+		return errors.New("outbox bucket not found")
+	}
+
+	return ob.Delete(key)
+}
+
+// ListOutbox returns every outbox entry, oldest event first.
+func ListOutbox(db *bbolt.DB) ([]OutboxEntry, error) {
+	var entries []OutboxEntry
+	err := db.View(func(tx *bbolt.Tx) error {
+		ob := tx.Bucket(OutboxBucket)
+		if ob == nil {
+			return errors.New("outbox bucket not found")
+		}
+
+		return ob.ForEach(func(k, v []byte) error {
+			var e OutboxEntry
+			err := json.Unmarshal(v, &e)
+			if err != nil {
+				logrus.Errorf("Failed to unmarshal outbox entry for key %s: %v", k, err)
+				return nil // Skip invalid entries
+			}
+
+			entries = append(entries, e)
+			return nil
+		})
+	})
+
+	return entries, err
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestOutbox(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	entry := OutboxEntry{EventID: "0001", Backend: "email", Hostname: "example.com", Created: now, NextAttempt: now}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		require.NoError(t, PutOutbox(tx, entry))
+		return PutOutbox(tx, OutboxEntry{EventID: "0000", Backend: "webhook"})
+	})
+	require.NoError(t, err)
+
+	entries, err := ListOutbox(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "0000", entries[0].EventID)
+	assert.Equal(t, entry, entries[1])
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		got, err := GetOutbox(tx, []byte("0001/email"))
+		require.NoError(t, err)
+		assert.Equal(t, &entry, got)
+
+		require.NoError(t, DeleteOutbox(tx, entry.Key()))
+
+		got, err = GetOutbox(tx, entry.Key())
+		require.NoError(t, err)
+		assert.Nil(t, got)
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Bucket names used in sites.db.
+var (
+	MetaBucket          = []byte("meta")
+	SitesBucket         = []byte("sites")
+	ChangesBucket       = []byte("changes")
+	ChangesBySiteBucket = []byte("changes_by_site")
+)
+
+var schemaVersionKey = []byte("schema_version")
+
+// changeKeyLen is the length of a change key: an 8 byte big-endian
+// timestamp in nanoseconds followed by an 8 byte bucket sequence number.
+const changeKeyLen = 16
+
+// Open opens the bbolt database at path and upgrades its schema to the
+// latest version. A backup of the file is taken before any migration runs
+// against an existing database.
+func Open(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	err = Migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// OpenCopy opens a scratch copy of the database at path, so a run can be
+// tried out without changing it. The original is opened read-only and may
+// be missing, in which case the copy starts empty. Call the returned
+// function to close and delete the copy.
+func OpenCopy(path string) (*bbolt.DB, func(), error) {
+	dir, err := os.MkdirTemp("", "digger-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	copyPath := filepath.Join(dir, filepath.Base(path))
+
+	_, err = os.Stat(path)
+	if err == nil {
+		src, err := bbolt.Open(path, 0o600, &bbolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, fmt.Errorf("failed to open database: %w", err)
+		}
+
+		err = src.View(func(tx *bbolt.Tx) error {
+			return tx.CopyFile(copyPath, 0o600)
+		})
+		src.Close()
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, nil, fmt.Errorf("failed to copy database: %w", err)
+		}
+	}
+
+	db, err := Open(copyPath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// SchemaVersion returns the schema version recorded in the meta bucket, or
+// 0 if the database predates schema versioning.
+func SchemaVersion(tx *bbolt.Tx) int {
+	mb := tx.Bucket(MetaBucket)
+	if mb == nil {
+		return 0
+	}
+
+	v, err := strconv.Atoi(string(mb.Get(schemaVersionKey)))
+	if err != nil {
+		return 0
+	}
+
+	return v
+}
+
+func setSchemaVersion(tx *bbolt.Tx, version int) error {
+	mb, err := tx.CreateBucketIfNotExists(MetaBucket)
+	if err != nil {
+		return fmt.Errorf("failed to create meta bucket: %w", err)
+	}
+
+	return mb.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
+
+// ChangeKey builds a time-ordered, collision-free key for the changes bucket.
+func ChangeKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, changeKeyLen)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// ChangeKeyTime returns the timestamp encoded in a change key.
+func ChangeKeyTime(key []byte) (time.Time, error) {
+	if len(key) != changeKeyLen {
+		return time.Time{}, fmt.Errorf("invalid change key length %d", len(key))
+	}
+
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8]))), nil
+}
+
+// PutChange stores a change record for hostname and adds it to the per-site
+// index. It returns the key the record was stored under.
+func PutChange(tx *bbolt.Tx, hostname string, t time.Time, data []byte) ([]byte, error) {
+	cb := tx.Bucket(ChangesBucket)
+	if cb == nil {
+		return nil, errors.New("changes bucket not found")
+	}
+
+	seq, err := cb.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate change sequence: %w", err)
+	}
+
+	key := ChangeKey(t, seq)
+	err = cb.Put(key, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put change: %w", err)
+	}
+
+	err = indexChange(tx, hostname, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// SiteChangeKeys returns the keys of every change recorded for hostname, in
+// time order.
+func SiteChangeKeys(tx *bbolt.Tx, hostname string) [][]byte {
+	ib := tx.Bucket(ChangesBySiteBucket)
+	if ib == nil {
+		return nil
+	}
+
+	sb := ib.Bucket([]byte(hostname))
+	if sb == nil {
+		return nil
+	}
+
+	var keys [][]byte
+	sb.ForEach(func(k, _ []byte) error {
+		keys = append(keys, append([]byte(nil), k...))
+		return nil
+	})
+
+	return keys
+}
+
+func indexChange(tx *bbolt.Tx, hostname string, key []byte) error {
+	ib, err := tx.CreateBucketIfNotExists(ChangesBySiteBucket)
+	if err != nil {
+		return fmt.Errorf("failed to create change index bucket: %w", err)
+	}
+
+	sb, err := ib.CreateBucketIfNotExists([]byte(hostname))
+	if err != nil {
+		return fmt.Errorf("failed to create change index for %s: %w", hostname, err)
+	}
+
+	return sb.Put(key, []byte{})
+}
+
+// backup copies the current database file next to the original before a
+// migration touches it.
+func backup(db *bbolt.DB, from int) (string, error) {
+	path := fmt.Sprintf("%s.v%d-%s.bak", db.Path(), from, time.Now().Format("20060102T150405"))
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(path, 0o600)
+	})
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	return path, nil
+}
+
+// GetMeta reads a value from the meta bucket, returning nil if it is unset.
+func GetMeta(tx *bbolt.Tx, key string) []byte {
+	mb := tx.Bucket(MetaBucket)
+	if mb == nil {
+		return nil
+	}
+
+	v := mb.Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+
+	return append([]byte(nil), v...)
+}
+
+// PutMeta stores a value in the meta bucket.
+func PutMeta(tx *bbolt.Tx, key string, value []byte) error {
+	mb, err := tx.CreateBucketIfNotExists(MetaBucket)
+	if err != nil {
+		return fmt.Errorf("failed to create meta bucket: %w", err)
+	}
+
+	return mb.Put([]byte(key), value)
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestOpenNewDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, LatestVersion(), SchemaVersion(tx))
+		assert.NotNil(t, tx.Bucket(SitesBucket))
+		assert.NotNil(t, tx.Bucket(ChangesBucket))
+		return nil
+	})
+	require.NoError(t, err)
+
+	backups, err := filepath.Glob(path + ".*.bak")
+	require.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestOpenCopy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+
+	// A missing database gives an empty copy and is not created
+	scratch, cleanup, err := OpenCopy(path)
+	require.NoError(t, err)
+	cleanup()
+	assert.NoFileExists(t, path)
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		return PutMeta(tx, "marker", []byte("original"))
+	}))
+	require.NoError(t, db.Close())
+
+	scratch, cleanup, err = OpenCopy(path)
+	require.NoError(t, err)
+	copyPath := scratch.Path()
+	require.NoError(t, scratch.Update(func(tx *bbolt.Tx) error {
+		assert.Equal(t, []byte("original"), GetMeta(tx, "marker"))
+		return PutMeta(tx, "marker", []byte("changed"))
+	}))
+	cleanup()
+	assert.NoFileExists(t, copyPath)
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, []byte("original"), GetMeta(tx, "marker"))
+		return nil
+	}))
+}
+
+func TestMigrateLegacyChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+
+	// Build a database in the pre-versioning layout
+	legacy, err := bbolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	err = legacy.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket(SitesBucket)
+		require.NoError(t, err)
+		cb, err := tx.CreateBucket(ChangesBucket)
+		require.NoError(t, err)
+
+		require.NoError(t, cb.Put([]byte("my-host.example.com-2024-03-01T10:00:00-07:00"),
+			[]byte(`{"Hostname":"my-host.example.com","OldIP":"1.1.1.1","NewIP":"2.2.2.2"}`)))
+		require.NoError(t, cb.Put([]byte("a.example.com-2024-03-02T10:00:00Z"),
+			[]byte(`{"Hostname":"a.example.com","OldIP":"3.3.3.3","NewIP":"4.4.4.4"}`)))
+		require.NoError(t, cb.Put([]byte("a.example.com-2024-01-01T10:00:00Z"),
+			[]byte(`{"Hostname":"a.example.com","OldIP":"5.5.5.5","NewIP":"3.3.3.3"}`)))
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, legacy.Close())
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	backups, err := filepath.Glob(path + ".v0-*.bak")
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+
+	err = db.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, LatestVersion(), SchemaVersion(tx))
+
+		var times []time.Time
+		var values [][]byte
+		err := tx.Bucket(ChangesBucket).ForEach(func(k, v []byte) error {
+			ts, err := ChangeKeyTime(k)
+			require.NoError(t, err)
+			times = append(times, ts)
+			values = append(values, v)
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.Len(t, times, 3)
+		assert.True(t, times[0].Before(times[1]))
+		assert.True(t, times[1].Before(times[2]))
+		assert.Contains(t, string(values[0]), "5.5.5.5")
+		assert.Contains(t, string(values[1]), "my-host.example.com")
+
+		assert.Len(t, SiteChangeKeys(tx, "a.example.com"), 2)
+		assert.Len(t, SiteChangeKeys(tx, "my-host.example.com"), 1)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestMigrateKeepsUnrecognisedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+	writeLegacy := func(key, value string) {
+		legacy, err := bbolt.Open(path, 0o600, nil)
+		require.NoError(t, err)
+		err = legacy.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(SitesBucket)
+			require.NoError(t, err)
+			cb, err := tx.CreateBucketIfNotExists(ChangesBucket)
+			require.NoError(t, err)
+			return cb.Put([]byte(key), []byte(value))
+		})
+		require.NoError(t, err)
+		require.NoError(t, legacy.Close())
+	}
+
+	// The record itself says which site and when
+	writeLegacy("renamed-change", `{"Hostname":"a.example.com","ChangeTime":"2024-03-01T10:00:00Z","NewIP":"2.2.2.2"}`)
+	db, err := Open(path)
+	require.NoError(t, err)
+	err = db.View(func(tx *bbolt.Tx) error {
+		assert.Len(t, SiteChangeKeys(tx, "a.example.com"), 1)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Nothing to go on: the migration fails and the change stays put
+	path = filepath.Join(t.TempDir(), "sites.db")
+	writeLegacy("mystery", `not json`)
+	_, err = Open(path)
+	assert.ErrorContains(t, err, `unrecognised change key "mystery"`)
+
+	legacy, err := bbolt.Open(path, 0o600, nil)
+	require.NoError(t, err)
+	defer legacy.Close()
+	err = legacy.View(func(tx *bbolt.Tx) error {
+		assert.Equal(t, []byte("not json"), tx.Bucket(ChangesBucket).Get([]byte("mystery")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestPutChangeSameInstant(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "sites.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	var first, second []byte
+	err = db.Update(func(tx *bbolt.Tx) error {
+		first, err = PutChange(tx, "example.com", now, []byte("one"))
+		require.NoError(t, err)
+		second, err = PutChange(tx, "example.com", now, []byte("two"))
+		require.NoError(t, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.False(t, bytes.Equal(first, second))
+	assert.Equal(t, -1, bytes.Compare(first, second))
+}
+
+func TestParseLegacyChangeKey(t *testing.T) {
+	hostname, ts, err := parseLegacyChangeKey("sftp-eu-west.vendor.com-2024-05-06T07:08:09+02:00")
+	require.NoError(t, err)
+	assert.Equal(t, "sftp-eu-west.vendor.com", hostname)
+	assert.Equal(t, 2024, ts.Year())
+
+	_, _, err = parseLegacyChangeKey("no-timestamp-here")
+	assert.Error(t, err)
+}
+
+func TestOpenNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sites.db")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return setSchemaVersion(tx, LatestVersion()+1)
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = Open(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,9 @@
+// Package templates holds the templates shipped in the templates directory
+// next to the binary. They are embedded as well, so a template that is not
+// on disk falls back to the built-in copy.
+package templates
+
+import "embed"
+
+//go:embed *.html *.json *.txt
+var FS embed.FS